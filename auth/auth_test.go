@@ -0,0 +1,158 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+func TestRoles(t *testing.T) {
+	cases := []struct {
+		role     string
+		command  string
+		data     []string
+		expected bool
+	}{
+		{RoleViewer, "Scoreboard.Reset", nil, false},
+		{RoleViewer, "Set", []string{"Settings.View.CurrentView", "x"}, false},
+		{RoleOperator, "Scoreboard.Reset", nil, true},
+		{RoleOperator, "Set", []string{"Settings.View.CurrentView", "x"}, true},
+		{RoleOperator, "Leagues.DeleteTeam", []string{"l", "t"}, false},
+		{RoleOperator, "Set", []string{"Auth.Device(d).Role", "Admin"}, false},
+		{RolePenaltyTracker, "Scoreboard.Team(1).AddPenalty", []string{"s", "X"}, true},
+		{RolePenaltyTracker, "Scoreboard.StartJam", nil, false},
+		{RoleLineupTracker, "Set", []string{"Scoreboard.Team(2).Skater(s).Position", "Jammer"}, true},
+		{RoleLineupTracker, "Set", []string{"Scoreboard.Team(2).Score", "5"}, false},
+		{RoleAdmin, "Auth.RevokeDevice", []string{"d"}, true},
+	}
+	for _, c := range cases {
+		err := FindRole(c.role).CheckCommand(c.command, c.data)
+		if (err == nil) != c.expected {
+			t.Errorf("%v %v %v: expected allowed %v got %v", c.role, c.command, c.data, c.expected, err)
+		}
+	}
+
+	isPII := func(k string) bool { return statemanager.PatternMatch("Leagues.Person(*).LegalName", k) }
+	v := "v"
+	updates := map[string]*string{"Leagues.Person(p).Name": &v, "Leagues.Person(p).LegalName": &v, "Auth.Device(d).Role": &v}
+	if f := FindRole(RoleViewer).Filter(updates, isPII); len(f) != 1 || f["Leagues.Person(p).Name"] == nil {
+		t.Errorf("Viewer can read private keys: %v", f)
+	}
+	if f := FindRole(RoleAdmin).Filter(updates, isPII); len(f) != 3 {
+		t.Errorf("Admin cannot read all keys: %v", f)
+	}
+	if FindRole(RoleAdmin).CanRead("Auth.Device(d).TokenHash") {
+		t.Error("Admin can read token hashes")
+	}
+}
+
+func TestPairing(t *testing.T) {
+	s := statemanager.New()
+	Initialize(s)
+
+	s.Lock()
+	pin, err := createPairing([]string{RoleOperator, "Desk"})
+	s.Unlock()
+	if err != nil {
+		t.Fatalf("createPairing: %v", err)
+	}
+
+	if _, _, err := Pair("not a pin", "desk"); err != errInvalidPIN {
+		t.Errorf("Pair with a wrong PIN: expected %v got %v", errInvalidPIN, err)
+	}
+	id, token, err := Pair(pin.(string), "desk")
+	if err != nil {
+		t.Fatalf("Pair: %v", err)
+	}
+	if _, _, err := Pair(pin.(string), "desk"); err != errInvalidPIN {
+		t.Errorf("PIN could be used twice")
+	}
+	if d, err := Authenticate(token); err != nil || d != id || DeviceRole(d).Name != RoleOperator {
+		t.Errorf("Authenticate: got %v (%v), %v", d, DeviceRole(d).Name, err)
+	}
+
+	if err := s.Command("Auth.RevokeDevice", []string{id}); err != nil {
+		t.Errorf("RevokeDevice: %v", err)
+	}
+	if _, err := Authenticate(token); err != errInvalidToken || DeviceRole(id).Name != RoleViewer {
+		t.Errorf("Revoked device can still authenticate")
+	}
+}
+
+func TestPairLockout(t *testing.T) {
+	s := statemanager.New()
+	Initialize(s)
+
+	s.Lock()
+	pin, _ := createPairing([]string{RoleOperator, "Desk"})
+	s.Unlock()
+
+	for i := 0; i < maxPairFailures; i++ {
+		if _, _, err := Pair("not a pin", "attacker"); err != errInvalidPIN {
+			t.Fatalf("Wrong PIN %v: got %v", i, err)
+		}
+	}
+	if _, _, err := Pair(pin.(string), "attacker"); err != errPairLockout {
+		t.Errorf("Locked out client paired: %v", err)
+	}
+	if _, _, err := Pair(pin.(string), "desk"); err != nil {
+		t.Errorf("Other client could not pair: %v", err)
+	}
+}
+
+func TestBootstrapPINExpires(t *testing.T) {
+	s := statemanager.New()
+	Initialize(s)
+	defer func(d time.Duration) { BootstrapTimeout = d }(BootstrapTimeout)
+	BootstrapTimeout = -time.Second
+
+	pin, err := BootstrapPIN()
+	if err != nil || pin == "" {
+		t.Fatalf("BootstrapPIN: %q, %v", pin, err)
+	}
+	if _, _, err := Pair(pin, "desk"); err != errInvalidPIN {
+		t.Errorf("Expired bootstrap PIN: got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := statemanager.New()
+	Initialize(s)
+
+	s.Lock()
+	pin, _ := createPairing([]string{RoleOperator, "Desk"})
+	s.Unlock()
+	_, token, err := Pair(pin.(string), "desk")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := Handler(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("ok")) }, StaffRoles...)
+	for _, c := range []struct {
+		header, query string
+		code          int
+	}{
+		{"", "", http.StatusForbidden},
+		{"Bearer wrong", "", http.StatusForbidden},
+		{"Bearer " + token, "", http.StatusOK},
+		{"", "?token=" + token, http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", "/schema"+c.query, nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != c.code {
+			t.Errorf("%q %q: expected %v got %v", c.header, c.query, c.code, w.Code)
+		}
+	}
+}
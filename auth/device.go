@@ -0,0 +1,298 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/rollerderby/crg/statemanager"
+	"github.com/satori/go.uuid"
+)
+
+type device struct {
+	id        string
+	name      string
+	role      string
+	tokenHash string
+	stateIDs  map[string]string
+}
+
+// pairing lets the device that enters its PIN become a device with role
+type pairing struct {
+	name    string
+	role    string
+	expires time.Time
+}
+
+// pairFailure counts the wrong PINs entered by a client
+type pairFailure struct {
+	count int
+	until time.Time
+}
+
+// PairingTimeout is how long a PIN from Auth.CreatePairing can be used for
+var PairingTimeout = 10 * time.Minute
+
+// BootstrapTimeout is how long a PIN from BootstrapPIN can be used for
+var BootstrapTimeout = time.Hour
+
+// PairLockout is how long a client that entered maxPairFailures wrong PINs
+// in a row can't pair for
+var PairLockout = 10 * time.Minute
+
+// maxPairFailures is how many wrong PINs a client may enter in a row
+// before it is locked out
+const maxPairFailures = 10
+
+// sm is the StateManager the auth subsystem is bound to
+var sm *statemanager.StateManager
+
+var devices = make(map[string]*device)
+var pairings = make(map[string]*pairing)
+var pairFailures = make(map[string]*pairFailure)
+
+var errDeviceNotFound = errors.New("Device Not Found")
+var errRoleNotFound = errors.New("Role Not Found")
+var errInvalidPIN = errors.New("Invalid PIN")
+var errInvalidToken = errors.New("Invalid Token")
+var errPairLockout = errors.New("Too Many Wrong PINs, Try Again Later")
+
+// Initialize the auth subsystem, binding it to the StateManager s
+func Initialize(s *statemanager.StateManager) {
+	sm = s
+
+	sm.RegisterPatternUpdaterString("Auth.Device(*).Name", 0, deviceSetName)
+	sm.RegisterPatternUpdaterString("Auth.Device(*).Role", 0, deviceSetRole)
+	sm.RegisterPatternUpdaterString("Auth.Device(*).TokenHash", 0, deviceSetTokenHash)
+	sm.RegisterKey("Auth.Device(*).ID", statemanager.TypeString, "ID of the paired device")
+	sm.RegisterKey("Auth.Device(*).Name", statemanager.TypeString, "Name of the paired device").MaxLength(64)
+	sm.RegisterKey("Auth.Device(*).Role", statemanager.TypeString, "Role of the paired device").Enum(roleNames...)
+	sm.RegisterKey("Auth.Device(*).TokenHash", statemanager.TypeString, "SHA-256 of the token the device authenticates with")
+
+	sm.RegisterCommandResult("Auth.CreatePairing", createPairing)
+	sm.RegisterCommand("Auth.RevokeDevice", revokeDevice)
+	sm.DescribeCommand("Auth.CreatePairing", "Creates a PIN a device can pair with, returning the PIN",
+		statemanager.Arg{Name: "Role", Type: statemanager.TypeString},
+		statemanager.Arg{Name: "Name", Type: statemanager.TypeString, Description: "Name of the device"})
+	sm.DescribeCommand("Auth.RevokeDevice", "Removes a paired device, it becomes a viewer",
+		statemanager.Arg{Name: "DeviceID", Type: statemanager.TypeString})
+}
+
+func findDevice(k string) *device {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 1 {
+		return nil
+	}
+	d, ok := devices[ids[0]]
+	if !ok {
+		d = blankDevice(ids[0])
+	}
+	return d
+}
+
+func blankDevice(id string) *device {
+	d := &device{id: id, role: RoleViewer, stateIDs: make(map[string]string)}
+	base := "Auth.Device(" + id + ")"
+	d.stateIDs["base"] = base
+	d.stateIDs["id"] = base + ".ID"
+	d.stateIDs["name"] = base + ".Name"
+	d.stateIDs["role"] = base + ".Role"
+	d.stateIDs["tokenHash"] = base + ".TokenHash"
+
+	sm.StateUpdateString(d.stateIDs["id"], id)
+	devices[id] = d
+	return d
+}
+
+func (d *device) setName(v string) {
+	d.name = v
+	sm.StateUpdateString(d.stateIDs["name"], v)
+}
+
+func (d *device) setRole(v string) {
+	d.role = v
+	sm.StateUpdateString(d.stateIDs["role"], v)
+}
+
+func (d *device) setTokenHash(v string) {
+	d.tokenHash = v
+	sm.StateUpdateString(d.stateIDs["tokenHash"], v)
+}
+
+func deviceSetName(k, v string) error {
+	if d := findDevice(k); d != nil {
+		d.setName(v)
+		return nil
+	}
+	return errDeviceNotFound
+}
+func deviceSetRole(k, v string) error {
+	if FindRole(v) == nil {
+		return errRoleNotFound
+	}
+	if d := findDevice(k); d != nil {
+		d.setRole(v)
+		return nil
+	}
+	return errDeviceNotFound
+}
+func deviceSetTokenHash(k, v string) error {
+	if d := findDevice(k); d != nil {
+		d.setTokenHash(v)
+		return nil
+	}
+	return errDeviceNotFound
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// newPIN returns a random 6 digit PIN that isn't pending
+func newPIN() (string, error) {
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return "", err
+		}
+		pin := fmt.Sprintf("%06d", n.Int64())
+		if _, ok := pairings[pin]; !ok {
+			return pin, nil
+		}
+	}
+}
+
+// createPairing is the Auth.CreatePairing command
+// data: [role, name]
+func createPairing(data []string) (interface{}, error) {
+	if FindRole(data[0]) == nil {
+		return nil, errRoleNotFound
+	}
+	pin, err := newPIN()
+	if err != nil {
+		return nil, err
+	}
+	pairings[pin] = &pairing{name: data[1], role: data[0], expires: time.Now().Add(PairingTimeout)}
+	log.Printf("auth: Created pairing for %v as %v", data[1], data[0])
+	return pin, nil
+}
+
+// revokeDevice is the Auth.RevokeDevice command
+// data: [deviceID]
+func revokeDevice(data []string) error {
+	d, ok := devices[data[0]]
+	if !ok {
+		return errDeviceNotFound
+	}
+	delete(devices, d.id)
+	sm.StateDelete(d.stateIDs["base"])
+	log.Printf("auth: Revoked %v (%v)", d.name, d.id)
+	return nil
+}
+
+// BootstrapPIN returns a PIN to pair the first admin device with, or ""
+// if an admin device has already been paired.  The PIN can be used for
+// BootstrapTimeout.
+func BootstrapPIN() (string, error) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	for _, d := range devices {
+		if d.role == RoleAdmin {
+			return "", nil
+		}
+	}
+	pin, err := newPIN()
+	if err != nil {
+		return "", err
+	}
+	pairings[pin] = &pairing{name: "Admin", role: RoleAdmin, expires: time.Now().Add(BootstrapTimeout)}
+	return pin, nil
+}
+
+// Pair pairs a new device using a PIN from Auth.CreatePairing or
+// BootstrapPIN, returning the ID of the device and the token it
+// authenticates with from now on.  client identifies where the PIN was
+// entered from (such as the remote IP address) so a client entering wrong
+// PINs is locked out without affecting the others.
+func Pair(pin, client string) (id, token string, err error) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	f := pairFailures[client]
+	if f != nil && time.Now().Before(f.until) {
+		return "", "", errPairLockout
+	}
+	p, ok := pairings[pin]
+	if !ok || time.Now().After(p.expires) {
+		delete(pairings, pin)
+		if f == nil || !f.until.IsZero() {
+			f = &pairFailure{}
+			pairFailures[client] = f
+		}
+		f.count++
+		if f.count >= maxPairFailures {
+			log.Printf("auth: %v entered %v wrong PINs, locked out for %v", client, f.count, PairLockout)
+			f.until = time.Now().Add(PairLockout)
+		}
+		return "", "", errInvalidPIN
+	}
+	delete(pairings, pin)
+	delete(pairFailures, client)
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+
+	d := blankDevice(uuid.NewV4().String())
+	d.setName(p.name)
+	d.setRole(p.role)
+	d.setTokenHash(hashToken(token))
+	log.Printf("auth: Paired %v as %v", d.name, d.role)
+	return d.id, token, nil
+}
+
+// Authenticate returns the ID of the device with token
+func Authenticate(token string) (string, error) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	hash := []byte(hashToken(token))
+	for _, d := range devices {
+		if subtle.ConstantTimeCompare(hash, []byte(d.tokenHash)) == 1 {
+			return d.id, nil
+		}
+	}
+	return "", errInvalidToken
+}
+
+// DeviceRole returns the role of the device with id.  Devices that are not
+// paired, or have been revoked, are viewers.
+func DeviceRole(id string) *Role {
+	sm.Lock()
+	defer sm.Unlock()
+
+	if d, ok := devices[id]; ok {
+		if r := FindRole(d.role); r != nil {
+			return r
+		}
+	}
+	return roles[RoleViewer]
+}
+
+// StateManager returns the StateManager the auth subsystem is bound to
+func StateManager() *statemanager.StateManager { return sm }
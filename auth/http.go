@@ -0,0 +1,45 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// RequestRole returns the role of the device whose token (see Pair) is
+// sent with r, in an "Authorization: Bearer" header or the token query
+// parameter.  Requests without a valid token are viewers.
+func RequestRole(r *http.Request) *Role {
+	token := r.URL.Query().Get("token")
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token = strings.TrimPrefix(h, "Bearer ")
+	}
+	if token == "" {
+		return roles[RoleViewer]
+	}
+	id, err := Authenticate(token)
+	if err != nil {
+		return roles[RoleViewer]
+	}
+	return DeviceRole(id)
+}
+
+// Handler serves h to devices with one of the roles named, others are
+// sent 403 Forbidden
+func Handler(h http.HandlerFunc, names ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role := RequestRole(r)
+		for _, name := range names {
+			if role.Name == name {
+				h(w, r)
+				return
+			}
+		}
+		err := &PermissionError{Role: role.Name, Action: "view " + r.URL.Path}
+		http.Error(w, err.Error(), http.StatusForbidden)
+	}
+}
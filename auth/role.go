@@ -0,0 +1,156 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package auth
+
+import (
+	"fmt"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+// Role is a set of permissions given to a device.  Each list holds patterns
+// (see statemanager.PatternMatch) of the keys or commands allowed.  Keys
+// matching Hidden can be neither read nor written, even if they match Read
+// or Write.  Keys marked as personal data in the schema can only be read
+// by roles with PII set.
+type Role struct {
+	Name     string
+	Read     []string
+	Write    []string
+	Commands []string
+	Hidden   []string
+	PII      bool
+}
+
+// Names of the roles
+const (
+	RoleViewer         = "Viewer"
+	RoleOperator       = "Operator"
+	RolePenaltyTracker = "PenaltyTracker"
+	RoleLineupTracker  = "LineupTracker"
+	RoleAdmin          = "Admin"
+)
+
+// privateKeys are only visible to admins
+var privateKeys = []string{"Auth"}
+
+var roles = map[string]*Role{
+	RoleViewer: {
+		Name:   RoleViewer,
+		Read:   []string{""},
+		Hidden: privateKeys,
+	},
+	RoleOperator: {
+		Name:     RoleOperator,
+		Read:     []string{""},
+		Write:    []string{"Scoreboard", "Settings"},
+		Commands: []string{"Scoreboard"},
+		Hidden:   privateKeys,
+	},
+	RolePenaltyTracker: {
+		Name:  RolePenaltyTracker,
+		Read:  []string{""},
+		Write: []string{"Scoreboard.Team(*).Skater(*).Penalty", "Scoreboard.Team(*).Skater(*).BoxTrip"},
+		Commands: []string{
+			"Scoreboard.Team(*).AddPenalty",
+			"Scoreboard.Team(*).DeletePenalty",
+		},
+		Hidden: privateKeys,
+	},
+	RoleLineupTracker: {
+		Name: RoleLineupTracker,
+		Read: []string{""},
+		Write: []string{
+			"Scoreboard.Team(*).Skater(*).Position",
+			"Scoreboard.Team(*).Skater(*).InBox",
+			"Scoreboard.Team(*).Jammer",
+			"Scoreboard.Team(*).Pivot",
+			"Scoreboard.Team(*).StarPass",
+		},
+		Hidden: privateKeys,
+	},
+	RoleAdmin: {
+		Name:     RoleAdmin,
+		Read:     []string{""},
+		Write:    []string{""},
+		Commands: []string{""},
+		Hidden:   []string{"Auth.Device(*).TokenHash"},
+		PII:      true,
+	},
+}
+
+// roleNames lists the roles for the schema of Auth.Device(*).Role
+var roleNames = []string{RoleViewer, RoleOperator, RolePenaltyTracker, RoleLineupTracker, RoleAdmin}
+
+// StaffRoles are the roles given to paired devices, every role but Viewer
+var StaffRoles = []string{RoleOperator, RolePenaltyTracker, RoleLineupTracker, RoleAdmin}
+
+// FindRole returns the role called name, or nil if there is none
+func FindRole(name string) *Role { return roles[name] }
+
+// PermissionError is returned when a role is not allowed to do something
+type PermissionError struct {
+	Role   string
+	Action string
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("Permission Denied: %v cannot %v", e.Role, e.Action)
+}
+
+// Code is the error code sent to clients, see statemanager.ErrorCode
+func (e *PermissionError) Code() string { return statemanager.CodePermissionDenied }
+
+func matchAny(patterns []string, k string) bool {
+	for _, p := range patterns {
+		if statemanager.PatternMatch(p, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRead returns true if the role may read the key k
+func (r *Role) CanRead(k string) bool {
+	return matchAny(r.Read, k) && !matchAny(r.Hidden, k)
+}
+
+// CanWrite returns true if the role may set the key k
+func (r *Role) CanWrite(k string) bool {
+	return matchAny(r.Write, k) && !matchAny(r.Hidden, k)
+}
+
+// CheckCommand returns a *PermissionError if the role may not run the
+// command name with data.  Set is checked against the key being set.
+func (r *Role) CheckCommand(name string, data []string) error {
+	if name == "Set" {
+		if len(data) > 0 && !r.CanWrite(data[0]) {
+			return &PermissionError{Role: r.Name, Action: "set " + data[0]}
+		}
+		return nil
+	}
+	if !matchAny(r.Commands, name) {
+		return &PermissionError{Role: r.Name, Action: "run " + name}
+	}
+	return nil
+}
+
+// CanSee returns true if the role may read the key k, allowing for
+// personal data, isPII tells if a key holds personal data
+func (r *Role) CanSee(k string, isPII func(string) bool) bool {
+	return r.CanRead(k) && (r.PII || !isPII(k))
+}
+
+// Filter removes the keys the role may not read from updates, see CanSee
+func (r *Role) Filter(updates map[string]*string, isPII func(string) bool) map[string]*string {
+	filtered := make(map[string]*string)
+	for k, v := range updates {
+		if r.CanSee(k, isPII) {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
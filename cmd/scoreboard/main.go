@@ -6,24 +6,43 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	_ "net/http/pprof"
 	"os"
 	"path/filepath"
 	"runtime/pprof"
+	"strings"
 
 	"github.com/kardianos/osext"
+	"github.com/rollerderby/crg/scoreboard"
 	"github.com/rollerderby/crg/server"
 	"github.com/rollerderby/crg/statemanager"
 )
 
 var port int
+var tracks int
 var cpuProf bool
+var redactArchives bool
+var encrypt bool
+var keyFile string
+var recoveryKey string
+var recoveryKeyOut string
+var migrate bool
 
 func init() {
 	flag.IntVar(&port, "port", 8000, "Server Port")
+	flag.IntVar(&tracks, "tracks", 1, "Number of Tracks")
 	flag.BoolVar(&cpuProf, "cpuprof", false, "Dump CPU Profile")
+	flag.BoolVar(&redactArchives, "redact-archives", false, "Leave Personal Data out of Archived Games")
+	flag.BoolVar(&encrypt, "encrypt", false, "Encrypt Saved Personal Data with a Passphrase")
+	flag.StringVar(&keyFile, "key-file", "", "Encrypt Saved Personal Data with the Key in File (Created if Missing)")
+	flag.StringVar(&recoveryKey, "recovery-key", "", "Decrypt Saved Data with the Recovery Private Key in File")
+	flag.StringVar(&recoveryKeyOut, "recovery-key-out", "", "Write the Recovery Private Key to File (Outside the Scoreboard Folder) when First Encrypting")
+	flag.BoolVar(&migrate, "migrate", false, "Encrypt Saved Files in Place and Exit")
 }
 
 func exists(dir bool, path ...string) bool {
@@ -40,6 +59,44 @@ func exists(dir bool, path ...string) bool {
 	return false
 }
 
+func readPassphrase(in *bufio.Reader, prompt string) string {
+	fmt.Print(prompt)
+	line, _ := in.ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+// openEncryption returns the encryption set up by the flags, or nil if
+// saved data is not encrypted
+func openEncryption() (*statemanager.Encryption, error) {
+	var e *statemanager.Encryption
+	var err error
+	if keyFile != "" {
+		e, err = statemanager.NewKeyFileEncryption(keyFile)
+	} else if encrypt {
+		in := bufio.NewReader(os.Stdin)
+		passphrase := readPassphrase(in, "Passphrase: ")
+		if passphrase == "" {
+			return nil, errors.New("No Passphrase Entered")
+		}
+		// A typo on the first encrypted run would lock away all saved data
+		if !server.HasRecoveryKey() && readPassphrase(in, "Repeat Passphrase: ") != passphrase {
+			return nil, errors.New("Passphrases Do Not Match")
+		}
+		e, err = statemanager.NewPassphraseEncryption(passphrase)
+	} else {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if recoveryKey != "" {
+		if err := e.Recover(recoveryKey); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
 func main() {
 	path, err := osext.ExecutableFolder()
 	if err == nil {
@@ -50,6 +107,27 @@ func main() {
 		}
 	}
 	flag.Parse()
+	scoreboard.RedactArchives = redactArchives
+
+	e, err := openEncryption()
+	if err != nil {
+		log.Fatal("Cannot set up encryption: ", err)
+	}
+	if e != nil {
+		if err := server.SetupEncryption(e, tracks, recoveryKeyOut); err != nil {
+			log.Fatal("Cannot decrypt saved data: ", err)
+		}
+	}
+	if migrate {
+		if e == nil {
+			log.Fatal("-migrate needs -encrypt or -key-file")
+		}
+		if err := server.MigrateEncryption(tracks); err != nil {
+			log.Fatal("Cannot encrypt saved data: ", err)
+		}
+		log.Print("Saved data encrypted")
+		return
+	}
 
 	if cpuProf {
 		f, err := os.Create(filepath.Join(statemanager.BaseFilePath(), "profile.cpu"))
@@ -60,5 +138,5 @@ func main() {
 			defer pprof.StopCPUProfile()
 		}
 	}
-	server.Start(uint16(port))
+	server.Start(uint16(port), tracks)
 }
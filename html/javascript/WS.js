@@ -10,6 +10,12 @@ var WS = {
 	callbacks: new Array(),
 	Connected: false,
 	state: { },
+	stateNum: 0,
+	errorCallback: null,
+	commandID: 0,
+	resultCallbacks: { },
+	Role: "Viewer",
+	roleCallback: null,
 	debug: false,
 
 	Connect: function(callback) {
@@ -20,7 +26,8 @@ var WS = {
 	_connect: function() {
 		WS.connectTimeout = null;
 		var url = (document.location.protocol == "http:" ? "ws" : "wss") + "://";
-		url += document.location.host + "/ws";
+		var track = document.location.pathname.match(/^\/track\/\d+/);
+		url += document.location.host + (track ? track[0] : "") + "/ws";
 
 		if(WS.Connected != true || !WS.socket) {
 			if(WS.debug) console.log("WS", "Connecting the websocket at " + url);
@@ -30,6 +37,20 @@ var WS = {
 				WS.Connected = true;
 				if(WS.debug) console.log("WS", "Websocket: Open");
 				$(".ConnectionError").addClass("Connected");
+				// Authenticate before registering, so updates are
+				// filtered for the device's role
+				var token = window.localStorage && window.localStorage.getItem("crgToken");
+				if (token) {
+					WS.sendCommand({ action: "Authenticate", data: [token] }, function(role, error) {
+						if (error != null) {
+							console.log("WS", "Cannot authenticate: " + error);
+							window.localStorage.removeItem("crgToken");
+							role = "Viewer";
+						}
+						WS.setRole(role);
+					});
+				} else
+					WS.setRole("Viewer");
 				req = {
 					action: "Register",
 					data: new Array()
@@ -53,8 +74,20 @@ var WS = {
 				if (WS.debug) console.log("WS", json);
 				if (json.authorization != null)
 					alert(json.authorization);
+				if (json.stateNum != null)
+					WS.stateNum = json.stateNum;
 				if (json.state != null)
 					WS.processUpdate(json.state);
+				if (json.error != null) {
+					console.log("WS", "Command " + json.action + " failed: " + json.error, json.conflict || json.constraint);
+					if (WS.errorCallback != null)
+						WS.errorCallback(json);
+				}
+				if (json.id != null && WS.resultCallbacks[json.id] != null) {
+					var callback = WS.resultCallbacks[json.id];
+					delete WS.resultCallbacks[json.id];
+					callback(json.result, json.error, json.code, json);
+				}
 			};
 			WS.socket.onclose = function(e) {
 				WS.Connected = false;
@@ -81,17 +114,27 @@ var WS = {
 		}
 	},
 
-	Command: function(command, data) {
+	// sendCommand sends req, calling callback(result, error, code, json)
+	// with the result of the command if it is given
+	sendCommand: function(req, callback) {
+		if (callback != null) {
+			req.id = ++WS.commandID;
+			WS.resultCallbacks[req.id] = callback;
+		}
+		WS.send(JSON.stringify(req));
+	},
+
+	Command: function(command, data, callback) {
 		if (!Array.isArray(data))
 			data = [data];
 		req = {
 			action: command,
 			data: data
 		};
-		WS.send(JSON.stringify(req));
+		WS.sendCommand(req, callback);
 	},
 
-	NewObject: function(name, data) {
+	NewObject: function(name, data, callback) {
 		req = {
 			action: "NewObject",
 			field: name,
@@ -100,11 +143,49 @@ var WS = {
 		for (var prop in data) {
 			req.fieldData[prop] = data[prop].toString();
 		}
-		WS.send(JSON.stringify(req));
+		WS.sendCommand(req, callback);
+	},
+
+	Set: function(key, value, callback) {
+		WS.Command("Set", [key, value], callback);
+	},
+
+	// CommandIfUnchanged runs the command only if none of keys have changed
+	// since the last update from the server, otherwise errorCallback is
+	// called with the conflicting key and its current value
+	CommandIfUnchanged: function(command, data, keys, callback) {
+		if (!Array.isArray(data))
+			data = [data];
+		req = {
+			action: command,
+			data: data,
+			stateNum: WS.stateNum,
+			keys: keys || []
+		};
+		WS.sendCommand(req, callback);
 	},
 
-	Set: function(key, value) {
-		WS.Command("Set", [key, value]);
+	SetIfUnchanged: function(key, value, callback) {
+		WS.CommandIfUnchanged("Set", [key, value], [], callback);
+	},
+
+	setRole: function(role) {
+		WS.Role = role;
+		if (WS.roleCallback != null)
+			WS.roleCallback(role);
+	},
+
+	// Pair pairs this device using a PIN created by an admin, reconnecting
+	// with its new role
+	Pair: function(pin, callback) {
+		WS.sendCommand({ action: "Pair", data: [pin] }, function(result, error, code, json) {
+			if (error == null) {
+				window.localStorage.setItem("crgToken", result.token);
+				WS.socket.close();
+			}
+			if (callback != null)
+				callback(result, error, code, json);
+		});
 	},
 
 	triggerCallback: function (k, v) {
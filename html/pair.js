@@ -0,0 +1,47 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+$(function() {
+	WS.roleCallback = showRole;
+	WS.Connect();
+	WS.Register("Auth.Device(*)", display);
+
+	$("button.Pair").click(function() {
+		WS.Pair($("input.PIN").val(), function(result, error) {
+			$("span.PairResult").text(error != null ? error : "Paired as " + result.role);
+		});
+	});
+	$("button.CreatePairing").click(function() {
+		WS.Command("Auth.CreatePairing", [$("select.NewRole").val(), $("input.NewName").val()], function(pin, error) {
+			$("span.NewPIN").text(error != null ? error : "Enter PIN " + pin + " on the device within 10 minutes");
+		});
+	});
+});
+
+function showRole(role) {
+	$("span.Role").text(role);
+	$("div.Admin").toggle(role == "Admin");
+}
+
+function display(k, v) {
+	var id = k.match(/^Auth\.Device\(([^)]*)\)/)[1];
+	var row = $('tr[device="' + id + '"]');
+	if (row.length == 0) {
+		if (v == null)
+			return;
+		row = $("<tr>").attr("device", id).appendTo("table tbody");
+		$("<td>").addClass("Name").appendTo(row);
+		$("<td>").addClass("Role").appendTo(row);
+		$("<td>").append($("<button>").text("Revoke").click(function() {
+			WS.Command("Auth.RevokeDevice", id);
+		})).appendTo(row);
+	}
+	if (k.endsWith(".ID") && v == null)
+		row.remove();
+	else if (k.endsWith(".Name"))
+		row.find("td.Name").text(v);
+	else if (k.endsWith(".Role"))
+		row.find("td.Role").text(v);
+}
@@ -15,30 +15,93 @@ type League struct {
 	id       string
 	name     string
 	stateIDs map[string]string
-	// members []*leagueMember
-	// teams   []*team
+	members  map[string]*member
+	teams    map[string]*Team
 }
 
+// sm is the StateManager the leagues subsystem is bound to
+var sm *statemanager.StateManager
+
 var leagues = make(map[string]*League)
 var errLeagueNotFound = errors.New("League Not Found")
+var errMemberNotFound = errors.New("Member Not Found")
+var errCommandArguments = errors.New("Incorrect Argument Count")
+
+// Initialize the leagues subsystem, binding it to the StateManager s
+func Initialize(s *statemanager.StateManager) {
+	sm = s
+
+	sm.RegisterPatternUpdaterString("Leagues.League(*).ID", 1, leagueSetID)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Name", 1, leagueSetName)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Member(*).ID", 2, leagueMemberSetID)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Member(*).Role", 2, leagueMemberSetRole)
+	sm.RegisterPatternUpdaterBool("Leagues.League(*).Member(*).Active", 2, leagueMemberSetActive)
+	sm.RegisterKey("Leagues.League(*).ID", statemanager.TypeString, "ID of the league")
+	sm.RegisterKey("Leagues.League(*).Name", statemanager.TypeString, "Name of the league").MaxLength(128)
+	sm.RegisterKey("Leagues.League(*).Member(*).ID", statemanager.TypeString, "ID of the Person that is a member of the league")
+	sm.RegisterKey("Leagues.League(*).Member(*).Role", statemanager.TypeString, "Role of the member in the league").Enum(roles...)
+	sm.RegisterKey("Leagues.League(*).Member(*).Active", statemanager.TypeBool, "Set if the member is active in the league")
 
-// Initialize the leagues subsystem
-func Initialize() {
-	statemanager.RegisterPatternUpdaterString("Leagues.League(*).Name", 1, leagueSetName)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Team(*).ID", 2, teamSetID)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Team(*).Name", 2, teamSetName)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Team(*).Type", 2, teamSetType)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Team(*).Color", 2, teamSetColor)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Team(*).Logo", 2, teamSetLogo)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Team(*).Member(*).ID", 3, teamMemberSetID)
+	sm.RegisterPatternUpdaterString("Leagues.League(*).Team(*).Member(*).Role", 3, teamMemberSetRole)
+	sm.RegisterPatternUpdaterBool("Leagues.League(*).Team(*).Member(*).Active", 3, teamMemberSetActive)
+	sm.RegisterKey("Leagues.League(*).Team(*).ID", statemanager.TypeString, "ID of the team")
+	sm.RegisterKey("Leagues.League(*).Team(*).Name", statemanager.TypeString, "Name of the team").MaxLength(64)
+	sm.RegisterKey("Leagues.League(*).Team(*).Type", statemanager.TypeString, "Type of the team: A, B or Home").Enum(TeamTypeA, TeamTypeB, TeamTypeHome)
+	sm.RegisterKey("Leagues.League(*).Team(*).Color", statemanager.TypeString, "Color of the team").Match(`#[0-9A-Fa-f]{3}|#[0-9A-Fa-f]{6}|[A-Za-z]+`)
+	sm.RegisterKey("Leagues.League(*).Team(*).Logo", statemanager.TypeString, "Logo of the team, relative to /images").MaxLength(256)
+	sm.RegisterKey("Leagues.League(*).Team(*).Member(*).ID", statemanager.TypeString, "ID of the Person on the team roster")
+	sm.RegisterKey("Leagues.League(*).Team(*).Member(*).Role", statemanager.TypeString, "Role of the member on the team").Enum(roles...)
+	sm.RegisterKey("Leagues.League(*).Team(*).Member(*).Active", statemanager.TypeBool, "Set if the member is active on the team")
 
-	statemanager.RegisterPatternUpdaterString("Leagues.Person(*).ID", 0, personSetID)
-	statemanager.RegisterPatternUpdaterString("Leagues.Person(*).Name", 0, personSetName)
-	statemanager.RegisterPatternUpdaterString("Leagues.Person(*).LegalName", 0, personSetLegalName)
-	statemanager.RegisterPatternUpdaterString("Leagues.Person(*).InsuranceNumber", 0, personSetInsuranceNumber)
-	statemanager.RegisterPatternUpdaterString("Leagues.Person(*).Number", 0, personSetNumber)
+	sm.RegisterPatternUpdaterString("Leagues.Person(*).ID", 0, personSetID)
+	sm.RegisterPatternUpdaterString("Leagues.Person(*).Name", 0, personSetName)
+	sm.RegisterPatternUpdaterString("Leagues.Person(*).LegalName", 0, personSetLegalName)
+	sm.RegisterPatternUpdaterString("Leagues.Person(*).InsuranceNumber", 0, personSetInsuranceNumber)
+	sm.RegisterPatternUpdaterString("Leagues.Person(*).Number", 0, personSetNumber)
+	sm.RegisterKey("Leagues.Person(*).ID", statemanager.TypeString, "ID of the person")
+	sm.RegisterKey("Leagues.Person(*).Name", statemanager.TypeString, "Derby name of the person").MaxLength(64)
+	sm.RegisterKey("Leagues.Person(*).LegalName", statemanager.TypeString, "Legal name of the person").MaxLength(128).Personal()
+	sm.RegisterKey("Leagues.Person(*).InsuranceNumber", statemanager.TypeString, "Insurance number of the person").MaxLength(64).Personal()
+	sm.RegisterKey("Leagues.Person(*).Number", statemanager.TypeString, "Number of the person").MaxLength(8)
+
+	sm.RegisterCommand("Leagues.DeleteMember", deleteLeagueMember)
+	sm.RegisterCommand("Leagues.DeleteTeam", deleteTeam)
+	sm.RegisterCommand("Leagues.DeleteTeamMember", deleteTeamMember)
+	sm.RegisterCommand("Leagues.MergePersons", mergePersons)
+	sm.DescribeCommand("Leagues.DeleteMember", "Removes a person from a league",
+		statemanager.Arg{Name: "LeagueID", Type: statemanager.TypeString},
+		statemanager.Arg{Name: "PersonID", Type: statemanager.TypeString})
+	sm.DescribeCommand("Leagues.DeleteTeam", "Deletes a team from a league",
+		statemanager.Arg{Name: "LeagueID", Type: statemanager.TypeString},
+		statemanager.Arg{Name: "TeamID", Type: statemanager.TypeString})
+	sm.DescribeCommand("Leagues.DeleteTeamMember", "Removes a person from a team",
+		statemanager.Arg{Name: "LeagueID", Type: statemanager.TypeString},
+		statemanager.Arg{Name: "TeamID", Type: statemanager.TypeString},
+		statemanager.Arg{Name: "PersonID", Type: statemanager.TypeString})
+	sm.DescribeCommand("Leagues.MergePersons", "Merges people into the first, deleting the others",
+		statemanager.Arg{Name: "KeepID", Type: statemanager.TypeString},
+		statemanager.Arg{Name: "OtherIDs", Type: statemanager.TypeString, Variadic: true})
 }
 
+// StateManager returns the StateManager the leagues subsystem is bound to.
+// Its lock must be held when reading leagues from another StateManager.
+func StateManager() *statemanager.StateManager { return sm }
+
 func blankLeague(id string) *League {
 	l := &League{
 		stateIDs: make(map[string]string),
+		members:  make(map[string]*member),
+		teams:    make(map[string]*Team),
 	}
 
 	base := "Leagues.League(" + id + ")"
+	l.stateIDs["base"] = base
 	l.stateIDs["id"] = base + ".ID"
 	l.stateIDs["name"] = base + ".Name"
 
@@ -57,16 +120,72 @@ func NewLeague(id, name, legalName, insuranceNumber, number string) *League {
 	return l
 }
 
+// FindLeague returns the League with the given id, or nil if there is none
+func FindLeague(id string) *League {
+	return leagues[id]
+}
+
 func (l *League) ID() string { return l.id }
 func (l *League) SetID(v string) error {
 	l.id = v
-	return statemanager.StateUpdateString(l.stateIDs["id"], v)
+	return sm.StateUpdateString(l.stateIDs["id"], v)
 }
 
 func (l *League) Name() string { return l.name }
 func (l *League) SetName(v string) error {
 	l.name = v
-	return statemanager.StateUpdateString(l.stateIDs["name"], v)
+	return sm.StateUpdateString(l.stateIDs["name"], v)
+}
+
+// Team returns the team with the given id, or nil if the league has no such team
+func (l *League) Team(id string) *Team {
+	return l.teams[id]
+}
+
+// Teams returns all teams owned by the league
+func (l *League) Teams() []*Team {
+	var ret []*Team
+	for _, t := range l.teams {
+		ret = append(ret, t)
+	}
+	return ret
+}
+
+// AddMember adds the person to the league (or updates the existing membership)
+func (l *League) AddMember(p *Person, role string, active bool) {
+	m, ok := l.members[p.ID()]
+	if !ok {
+		m = newMember(l.stateIDs["base"], p)
+		l.members[p.ID()] = m
+	}
+	m.setRole(role)
+	m.setActive(active)
+}
+
+// RemoveMember removes the person from the league and from all of the league's teams
+func (l *League) RemoveMember(id string) error {
+	m, ok := l.members[id]
+	if !ok {
+		return errMemberNotFound
+	}
+	for _, t := range l.teams {
+		t.RemoveMember(id)
+	}
+	delete(l.members, id)
+	m.delete()
+	return nil
+}
+
+// Members returns the persons who are members of the league.  If activeOnly
+// is set, inactive members are not returned.
+func (l *League) Members(activeOnly bool) []*Person {
+	var ret []*Person
+	for _, m := range l.members {
+		if !activeOnly || m.active {
+			ret = append(ret, m.person)
+		}
+	}
+	return ret
 }
 
 /* Helper functions to find the League for RegisterUpdaters */
@@ -82,6 +201,26 @@ func findLeague(k string) *League {
 	return l
 }
 
+func (l *League) findMember(k string) *member {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 2 {
+		return nil
+	}
+	id := ids[1]
+
+	m, ok := l.members[id]
+	if !ok {
+		// Members can only refer to a Person that exists
+		p, ok := persons[id]
+		if !ok {
+			return nil
+		}
+		m = newMember(l.stateIDs["base"], p)
+		l.members[id] = m
+	}
+	return m
+}
+
 func leagueSetID(k, v string) error {
 	if l := findLeague(k); l != nil {
 		l.SetID(v)
@@ -96,3 +235,39 @@ func leagueSetName(k, v string) error {
 	}
 	return errLeagueNotFound
 }
+
+// leagueMemberSetID makes sure the member exists, the ID of a member is
+// always the ID of its Person
+func leagueMemberSetID(k, _ string) error {
+	if m := findLeague(k).findMember(k); m != nil {
+		return nil
+	}
+	return errMemberNotFound
+}
+func leagueMemberSetRole(k, v string) error {
+	if m := findLeague(k).findMember(k); m != nil {
+		m.setRole(v)
+		return nil
+	}
+	return errMemberNotFound
+}
+func leagueMemberSetActive(k string, v bool) error {
+	if m := findLeague(k).findMember(k); m != nil {
+		m.setActive(v)
+		return nil
+	}
+	return errMemberNotFound
+}
+
+// deleteLeagueMember is the Leagues.DeleteMember command.
+// data: [leagueID, personID]
+func deleteLeagueMember(data []string) error {
+	if len(data) < 2 {
+		return errCommandArguments
+	}
+	l, ok := leagues[data[0]]
+	if !ok {
+		return errLeagueNotFound
+	}
+	return l.RemoveMember(data[1])
+}
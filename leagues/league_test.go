@@ -0,0 +1,95 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package leagues
+
+import (
+	"testing"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+func TestLeagueLoad(t *testing.T) {
+	Initialize(statemanager.New())
+	sm.Lock()
+	defer sm.Unlock()
+
+	// Every key written for leagues, teams and members can be loaded
+	for _, k := range []string{
+		"Leagues.Person(p1).ID",
+		"Leagues.League(load).ID",
+		"Leagues.League(load).Member(p1).ID",
+		"Leagues.League(load).Team(a).ID",
+		"Leagues.League(load).Team(a).Member(p1).ID",
+	} {
+		ids := statemanager.ParseIDs(k)
+		if err := sm.StateSet(k, ids[len(ids)-1]); err != nil {
+			t.Errorf("%v: %v", k, err)
+		}
+	}
+
+	l := FindLeague("load")
+	if l == nil {
+		t.Fatal("League not loaded")
+	}
+	team := l.Team("a")
+	if team == nil {
+		t.Fatal("Team not loaded")
+	}
+	if len(l.Members(false)) != 1 || len(team.Roster(false)) != 1 || team.Roster(false)[0].Person.ID() != "p1" {
+		t.Errorf("Members not loaded: league %v, team %v", l.Members(false), team.Roster(false))
+	}
+
+	// Members can't refer to a Person that doesn't exist
+	for _, k := range []string{
+		"Leagues.League(load).Member(nobody).ID",
+		"Leagues.League(load).Team(a).Member(nobody).ID",
+	} {
+		if err := sm.StateSet(k, "nobody"); err != errMemberNotFound {
+			t.Errorf("%v: expected errMemberNotFound got %v", k, err)
+		}
+	}
+	if _, ok := persons["nobody"]; ok {
+		t.Error("Person created for an unknown member")
+	}
+}
+
+func TestLeagueMembers(t *testing.T) {
+	Initialize(statemanager.New())
+	sm.Lock()
+	defer sm.Unlock()
+
+	l := NewLeague("members", "League", "", "", "")
+	team := l.NewTeam("a", "A Team", TeamTypeA, "Red", "")
+	skater := NewPerson("m1", "Skater", "", "", "1")
+	bench := NewPerson("m2", "Bench", "", "", "")
+
+	// Adding to a team roster adds to the league
+	team.AddMember(skater, RoleSkater, true)
+	team.AddMember(bench, RoleBenchStaff, false)
+	if len(l.Members(false)) != 2 {
+		t.Errorf("Team members not added to the league: %v", l.Members(false))
+	}
+	if r := team.Roster(true); len(r) != 1 || r[0].Person != skater || r[0].Role != RoleSkater {
+		t.Errorf("Active roster: %v", r)
+	}
+	if len(team.Roster(false)) != 2 {
+		t.Errorf("Full roster: %v", team.Roster(false))
+	}
+
+	// Removing from the league removes from its teams
+	if err := l.RemoveMember("m1"); err != nil {
+		t.Fatal(err)
+	}
+	if r := team.Roster(false); len(r) != 1 || r[0].Person != bench {
+		t.Errorf("Removed member kept on the roster: %v", r)
+	}
+	if v, _ := sm.StateGetString("Leagues.League(members).Team(a).Member(m1).Role"); v != "" {
+		t.Errorf("Removed member's state kept: %v", v)
+	}
+	if err := l.RemoveMember("m1"); err != errMemberNotFound {
+		t.Errorf("Removing twice: got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package leagues
+
+// Roles a Person can hold as a member of a League or on a Team roster
+const (
+	RoleSkater     = "Skater"
+	RoleAlternate  = "Alternate"
+	RoleCaptain    = "Captain"
+	RoleAltCaptain = "AltCaptain"
+	RoleBenchStaff = "BenchStaff"
+	RoleOfficial   = "Official"
+)
+
+var roles = []string{RoleSkater, RoleAlternate, RoleCaptain, RoleAltCaptain, RoleBenchStaff, RoleOfficial}
+
+// member links a Person to a League or a Team roster
+type member struct {
+	person   *Person
+	base     string
+	role     string
+	active   bool
+	stateIDs map[string]string
+}
+
+func newMember(parentBase string, p *Person) *member {
+	m := &member{
+		person:   p,
+		base:     parentBase + ".Member(" + p.ID() + ")",
+		stateIDs: make(map[string]string),
+	}
+
+	m.stateIDs["id"] = m.base + ".ID"
+	m.stateIDs["role"] = m.base + ".Role"
+	m.stateIDs["active"] = m.base + ".Active"
+
+	sm.StateUpdateString(m.stateIDs["id"], p.ID())
+	m.setRole(RoleSkater)
+	m.setActive(true)
+
+	return m
+}
+
+func (m *member) delete() {
+	sm.StateDelete(m.base)
+}
+
+func (m *member) setRole(v string) error {
+	m.role = v
+	return sm.StateUpdateString(m.stateIDs["role"], v)
+}
+
+func (m *member) setActive(v bool) error {
+	m.active = v
+	return sm.StateUpdateBool(m.stateIDs["active"], v)
+}
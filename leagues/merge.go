@@ -0,0 +1,277 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package leagues
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+// MergeFunc is called after a Person has been merged into another so
+// other subsystems can move their references from mergedID to survivorID.
+// Returns a channel that is closed once the references have been moved,
+// or nil if they already have been.
+type MergeFunc func(survivorID, mergedID string) <-chan struct{}
+
+type mergeFunc struct {
+	f MergeFunc
+}
+
+// Duplicate describes a pair of Persons that are likely the same person
+type Duplicate struct {
+	IDs     [2]string `json:"ids"`
+	Score   float64   `json:"score"`
+	Reasons []string  `json:"reasons"`
+}
+
+var mergeFuncs []*mergeFunc
+var mergeFuncsLock sync.Mutex
+var errMergeSelf = errors.New("Cannot Merge Person Into Itself")
+
+// duplicateThreshold is the minimum score for a pair to be reported as a duplicate
+const duplicateThreshold = 0.75
+
+// RegisterMergeFunc registers f to be called whenever two Persons are
+// merged.  Returns a function that unregisters f.
+func RegisterMergeFunc(f MergeFunc) func() {
+	mergeFuncsLock.Lock()
+	defer mergeFuncsLock.Unlock()
+	mf := &mergeFunc{f: f}
+	mergeFuncs = append(mergeFuncs, mf)
+	return func() {
+		mergeFuncsLock.Lock()
+		defer mergeFuncsLock.Unlock()
+		for i, mf2 := range mergeFuncs {
+			if mf == mf2 {
+				mergeFuncs = append(mergeFuncs[:i], mergeFuncs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// MergePersons merges the Person mergedID into survivorID.  Fields that
+// are blank on the survivor are filled in from the merged record, league
+// and team memberships are moved to the survivor, registered MergeFuncs
+// are called and the merged Person is deleted.  If a MergeFunc moves its
+// references in the background the merged Person is deleted once it is
+// done, so nothing is left referring to a deleted Person.
+func MergePersons(survivorID, mergedID string) error {
+	if survivorID == mergedID {
+		return errMergeSelf
+	}
+	survivor, ok := persons[survivorID]
+	if !ok {
+		return errPersonNotFound
+	}
+	merged, ok := persons[mergedID]
+	if !ok {
+		return errPersonNotFound
+	}
+	log.Printf("leagues: Merging Person(%v) into Person(%v)", mergedID, survivorID)
+
+	if survivor.name == "" {
+		survivor.SetName(merged.name)
+	}
+	if survivor.legalName == "" {
+		survivor.SetLegalName(merged.legalName)
+	}
+	if survivor.insuranceNumber == "" {
+		survivor.SetInsuranceNumber(merged.insuranceNumber)
+	}
+	if survivor.number == "" {
+		survivor.SetNumber(merged.number)
+	}
+
+	for _, l := range leagues {
+		for _, t := range l.teams {
+			if m, ok := t.members[mergedID]; ok {
+				if _, ok := t.members[survivorID]; !ok {
+					t.AddMember(survivor, m.role, m.active)
+				}
+				t.RemoveMember(mergedID)
+			}
+		}
+		if m, ok := l.members[mergedID]; ok {
+			if _, ok := l.members[survivorID]; !ok {
+				l.AddMember(survivor, m.role, m.active)
+			}
+			delete(l.members, mergedID)
+			m.delete()
+		}
+	}
+
+	mergeFuncsLock.Lock()
+	funcs := append([]*mergeFunc(nil), mergeFuncs...)
+	mergeFuncsLock.Unlock()
+	var pending []<-chan struct{}
+	for _, mf := range funcs {
+		if done := mf.f(survivorID, mergedID); done != nil {
+			pending = append(pending, done)
+		}
+	}
+	if len(pending) == 0 {
+		return deletePerson(sm, merged)
+	}
+
+	go func(sm *statemanager.StateManager) {
+		for _, done := range pending {
+			<-done
+		}
+		sm.Lock()
+		defer sm.Unlock()
+		deletePerson(sm, merged)
+	}(sm)
+	return nil
+}
+
+// deletePerson deletes p unless it has been replaced since.  The
+// statemanager lock must be held by the caller.
+func deletePerson(sm *statemanager.StateManager, p *Person) error {
+	if persons[p.id] != p {
+		return nil
+	}
+	delete(persons, p.id)
+	return sm.StateDelete("Leagues.Person(" + p.id + ")")
+}
+
+// mergePersons is the Leagues.MergePersons command.
+// data: [survivorID, mergedID, ...]
+func mergePersons(data []string) error {
+	if len(data) < 2 {
+		return errCommandArguments
+	}
+	for _, id := range data[1:] {
+		if err := MergePersons(data[0], id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindDuplicates returns pairs of Persons that are likely duplicates based
+// on the similarity of their name, number and legal name, best matches first
+func FindDuplicates() []Duplicate {
+	var ps []*Person
+	for _, p := range persons {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].id < ps[j].id })
+
+	var ret []Duplicate
+	for i, p1 := range ps {
+		for _, p2 := range ps[i+1:] {
+			if d, ok := comparePersons(p1, p2); ok {
+				ret = append(ret, d)
+			}
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Score > ret[j].Score })
+	return ret
+}
+
+func comparePersons(p1, p2 *Person) (Duplicate, bool) {
+	d := Duplicate{IDs: [2]string{p1.id, p2.id}}
+
+	name := similarity(p1.name, p2.name)
+	legalName := similarity(p1.legalName, p2.legalName)
+	sameNumber := p1.number != "" && normalize(p1.number) == normalize(p2.number)
+
+	if name > 0 {
+		d.Reasons = append(d.Reasons, "Name")
+	}
+	if legalName > 0 {
+		d.Reasons = append(d.Reasons, "LegalName")
+	}
+	if sameNumber {
+		d.Reasons = append(d.Reasons, "Number")
+	}
+
+	// Names carry most of the weight, a matching number on its own is not enough
+	d.Score = name
+	if legalName > d.Score {
+		d.Score = legalName
+	}
+	if sameNumber && d.Score > 0 {
+		d.Score = d.Score + (1-d.Score)/2
+	}
+
+	return d, d.Score >= duplicateThreshold
+}
+
+// similarity returns a value between 0 and 1 describing how alike two
+// names are after normalizing.  Values below duplicateThreshold are
+// reported as 0.
+func similarity(a, b string) float64 {
+	a, b = normalize(a), normalize(b)
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	max := len(ra)
+	if len(rb) > max {
+		max = len(rb)
+	}
+	s := 1 - float64(levenshtein(ra, rb))/float64(max)
+	if s < duplicateThreshold {
+		return 0
+	}
+	return s
+}
+
+// normalize lowercases s and strips everything but letters and digits
+func normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// DuplicatesHandler writes the likely duplicate Persons as JSON
+func DuplicatesHandler(w http.ResponseWriter, _ *http.Request) {
+	sm.Lock()
+	dups := FindDuplicates()
+	sm.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dups); err != nil {
+		log.Print("leagues: Cannot write duplicates: ", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package leagues
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+func TestSimilarity(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"Smash Lee", "smash lee", true},
+		{"Smash Lee", "Smash-Lee", true},
+		{"Smash Lee", "Smash Le", true},
+		{"Smash Lee", "Crash Bee", false},
+		{"", "", false},
+		{"Smash Lee", "", false},
+	}
+
+	for _, c := range cases {
+		r := similarity(c.a, c.b) > 0
+		if r != c.expected {
+			t.Errorf("similarity('%v', '%v') expected %v got %v", c.a, c.b, c.expected, r)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	if d := levenshtein([]rune("kitten"), []rune("sitting")); d != 3 {
+		t.Errorf("levenshtein('kitten', 'sitting') expected 3 got %v", d)
+	}
+}
+
+func TestMergePersons(t *testing.T) {
+	Initialize(statemanager.New())
+	sm.Lock()
+	defer sm.Unlock()
+
+	var merges [][2]string
+	defer RegisterMergeFunc(func(survivorID, mergedID string) <-chan struct{} {
+		merges = append(merges, [2]string{survivorID, mergedID})
+		return nil
+	})()
+
+	keep := NewPerson("keep", "Smash Lee", "", "", "12")
+	other := NewPerson("other", "Smash Le", "Jane Doe", "INS-1", "21")
+	l := NewLeague("l", "League", "", "", "")
+	l.AddMember(other, RoleSkater, true)
+	team := l.NewTeam("a", "A Team", TeamTypeA, "Red", "")
+	team.AddMember(keep, RoleSkater, true)
+	team.AddMember(other, RoleCaptain, false)
+
+	if err := MergePersons("keep", "keep"); err != errMergeSelf {
+		t.Errorf("Merge into itself: got %v", err)
+	}
+	if err := MergePersons("keep", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keep.Name() != "Smash Lee" || keep.Number() != "12" || keep.LegalName() != "Jane Doe" || keep.InsuranceNumber() != "INS-1" {
+		t.Errorf("Blank fields not filled in: %+v", keep)
+	}
+	if _, ok := persons["other"]; ok {
+		t.Errorf("Merged person not deleted")
+	}
+	if v, _ := sm.StateGetString("Leagues.Person(other).Name"); v != "" {
+		t.Errorf("Merged person's state not deleted: %v", v)
+	}
+	if m, ok := l.members["keep"]; !ok || m.role != RoleSkater {
+		t.Errorf("League membership not moved: %+v", l.members)
+	}
+	if _, ok := l.members["other"]; ok {
+		t.Errorf("League membership not removed")
+	}
+	if m, ok := team.members["keep"]; !ok || m.role != RoleSkater || len(team.members) != 1 {
+		t.Errorf("Team membership of the survivor changed: %+v", team.members)
+	}
+	if len(merges) != 1 || merges[0] != [2]string{"keep", "other"} {
+		t.Errorf("MergeFuncs called with %v", merges)
+	}
+}
+
+func TestMergePersonsPending(t *testing.T) {
+	Initialize(statemanager.New())
+	done := make(chan struct{})
+	defer RegisterMergeFunc(func(survivorID, mergedID string) <-chan struct{} { return done })()
+
+	sm.Lock()
+	NewPerson("keep", "Smash Lee", "", "", "12")
+	NewPerson("other", "Smash Le", "", "", "21")
+	if err := MergePersons("keep", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := persons["other"]; !ok {
+		t.Error("Merged person deleted before the MergeFunc was done")
+	}
+	sm.Unlock()
+
+	close(done)
+	for i := 0; ; i++ {
+		sm.Lock()
+		_, ok := persons["other"]
+		sm.Unlock()
+		if !ok {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Merged person not deleted after the MergeFunc was done")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	n := len(mergeFuncs)
+	RegisterMergeFunc(func(string, string) <-chan struct{} { return nil })()
+	if len(mergeFuncs) != n {
+		t.Errorf("MergeFunc not unregistered: %v registered, expected %v", len(mergeFuncs), n)
+	}
+}
@@ -46,7 +46,8 @@ func blankPerson(id string) *Person {
 	return p
 }
 
-// NewPerson creates a new person (unattached to any leagues at this point)
+// NewPerson creates a new person (unattached to any leagues at this point,
+// see League.AddMember and Team.AddMember)
 func NewPerson(id, name, legalName, insuranceNumber, number string) *Person {
 	p := blankPerson(id)
 	p.SetName(name)
@@ -63,38 +64,45 @@ func (p *Person) ID() string { return p.id }
 // SetID sets the ID to `v`
 func (p *Person) SetID(v string) error {
 	p.id = v
-	return statemanager.StateUpdateString(p.stateIDs["id"], v)
+	return sm.StateUpdateString(p.stateIDs["id"], v)
 }
 
 func (p *Person) Name() string { return p.name }
 func (p *Person) SetName(v string) error {
 	p.name = v
-	return statemanager.StateUpdateString(p.stateIDs["name"], v)
+	return sm.StateUpdateString(p.stateIDs["name"], v)
 }
 
 func (p *Person) LegalName() string { return p.legalName }
 func (p *Person) SetLegalName(v string) error {
 	p.legalName = v
-	return statemanager.StateUpdateString(p.stateIDs["legalName"], v)
+	return sm.StateUpdateString(p.stateIDs["legalName"], v)
 }
 
 func (p *Person) InsuranceNumber() string { return p.insuranceNumber }
 func (p *Person) SetInsuranceNumber(v string) error {
 	p.insuranceNumber = v
-	return statemanager.StateUpdateString(p.stateIDs["insuranceNumber"], v)
+	return sm.StateUpdateString(p.stateIDs["insuranceNumber"], v)
 }
 
 func (p *Person) Number() string { return p.number }
 func (p *Person) SetNumber(v string) error {
 	p.number = v
-	return statemanager.StateUpdateString(p.stateIDs["number"], v)
+	return sm.StateUpdateString(p.stateIDs["number"], v)
+}
+
+// FindPerson returns the Person with the given id, or nil if there is none
+func FindPerson(id string) *Person {
+	return persons[id]
 }
 
 /* Helper functions to find the Person for RegisterUpdaters */
 func findPerson(k string) *Person {
 	ids := statemanager.ParseIDs(k)
-	id := ids[0]
+	return findPersonByID(ids[0])
+}
 
+func findPersonByID(id string) *Person {
 	p, ok := persons[id]
 	if !ok {
 		p = blankPerson(id)
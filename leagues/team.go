@@ -0,0 +1,286 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package leagues
+
+import (
+	"errors"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+// Types of teams a League can own
+const (
+	TeamTypeA    = "A"
+	TeamTypeB    = "B"
+	TeamTypeHome = "Home"
+)
+
+// Team is a saved team belonging to a League, with a roster of Persons
+type Team struct {
+	l        *League
+	id       string
+	base     string
+	name     string
+	teamType string
+	color    string
+	logo     string
+	members  map[string]*member
+	stateIDs map[string]string
+}
+
+// RosterEntry describes one Person on a Team roster
+type RosterEntry struct {
+	Person *Person
+	Role   string
+	Active bool
+}
+
+var errTeamNotFound = errors.New("Team Not Found")
+
+func blankTeam(l *League, id string) *Team {
+	t := &Team{
+		l:        l,
+		id:       id,
+		base:     l.stateIDs["base"] + ".Team(" + id + ")",
+		members:  make(map[string]*member),
+		stateIDs: make(map[string]string),
+	}
+
+	t.stateIDs["id"] = t.base + ".ID"
+	t.stateIDs["name"] = t.base + ".Name"
+	t.stateIDs["type"] = t.base + ".Type"
+	t.stateIDs["color"] = t.base + ".Color"
+	t.stateIDs["logo"] = t.base + ".Logo"
+
+	sm.StateUpdateString(t.stateIDs["id"], id)
+	t.SetName("")
+	t.SetType(TeamTypeHome)
+	t.SetColor("")
+	t.SetLogo("")
+
+	l.teams[id] = t
+
+	return t
+}
+
+// NewTeam creates a new team owned by the league
+func (l *League) NewTeam(id, name, teamType, color, logo string) *Team {
+	t := blankTeam(l, id)
+	t.SetName(name)
+	t.SetType(teamType)
+	t.SetColor(color)
+	t.SetLogo(logo)
+
+	return t
+}
+
+// DeleteTeam removes the team (and its roster) from the league
+func (l *League) DeleteTeam(id string) error {
+	t, ok := l.teams[id]
+	if !ok {
+		return errTeamNotFound
+	}
+	delete(l.teams, id)
+	return sm.StateDelete(t.base)
+}
+
+func (t *Team) League() *League { return t.l }
+func (t *Team) ID() string      { return t.id }
+
+func (t *Team) Name() string { return t.name }
+func (t *Team) SetName(v string) error {
+	t.name = v
+	return sm.StateUpdateString(t.stateIDs["name"], v)
+}
+
+func (t *Team) Type() string { return t.teamType }
+func (t *Team) SetType(v string) error {
+	t.teamType = v
+	return sm.StateUpdateString(t.stateIDs["type"], v)
+}
+
+func (t *Team) Color() string { return t.color }
+func (t *Team) SetColor(v string) error {
+	t.color = v
+	return sm.StateUpdateString(t.stateIDs["color"], v)
+}
+
+func (t *Team) Logo() string { return t.logo }
+func (t *Team) SetLogo(v string) error {
+	t.logo = v
+	return sm.StateUpdateString(t.stateIDs["logo"], v)
+}
+
+// AddMember adds the person to the team roster (or updates the existing entry).
+// The person is also made a member of the team's league if they are not already.
+func (t *Team) AddMember(p *Person, role string, active bool) {
+	if _, ok := t.l.members[p.ID()]; !ok {
+		t.l.AddMember(p, RoleSkater, true)
+	}
+	m, ok := t.members[p.ID()]
+	if !ok {
+		m = newMember(t.base, p)
+		t.members[p.ID()] = m
+	}
+	m.setRole(role)
+	m.setActive(active)
+}
+
+// RemoveMember removes the person from the team roster
+func (t *Team) RemoveMember(id string) error {
+	m, ok := t.members[id]
+	if !ok {
+		return errMemberNotFound
+	}
+	delete(t.members, id)
+	m.delete()
+	return nil
+}
+
+// Roster returns the team roster.  If activeOnly is set, inactive
+// entries are not returned.
+func (t *Team) Roster(activeOnly bool) []RosterEntry {
+	var ret []RosterEntry
+	for _, m := range t.members {
+		if !activeOnly || m.active {
+			ret = append(ret, RosterEntry{Person: m.person, Role: m.role, Active: m.active})
+		}
+	}
+	return ret
+}
+
+/* Helper functions to find the Team for RegisterUpdaters */
+func (l *League) findTeam(k string) *Team {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 2 {
+		return nil
+	}
+	id := ids[1]
+
+	t, ok := l.teams[id]
+	if !ok {
+		t = blankTeam(l, id)
+	}
+	return t
+}
+
+func (t *Team) findMember(k string) *member {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 3 {
+		return nil
+	}
+	id := ids[2]
+
+	m, ok := t.members[id]
+	if !ok {
+		// Members can only refer to a Person that exists
+		p, ok := persons[id]
+		if !ok {
+			return nil
+		}
+		m = newMember(t.base, p)
+		t.members[id] = m
+	}
+	return m
+}
+
+// teamSetID makes sure the team exists, the ID of a team is always the ID
+// in its key
+func teamSetID(k, _ string) error {
+	if t := findLeague(k).findTeam(k); t != nil {
+		return nil
+	}
+	return errTeamNotFound
+}
+func teamSetName(k, v string) error {
+	if t := findLeague(k).findTeam(k); t != nil {
+		t.SetName(v)
+		return nil
+	}
+	return errTeamNotFound
+}
+func teamSetType(k, v string) error {
+	if t := findLeague(k).findTeam(k); t != nil {
+		t.SetType(v)
+		return nil
+	}
+	return errTeamNotFound
+}
+func teamSetColor(k, v string) error {
+	if t := findLeague(k).findTeam(k); t != nil {
+		t.SetColor(v)
+		return nil
+	}
+	return errTeamNotFound
+}
+func teamSetLogo(k, v string) error {
+	if t := findLeague(k).findTeam(k); t != nil {
+		t.SetLogo(v)
+		return nil
+	}
+	return errTeamNotFound
+}
+
+// teamMemberSetID makes sure the roster entry exists, see leagueMemberSetID
+func teamMemberSetID(k, _ string) error {
+	if t := findLeague(k).findTeam(k); t != nil {
+		if m := t.findMember(k); m != nil {
+			return nil
+		}
+		return errMemberNotFound
+	}
+	return errTeamNotFound
+}
+func teamMemberSetRole(k, v string) error {
+	if t := findLeague(k).findTeam(k); t != nil {
+		if m := t.findMember(k); m != nil {
+			m.setRole(v)
+			return nil
+		}
+		return errMemberNotFound
+	}
+	return errTeamNotFound
+}
+func teamMemberSetActive(k string, v bool) error {
+	if t := findLeague(k).findTeam(k); t != nil {
+		if m := t.findMember(k); m != nil {
+			m.setActive(v)
+			return nil
+		}
+		return errMemberNotFound
+	}
+	return errTeamNotFound
+}
+
+// deleteTeam is the Leagues.DeleteTeam command.
+// data: [leagueID, teamID]
+func deleteTeam(data []string) error {
+	if len(data) < 2 {
+		return errCommandArguments
+	}
+	l, ok := leagues[data[0]]
+	if !ok {
+		return errLeagueNotFound
+	}
+	return l.DeleteTeam(data[1])
+}
+
+// deleteTeamMember is the Leagues.DeleteTeamMember command.
+// data: [leagueID, teamID, personID]
+func deleteTeamMember(data []string) error {
+	if len(data) < 3 {
+		return errCommandArguments
+	}
+	l, ok := leagues[data[0]]
+	if !ok {
+		return errLeagueNotFound
+	}
+	t, ok := l.teams[data[1]]
+	if !ok {
+		return errTeamNotFound
+	}
+	return t.RemoveMember(data[2])
+}
@@ -6,17 +6,22 @@
 package scoreboard
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 
 	"github.com/rollerderby/crg/statemanager"
 )
 
+var errBoxTripNotFound = errors.New("Box Trip Not Found")
+
 type boxTrip struct {
 	sb       *Scoreboard
 	s        *skater
 	in       boxTripTime
 	out      boxTripTime
+	duration int64
 	stateIDs map[string]string
 }
 
@@ -30,6 +35,8 @@ func blankBoxTrip(s *skater) *boxTrip {
 	bt := &boxTrip{
 		sb:       s.t.sb,
 		s:        s,
+		in:       boxTripTime{jamIdx: -1},
+		out:      boxTripTime{jamIdx: -1},
 		stateIDs: make(map[string]string),
 	}
 	idx := len(s.boxTrips)
@@ -46,6 +53,7 @@ func blankBoxTrip(s *skater) *boxTrip {
 	bt.stateIDs["out.jam"] = base + ".Out.Jam"
 	bt.stateIDs["out.betweenJams"] = base + ".Out.BetweenJams"
 	bt.stateIDs["out.afterStarPass"] = base + ".Out.AfterStarPass"
+	bt.stateIDs["duration"] = base + ".Duration"
 
 	return bt
 }
@@ -60,81 +68,201 @@ func newBoxTrip(s *skater, jamIdx int64, betweenJams, afterStarPass bool) *boxTr
 	bt.setOutJamIdx(-1)
 	bt.setOutBetweenJams(false)
 	bt.setOutAfterStarPass(false)
+	bt.setDuration(0)
 
 	return bt
 }
 
 func (bt *boxTrip) setSkater(s *skater) {
 	bt.s = s
-	statemanager.StateUpdateString(bt.stateIDs["skater"], s.id)
+	bt.sb.sm.StateUpdateString(bt.stateIDs["skater"], s.id)
 }
 
 func (bt *boxTrip) setInJamIdx(v int64) error {
 	if v >= 0 && v < int64(len(bt.sb.jams)) {
 		jam := bt.sb.jams[v]
 		bt.in.jamIdx = jam.idx
-		statemanager.StateUpdateInt64(bt.stateIDs["in.jamIdx"], jam.idx)
-		statemanager.StateUpdateInt64(bt.stateIDs["in.period"], jam.period)
-		statemanager.StateUpdateInt64(bt.stateIDs["in.jam"], jam.jam)
+		bt.sb.sm.StateUpdateInt64(bt.stateIDs["in.jamIdx"], jam.idx)
+		bt.sb.sm.StateUpdateInt64(bt.stateIDs["in.period"], jam.period)
+		bt.sb.sm.StateUpdateInt64(bt.stateIDs["in.jam"], jam.jam)
 		return nil
 	}
 
 	bt.in.jamIdx = -1
-	statemanager.StateDelete(bt.stateIDs["in.jamIdx"])
-	statemanager.StateDelete(bt.stateIDs["in.period"])
-	statemanager.StateDelete(bt.stateIDs["in.jam"])
+	bt.sb.sm.StateDelete(bt.stateIDs["in.jamIdx"])
+	bt.sb.sm.StateDelete(bt.stateIDs["in.period"])
+	bt.sb.sm.StateDelete(bt.stateIDs["in.jam"])
 	return nil
 }
 
 func (bt *boxTrip) setInBetweenJams(v bool) {
 	bt.in.betweenJams = v
-	statemanager.StateUpdateBool(bt.stateIDs["in.betweenJams"], v)
+	bt.sb.sm.StateUpdateBool(bt.stateIDs["in.betweenJams"], v)
 }
 
 func (bt *boxTrip) setInAfterStarPass(v bool) {
 	bt.in.afterStarPass = v
-	statemanager.StateUpdateBool(bt.stateIDs["in.afterStarPass"], v)
+	bt.sb.sm.StateUpdateBool(bt.stateIDs["in.afterStarPass"], v)
 }
 
 func (bt *boxTrip) setOutJamIdx(v int64) {
 	if v >= 0 && v < int64(len(bt.sb.jams)) {
 		jam := bt.sb.jams[v]
 		bt.out.jamIdx = jam.idx
-		statemanager.StateUpdateInt64(bt.stateIDs["out.jamIdx"], jam.idx)
-		statemanager.StateUpdateInt64(bt.stateIDs["out.period"], jam.period)
-		statemanager.StateUpdateInt64(bt.stateIDs["out.jam"], jam.jam)
+		bt.sb.sm.StateUpdateInt64(bt.stateIDs["out.jamIdx"], jam.idx)
+		bt.sb.sm.StateUpdateInt64(bt.stateIDs["out.period"], jam.period)
+		bt.sb.sm.StateUpdateInt64(bt.stateIDs["out.jam"], jam.jam)
+		return
 	}
 
-	statemanager.StateDelete(bt.stateIDs["out.jamIdx"])
-	statemanager.StateDelete(bt.stateIDs["out.period"])
-	statemanager.StateDelete(bt.stateIDs["out.jam"])
+	bt.out.jamIdx = -1
+	bt.sb.sm.StateDelete(bt.stateIDs["out.jamIdx"])
+	bt.sb.sm.StateDelete(bt.stateIDs["out.period"])
+	bt.sb.sm.StateDelete(bt.stateIDs["out.jam"])
 }
 
 func (bt *boxTrip) setOutBetweenJams(v bool) {
 	bt.out.betweenJams = v
-	statemanager.StateUpdateBool(bt.stateIDs["out.betweenJams"], v)
+	bt.sb.sm.StateUpdateBool(bt.stateIDs["out.betweenJams"], v)
 }
 
 func (bt *boxTrip) setOutAfterStarPass(v bool) {
 	bt.out.afterStarPass = v
-	statemanager.StateUpdateBool(bt.stateIDs["out.afterStarPass"], v)
+	bt.sb.sm.StateUpdateBool(bt.stateIDs["out.afterStarPass"], v)
+}
+
+// setDuration sets the time (in ms of jam clock time) the skater has spent in the box
+func (bt *boxTrip) setDuration(v int64) {
+	bt.duration = v
+	bt.sb.sm.StateUpdateInt64(bt.stateIDs["duration"], v)
+}
+
+// tickBoxTrips adds d to the duration of every box trip in progress.  Called
+// from masterClock.ticker() while the jam clock is running.
+func (sb *Scoreboard) tickBoxTrips(d int64) {
+	for _, t := range sb.teams {
+		for _, s := range t.skaters {
+			if s.curBoxTrip != nil {
+				s.curBoxTrip.setDuration(s.curBoxTrip.duration + d)
+			}
+		}
+	}
+}
+
+// mergeBoxTrips moves the box trips of from to s, in the order the skaters
+// went to the box
+func (s *skater) mergeBoxTrips(from *skater) {
+	trips := append(append([]*boxTrip(nil), s.boxTrips...), from.boxTrips...)
+	sort.SliceStable(trips, func(i, j int) bool { return trips[i].in.jamIdx < trips[j].in.jamIdx })
+	cur := s.curBoxTrip
+	if cur == nil {
+		cur = from.curBoxTrip
+	}
+
+	for idx := range s.boxTrips {
+		s.t.sb.sm.StateDelete(fmt.Sprintf("%v.BoxTrip(%v)", s.base, idx))
+	}
+	s.boxTrips = nil
+	s.curBoxTrip = nil
+	for _, bt := range trips {
+		nbt := newBoxTrip(s, bt.in.jamIdx, bt.in.betweenJams, bt.in.afterStarPass)
+		nbt.setOutJamIdx(bt.out.jamIdx)
+		nbt.setOutBetweenJams(bt.out.betweenJams)
+		nbt.setOutAfterStarPass(bt.out.afterStarPass)
+		nbt.setDuration(bt.duration)
+		s.boxTrips = append(s.boxTrips, nbt)
+		if bt == cur {
+			s.curBoxTrip = nbt
+		}
+	}
+}
+
+// removeBoxTrip removes a box trip, and any after it, whose keys were
+// removed by a rollback or journal replay
+func (t *team) removeBoxTrip(k string) {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 3 {
+		return
+	}
+	s, ok := t.skaters[ids[1]]
+	if !ok {
+		return
+	}
+	idx, err := strconv.Atoi(ids[2])
+	if err != nil || idx < 0 || idx >= len(s.boxTrips) {
+		return
+	}
+	for i, bt := range s.boxTrips[idx:] {
+		if bt == s.curBoxTrip {
+			s.curBoxTrip = nil
+		}
+		t.sb.sm.StateDelete(fmt.Sprintf("%v.BoxTrip(%v)", s.base, idx+i))
+	}
+	s.boxTrips = s.boxTrips[:idx]
+	t.updateStats()
+}
+
+// resumeBoxTrip sets the skater's current box trip to the last one if the
+// skater has not left the box
+func (s *skater) resumeBoxTrip() {
+	s.curBoxTrip = nil
+	if n := len(s.boxTrips); n > 0 && s.boxTrips[n-1].out.jamIdx < 0 {
+		s.curBoxTrip = s.boxTrips[n-1]
+	}
 }
 
-/* Helper functions to find the jam for RegisterUpdaters */
+/* Helper functions to find the box trip for RegisterUpdaters */
 func (s *skater) findBoxTrip(k string) *boxTrip {
 	ids := statemanager.ParseIDs(k)
-	if len(ids) == 0 {
+	if len(ids) < 3 {
 		return nil
 	}
-	id, err := strconv.ParseInt(ids[0], 10, 64)
-	if err != nil {
+	id, err := strconv.ParseInt(ids[2], 10, 64)
+	if err != nil || id < 0 || id > int64(len(s.boxTrips)) {
 		return nil
 	}
 
-	// Generate blank snapshots if needed
-	for i := int64(len(s.boxTrips)); i <= id; i++ {
+	// Generate a blank box trip for the next index
+	if id == int64(len(s.boxTrips)) {
 		s.boxTrips = append(s.boxTrips, blankBoxTrip(s))
 	}
 
 	return s.boxTrips[id]
 }
+
+func (t *team) updateBoxTrip(k string, f func(bt *boxTrip)) error {
+	if s := t.findSkater(k); s != nil {
+		if bt := s.findBoxTrip(k); bt != nil {
+			f(bt)
+			s.resumeBoxTrip()
+			return nil
+		}
+		return errBoxTripNotFound
+	}
+	return errSkaterNotFound
+}
+
+func (t *team) bSetSkater(k, v string) error {
+	return t.updateBoxTrip(k, func(bt *boxTrip) { bt.setSkater(bt.s) })
+}
+func (t *team) bSetInJamIdx(k string, v int64) error {
+	return t.updateBoxTrip(k, func(bt *boxTrip) { bt.setInJamIdx(v) })
+}
+func (t *team) bSetInBetweenJams(k string, v bool) error {
+	return t.updateBoxTrip(k, func(bt *boxTrip) { bt.setInBetweenJams(v) })
+}
+func (t *team) bSetInAfterStarPass(k string, v bool) error {
+	return t.updateBoxTrip(k, func(bt *boxTrip) { bt.setInAfterStarPass(v) })
+}
+func (t *team) bSetOutJamIdx(k string, v int64) error {
+	return t.updateBoxTrip(k, func(bt *boxTrip) { bt.setOutJamIdx(v) })
+}
+func (t *team) bSetOutBetweenJams(k string, v bool) error {
+	return t.updateBoxTrip(k, func(bt *boxTrip) { bt.setOutBetweenJams(v) })
+}
+func (t *team) bSetOutAfterStarPass(k string, v bool) error {
+	return t.updateBoxTrip(k, func(bt *boxTrip) { bt.setOutAfterStarPass(v) })
+}
+func (t *team) bSetDuration(k string, v int64) error {
+	return t.updateBoxTrip(k, func(bt *boxTrip) { bt.setDuration(v) })
+}
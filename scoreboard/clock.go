@@ -32,8 +32,8 @@ func newClock(sb *Scoreboard, name string, numMin, numMax, timeMin, timeMax int6
 		sb:   sb,
 		base: fmt.Sprintf("%s.Clock(%s)", sb.stateBase(), name),
 	}
-	c.number = newMinMaxNumber(c, "Number", false, numMin, numMax, numMin, 1)
-	c.time = newMinMaxNumber(c, "Time", countdown, timeMin, timeMax, timeNum, 1000)
+	c.number = newMinMaxNumber(sb.sm, c, "Number", false, numMin, numMax, numMin, 1)
+	c.time = newMinMaxNumber(sb.sm, c, "Time", countdown, timeMin, timeMax, timeNum, 1000)
 	c.stateIDs = make(map[string]string)
 
 	c.stateIDs["name"] = c.base + ".Name"
@@ -41,12 +41,18 @@ func newClock(sb *Scoreboard, name string, numMin, numMax, timeMin, timeMax int6
 	c.stateIDs["running"] = c.base + ".Running"
 	c.stateIDs["adjustable"] = c.base + ".Adjustable"
 
-	statemanager.RegisterCommand(c.time.stateIDs["num"]+".Inc", c.incTime)
-	statemanager.RegisterCommand(c.time.stateIDs["num"]+".Dec", c.decTime)
+	sb.sm.RegisterCommand(c.time.stateIDs["num"]+".Inc", c.incTime)
+	sb.sm.RegisterCommand(c.time.stateIDs["num"]+".Dec", c.decTime)
+	sb.sm.DescribeCommand(c.time.stateIDs["num"]+".Inc", "Adds a second to the clock")
+	sb.sm.DescribeCommand(c.time.stateIDs["num"]+".Dec", "Takes a second from the clock")
 
-	statemanager.RegisterUpdaterString(c.stateIDs["name"], 0, c.setName)
-	statemanager.RegisterUpdaterBool(c.stateIDs["countdown"], 4, c.setCountDown)
-	statemanager.RegisterUpdaterBool(c.stateIDs["running"], 4, c.setRunning)
+	sb.sm.RegisterUpdaterString(c.stateIDs["name"], 0, c.setName)
+	sb.sm.RegisterUpdaterBool(c.stateIDs["countdown"], 4, c.setCountDown)
+	sb.sm.RegisterUpdaterBool(c.stateIDs["running"], 4, c.setRunning)
+	sb.sm.RegisterKey(c.stateIDs["name"], statemanager.TypeString, "Name of the clock")
+	sb.sm.RegisterKey(c.stateIDs["countdown"], statemanager.TypeBool, "Set if the clock counts down")
+	sb.sm.RegisterKey(c.stateIDs["running"], statemanager.TypeBool, "Set if the clock is running")
+	sb.sm.RegisterKey(c.stateIDs["adjustable"], statemanager.TypeBool, "Set if the clock time can be adjusted")
 
 	c.setName(name)
 	c.setCountDown(countdown)
@@ -62,20 +68,20 @@ func (c *clock) stateBase() string {
 
 func (c *clock) setName(name string) error {
 	c.name = name
-	statemanager.StateUpdateString(c.stateIDs["name"], name)
+	c.sb.sm.StateUpdateString(c.stateIDs["name"], name)
 	return nil
 }
 
 func (c *clock) setCountDown(countdown bool) error {
 	c.countdown = countdown
-	statemanager.StateUpdateBool(c.stateIDs["countdown"], countdown)
+	c.sb.sm.StateUpdateBool(c.stateIDs["countdown"], countdown)
 	c.time.setCountDown(countdown)
 	return nil
 }
 
 func (c *clock) setRunning(running bool) error {
 	c.running = running
-	statemanager.StateUpdateBool(c.stateIDs["running"], running)
+	c.sb.sm.StateUpdateBool(c.stateIDs["running"], running)
 	return nil
 }
 
@@ -103,12 +109,12 @@ func (c *clock) isRunning() bool {
 
 func (c *clock) start() {
 	c.running = true
-	statemanager.StateUpdateBool(c.stateIDs["running"], c.running)
+	c.sb.sm.StateUpdateBool(c.stateIDs["running"], c.running)
 }
 
 func (c *clock) stop() {
 	c.running = false
-	statemanager.StateUpdateBool(c.stateIDs["running"], c.running)
+	c.sb.sm.StateUpdateBool(c.stateIDs["running"], c.running)
 }
 
 // returns true if clock timedout
@@ -122,7 +128,7 @@ func (c *clock) tick(tickDuration int64) bool {
 
 func (c *clock) setAdjustable(adjustable bool) {
 	c.adjustable = adjustable
-	statemanager.StateUpdateBool(c.stateIDs["adjustable"], adjustable)
+	c.sb.sm.StateUpdateBool(c.stateIDs["adjustable"], adjustable)
 }
 
 func (c *clock) clone() *clock {
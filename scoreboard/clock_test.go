@@ -240,8 +240,8 @@ func TestClockOffset(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		m := &clock{nil, "master", "master", nil, &minMaxNumber{nil, "", tc.masterCountdown, tc.masterTime, 0, 90000, 1000, nil}, tc.masterCountdown, true, false, nil}
-		s := &clock{nil, "slave", "slave", nil, &minMaxNumber{nil, "", tc.slaveCountdown, tc.slaveTime, 0, 90000, 1000, nil}, tc.slaveCountdown, true, false, nil}
+		m := &clock{nil, "master", "master", nil, &minMaxNumber{nil, "", tc.masterCountdown, tc.masterTime, 0, 90000, 1000, nil, nil}, tc.masterCountdown, true, false, nil}
+		s := &clock{nil, "slave", "slave", nil, &minMaxNumber{nil, "", tc.slaveCountdown, tc.slaveTime, 0, 90000, 1000, nil, nil}, tc.slaveCountdown, true, false, nil}
 
 		offset := calculateClockOffset(m, s)
 		if offset != tc.expectedOffset {
@@ -6,28 +6,37 @@
 package scoreboard
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 
 	"github.com/rollerderby/crg/statemanager"
 )
 
+var errJamNotFound = errors.New("Jam Not Found")
+var errJamTeamNotFound = errors.New("Jam Team Not Found")
+var errJamBlockerNotFound = errors.New("Jam Blocker Not Found")
+
 type jam struct {
 	sb       *Scoreboard
 	lastJam  *jam
 	idx      int64
 	period   int64
 	jam      int64
+	started  bool
 	base     string
 	teams    [2]jamTeam
 	stateIDs map[string]string
 }
 
 type jamTeam struct {
-	jammer   string
-	pivot    string
-	blockers []string
-	scores   [9]int64
+	jammer     string
+	pivot      string
+	blockers   []string
+	scores     [9]int64
+	startScore int64
+	score      int64
+	lead       string
 }
 
 func blankJam(sb *Scoreboard) *jam {
@@ -41,9 +50,11 @@ func blankJam(sb *Scoreboard) *jam {
 	j.stateIDs["idx"] = j.base + ".Idx"
 	j.stateIDs["period"] = j.base + ".Period"
 	j.stateIDs["jam"] = j.base + ".Jam"
+	j.stateIDs["started"] = j.base + ".Started"
 
 	j.setPeriod(0)
 	j.setJam(0)
+	j.setStarted(false)
 
 	j.lastJam = sb.activeJam
 	sb.jams = append(sb.jams, j)
@@ -63,27 +74,37 @@ func newJam(sb *Scoreboard) *jam {
 		j.setJam(sb.masterClock.jam.number.num + 1)
 	}
 
+	// Skaters keep their positions from the last jam until changed
+	for _, t := range sb.teams {
+		t.updatePositions()
+	}
+
 	return j
 }
 
 func (j *jam) updateJam() {
 	j.setPeriod(j.sb.masterClock.period.number.num)
 	j.setJam(j.sb.masterClock.jam.number.num)
+	for _, t := range j.sb.teams {
+		j.setTeamStartScore(t, t.score)
+		j.setTeamScore(t, 0)
+	}
+	j.setStarted(true)
 }
 
 func (j *jam) delete() {
-	statemanager.StateDelete(j.base)
+	j.sb.sm.StateDelete(j.base)
 	j.sb = nil
 }
 
 func (j *jam) clearTeamPositions(t *team) {
 	base := fmt.Sprintf("%v.Team(%v)", j.base, t.id)
 	j.teams[t.id-1].jammer = ""
-	statemanager.StateDelete(base + ".Jammer")
+	j.sb.sm.StateDelete(base + ".Jammer")
 	j.teams[t.id-1].pivot = ""
-	statemanager.StateDelete(base + ".Pivot")
+	j.sb.sm.StateDelete(base + ".Pivot")
 	for idx, _ := range j.teams[t.id-1].blockers {
-		statemanager.StateDelete(fmt.Sprintf("%v.Blocker(%v)", base, idx))
+		j.sb.sm.StateDelete(fmt.Sprintf("%v.Blocker(%v)", base, idx))
 	}
 	j.teams[t.id-1].blockers = nil
 }
@@ -93,24 +114,112 @@ func (j *jam) setTeamPosition(s *skater) {
 	switch s.position {
 	case positionJammer:
 		j.teams[s.t.id-1].jammer = s.id
-		statemanager.StateUpdateString(base+".Jammer", s.id)
+		j.sb.sm.StateUpdateString(base+".Jammer", s.id)
 	case positionPivot:
-		j.teams[s.t.id-1].jammer = s.id
-		statemanager.StateUpdateString(base+".Pivot", s.id)
+		j.teams[s.t.id-1].pivot = s.id
+		j.sb.sm.StateUpdateString(base+".Pivot", s.id)
 	case positionBlocker:
 		j.teams[s.t.id-1].blockers = append(j.teams[s.t.id-1].blockers, s.id)
-		statemanager.StateUpdateString(fmt.Sprintf("%v.Blocker(%v)", base, len(j.teams[s.t.id-1].blockers)-1), s.id)
+		j.sb.sm.StateUpdateString(fmt.Sprintf("%v.Blocker(%v)", base, len(j.teams[s.t.id-1].blockers)-1), s.id)
+	}
+}
+
+func (j *jam) renameSkater(t *team, from, to string) {
+	base := fmt.Sprintf("%v.Team(%v)", j.base, t.id)
+	jt := &j.teams[t.id-1]
+	if jt.jammer == from {
+		jt.jammer = to
+		j.sb.sm.StateUpdateString(base+".Jammer", to)
+	}
+	if jt.pivot == from {
+		jt.pivot = to
+		j.sb.sm.StateUpdateString(base+".Pivot", to)
+	}
+	for idx, id := range jt.blockers {
+		if id == from {
+			jt.blockers[idx] = to
+			j.sb.sm.StateUpdateString(fmt.Sprintf("%v.Blocker(%v)", base, idx), to)
+		}
 	}
 }
 
 func (j *jam) setPeriod(v int64) error {
 	j.period = v
-	return statemanager.StateUpdateInt64(j.stateIDs["period"], v)
+	return j.sb.sm.StateUpdateInt64(j.stateIDs["period"], v)
 }
 
 func (j *jam) setJam(v int64) error {
 	j.jam = v
-	return statemanager.StateUpdateInt64(j.stateIDs["jam"], v)
+	return j.sb.sm.StateUpdateInt64(j.stateIDs["jam"], v)
+}
+
+func (j *jam) setStarted(v bool) error {
+	j.started = v
+	return j.sb.sm.StateUpdateBool(j.stateIDs["started"], v)
+}
+
+func (j *jam) setTeamScore(t *team, v int64) {
+	j.teams[t.id-1].score = v
+	j.sb.sm.StateUpdateInt64(fmt.Sprintf("%v.Team(%v).Score", j.base, t.id), v)
+}
+
+func (j *jam) setTeamStartScore(t *team, v int64) {
+	j.teams[t.id-1].startScore = v
+	j.sb.sm.StateUpdateInt64(fmt.Sprintf("%v.Team(%v).StartScore", j.base, t.id), v)
+}
+
+func (j *jam) setTeamLead(t *team, v string) {
+	j.teams[t.id-1].lead = v
+	j.sb.sm.StateUpdateString(fmt.Sprintf("%v.Team(%v).Lead", j.base, t.id), v)
+}
+
+func (j *jam) setTeamJammer(t *team, v string) {
+	j.teams[t.id-1].jammer = v
+	j.sb.sm.StateUpdateString(fmt.Sprintf("%v.Team(%v).Jammer", j.base, t.id), v)
+}
+
+func (j *jam) setTeamPivot(t *team, v string) {
+	j.teams[t.id-1].pivot = v
+	j.sb.sm.StateUpdateString(fmt.Sprintf("%v.Team(%v).Pivot", j.base, t.id), v)
+}
+
+func (j *jam) setTeamBlocker(t *team, idx int, v string) {
+	jt := &j.teams[t.id-1]
+	for len(jt.blockers) <= idx {
+		jt.blockers = append(jt.blockers, "")
+	}
+	jt.blockers[idx] = v
+	j.sb.sm.StateUpdateString(fmt.Sprintf("%v.Team(%v).Blocker(%v)", j.base, t.id, idx), v)
+}
+
+// scoringJam returns the last jam that has started.  Points entered during
+// the following lineup are credited to it.
+func (sb *Scoreboard) scoringJam() *jam {
+	for j := sb.activeJam; j != nil; j = j.lastJam {
+		if j.started {
+			return j
+		}
+	}
+	return nil
+}
+
+// removeJam removes a jam, and any after it, whose keys were removed by a
+// rollback or journal replay.  The first jam is kept as the scoreboard
+// always has an active jam.
+func (sb *Scoreboard) removeJam(k string) {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) == 0 {
+		return
+	}
+	idx, err := strconv.Atoi(ids[0])
+	if err != nil || idx < 1 || idx >= len(sb.jams) {
+		return
+	}
+	for _, j := range sb.jams[idx:] {
+		sb.sm.StateDelete(j.base)
+	}
+	sb.jams = sb.jams[:idx]
+	sb.activeJam = sb.jams[idx-1]
 }
 
 /* helper functions to find the jam for registerupdaters */
@@ -120,14 +229,113 @@ func (sb *Scoreboard) findJam(k string) *jam {
 		return nil
 	}
 	id, err := strconv.ParseInt(ids[0], 10, 64)
-	if err != nil {
+	if err != nil || id < 0 || id > int64(len(sb.jams)) {
 		return nil
 	}
 
-	// generate blank snapshots if needed
-	for i := int64(len(sb.jams)); i <= id; i++ {
-		sb.jams = append(sb.jams, blankJam(sb))
+	// generate a blank jam for the next index
+	if id == int64(len(sb.jams)) {
+		blankJam(sb)
 	}
 
 	return sb.jams[id]
 }
+
+func (sb *Scoreboard) findJamTeam(k string) *team {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 2 {
+		return nil
+	}
+	id, err := strconv.Atoi(ids[1])
+	if err != nil || id < 1 || id > len(sb.teams) {
+		return nil
+	}
+	return sb.teams[id-1]
+}
+
+func (sb *Scoreboard) jSetPeriod(k string, v int64) error {
+	if j := sb.findJam(k); j != nil {
+		j.setPeriod(v)
+		return nil
+	}
+	return errJamNotFound
+}
+func (sb *Scoreboard) jSetJam(k string, v int64) error {
+	if j := sb.findJam(k); j != nil {
+		j.setJam(v)
+		return nil
+	}
+	return errJamNotFound
+}
+func (sb *Scoreboard) jSetStarted(k string, v bool) error {
+	if j := sb.findJam(k); j != nil {
+		j.setStarted(v)
+		return nil
+	}
+	return errJamNotFound
+}
+func (sb *Scoreboard) jtSetStartScore(k string, v int64) error {
+	if j := sb.findJam(k); j != nil {
+		if t := sb.findJamTeam(k); t != nil {
+			j.setTeamStartScore(t, v)
+			return nil
+		}
+		return errJamTeamNotFound
+	}
+	return errJamNotFound
+}
+func (sb *Scoreboard) jtSetScore(k string, v int64) error {
+	if j := sb.findJam(k); j != nil {
+		if t := sb.findJamTeam(k); t != nil {
+			j.setTeamScore(t, v)
+			return nil
+		}
+		return errJamTeamNotFound
+	}
+	return errJamNotFound
+}
+func (sb *Scoreboard) jtSetLead(k string, v string) error {
+	if j := sb.findJam(k); j != nil {
+		if t := sb.findJamTeam(k); t != nil {
+			j.setTeamLead(t, v)
+			return nil
+		}
+		return errJamTeamNotFound
+	}
+	return errJamNotFound
+}
+func (sb *Scoreboard) jtSetJammer(k string, v string) error {
+	if j := sb.findJam(k); j != nil {
+		if t := sb.findJamTeam(k); t != nil {
+			j.setTeamJammer(t, v)
+			return nil
+		}
+		return errJamTeamNotFound
+	}
+	return errJamNotFound
+}
+func (sb *Scoreboard) jtSetPivot(k string, v string) error {
+	if j := sb.findJam(k); j != nil {
+		if t := sb.findJamTeam(k); t != nil {
+			j.setTeamPivot(t, v)
+			return nil
+		}
+		return errJamTeamNotFound
+	}
+	return errJamNotFound
+}
+func (sb *Scoreboard) jtSetBlocker(k string, v string) error {
+	if j := sb.findJam(k); j != nil {
+		if t := sb.findJamTeam(k); t != nil {
+			ids := statemanager.ParseIDs(k)
+			idx, err := strconv.Atoi(ids[len(ids)-1])
+			if err != nil || idx < 0 || idx > len(j.teams[t.id-1].blockers) {
+				return errJamBlockerNotFound
+			}
+			j.setTeamBlocker(t, idx, v)
+			return nil
+		}
+		return errJamTeamNotFound
+	}
+	return errJamNotFound
+}
@@ -107,8 +107,10 @@ func newMasterClock(sb *Scoreboard) *masterClock {
 	mc.stateIDs["startTime"] = sb.stateBase() + ".MasterClock.StartTime"
 	mc.stateIDs["ticks"] = sb.stateBase() + ".MasterClock.Ticks"
 
-	statemanager.RegisterUpdaterTime(mc.stateIDs["startTime"], 0, mc.setStartTime)
-	statemanager.RegisterUpdaterInt64(mc.stateIDs["ticks"], 0, mc.setTicks)
+	sb.sm.RegisterUpdaterTime(mc.stateIDs["startTime"], 0, mc.setStartTime)
+	sb.sm.RegisterUpdaterInt64(mc.stateIDs["ticks"], 0, mc.setTicks)
+	sb.sm.RegisterKey(mc.stateIDs["startTime"], statemanager.TypeTime, "Wall clock time the master clock started")
+	sb.sm.RegisterKey(mc.stateIDs["ticks"], statemanager.TypeInt64, "Milliseconds the master clock has run")
 
 	go mc.tickClocks()
 
@@ -145,6 +147,7 @@ func (mc *masterClock) ticker() {
 	}
 	for i := int64(0); i < ticksToDo; i++ {
 		clockExpired := false
+		jamRunning := mc.jam.isRunning()
 		for _, c := range clocks {
 			if c.isRunning() {
 				if c.tick(clockTimeTick) {
@@ -152,6 +155,9 @@ func (mc *masterClock) ticker() {
 				}
 			}
 		}
+		if jamRunning {
+			mc.sb.tickBoxTrips(clockTimeTick)
+		}
 		mc.setTicks(mc.ticks + 1)
 		mc.sb.activeSnapshot.updateLength()
 		if clockExpired {
@@ -163,21 +169,21 @@ func (mc *masterClock) ticker() {
 func (mc *masterClock) tickClocks() {
 	ticker := time.NewTicker(durationPerTick)
 	for range ticker.C {
-		statemanager.Lock()
+		mc.sb.sm.Lock()
 		mc.ticker()
-		statemanager.Unlock()
+		mc.sb.sm.Unlock()
 	}
 }
 
 func (mc *masterClock) setStartTime(v time.Time) error {
 	mc.startTime = v
-	statemanager.StateUpdateTime(mc.stateIDs["startTime"], v)
+	mc.sb.sm.StateUpdateTime(mc.stateIDs["startTime"], v)
 	return nil
 }
 
 func (mc *masterClock) setTicks(v int64) error {
 	mc.ticks = v
-	statemanager.StateUpdateInt64(mc.stateIDs["ticks"], v)
+	mc.sb.sm.StateUpdateInt64(mc.stateIDs["ticks"], v)
 	return nil
 }
 
@@ -20,10 +20,12 @@ type minMaxNumber struct {
 	max       int64
 	updateOn  int64
 	stateIDs  map[string]string
+	sm        *statemanager.StateManager
 }
 
-func newMinMaxNumber(p parent, id string, countdown bool, min, max, num, updateOn int64) *minMaxNumber {
+func newMinMaxNumber(sm *statemanager.StateManager, p parent, id string, countdown bool, min, max, num, updateOn int64) *minMaxNumber {
 	mmn := &minMaxNumber{
+		sm:        sm,
 		parent:    p,
 		base:      fmt.Sprintf("%s.%s", p.stateBase(), id),
 		countdown: countdown,
@@ -36,9 +38,13 @@ func newMinMaxNumber(p parent, id string, countdown bool, min, max, num, updateO
 	mmn.stateIDs["num"] = mmn.base + ".Num"
 	mmn.stateIDs["precise"] = mmn.base + ".PreciseNum"
 
-	statemanager.RegisterUpdaterInt64(mmn.stateIDs["min"], 1, mmn.setMin)
-	statemanager.RegisterUpdaterInt64(mmn.stateIDs["max"], 2, mmn.setMax)
-	statemanager.RegisterUpdaterInt64(mmn.stateIDs["precise"], 3, mmn.setNum)
+	sm.RegisterUpdaterInt64(mmn.stateIDs["min"], 1, mmn.setMin)
+	sm.RegisterUpdaterInt64(mmn.stateIDs["max"], 2, mmn.setMax)
+	sm.RegisterUpdaterInt64(mmn.stateIDs["precise"], 3, mmn.setNum)
+	sm.RegisterKey(mmn.stateIDs["min"], statemanager.TypeInt64, "Minimum "+id)
+	sm.RegisterKey(mmn.stateIDs["max"], statemanager.TypeInt64, "Maximum "+id)
+	sm.RegisterKey(mmn.stateIDs["num"], statemanager.TypeInt64, id+", rounded for display")
+	sm.RegisterKey(mmn.stateIDs["precise"], statemanager.TypeInt64, id)
 
 	mmn.setMin(min)
 	mmn.setMax(max)
@@ -48,7 +54,7 @@ func newMinMaxNumber(p parent, id string, countdown bool, min, max, num, updateO
 }
 
 func (mmn *minMaxNumber) sendNumStateUpdate() {
-	statemanager.StateUpdateInt64(mmn.stateIDs["precise"], mmn.num)
+	mmn.sm.StateUpdateInt64(mmn.stateIDs["precise"], mmn.num)
 	diff := mmn.num % mmn.updateOn
 	if mmn.countdown {
 		diff = -((mmn.updateOn - diff) % mmn.updateOn)
@@ -60,7 +66,7 @@ func (mmn *minMaxNumber) sendNumStateUpdate() {
 	} else if num > mmn.max {
 		num = mmn.max
 	}
-	statemanager.StateUpdateInt64(mmn.stateIDs["num"], num)
+	mmn.sm.StateUpdateInt64(mmn.stateIDs["num"], num)
 }
 
 func (mmn *minMaxNumber) adjust(down bool, adjust int64) bool {
@@ -96,7 +102,7 @@ func (mmn *minMaxNumber) setMin(v int64) error {
 		mmn.setNum(mmn.num)
 	}
 
-	statemanager.StateUpdateInt64(mmn.stateIDs["min"], mmn.min)
+	mmn.sm.StateUpdateInt64(mmn.stateIDs["min"], mmn.min)
 	return nil
 }
 
@@ -109,7 +115,7 @@ func (mmn *minMaxNumber) setMax(v int64) error {
 		mmn.setNum(mmn.max)
 	}
 
-	statemanager.StateUpdateInt64(mmn.stateIDs["max"], mmn.max)
+	mmn.sm.StateUpdateInt64(mmn.stateIDs["max"], mmn.max)
 	return nil
 }
 
@@ -0,0 +1,110 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package scoreboard
+
+import (
+	"errors"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+// official is a member of the officiating crew (referee or NSO) for the game
+type official struct {
+	sb       *Scoreboard
+	id       string
+	base     string
+	name     string
+	role     string
+	stateIDs map[string]string
+}
+
+var errOfficialNotFound = errors.New("Official Not Found")
+
+func blankOfficial(sb *Scoreboard, id string) *official {
+	o := &official{
+		sb:       sb,
+		id:       id,
+		base:     sb.stateBase() + ".Official(" + id + ")",
+		stateIDs: make(map[string]string),
+	}
+
+	o.stateIDs["id"] = o.base + ".ID"
+	o.stateIDs["name"] = o.base + ".Name"
+	o.stateIDs["role"] = o.base + ".Role"
+
+	sb.sm.StateUpdateString(o.stateIDs["id"], id)
+	o.setName("")
+	o.setRole("")
+
+	return o
+}
+
+func (o *official) setName(v string) error {
+	o.name = v
+	return o.sb.sm.StateUpdateString(o.stateIDs["name"], v)
+}
+
+func (o *official) setRole(v string) error {
+	o.role = v
+	return o.sb.sm.StateUpdateString(o.stateIDs["role"], v)
+}
+
+// deleteOfficial is the Scoreboard.DeleteOfficial command.
+// data: [officialID]
+func (sb *Scoreboard) deleteOfficial(data []string) error {
+	if len(data) < 1 {
+		return errOfficialNotFound
+	}
+	if _, ok := sb.officials[data[0]]; !ok {
+		return errOfficialNotFound
+	}
+	delete(sb.officials, data[0])
+	return sb.sm.StateDelete(sb.stateBase() + ".Official(" + data[0] + ")")
+}
+
+// removeOfficial removes an official whose keys were removed by a rollback
+// or journal replay
+func (sb *Scoreboard) removeOfficial(k string) {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 1 {
+		return
+	}
+	if o, ok := sb.officials[ids[0]]; ok {
+		delete(sb.officials, ids[0])
+		sb.sm.StateDelete(o.base)
+	}
+}
+
+/* Helper functions to find the official for RegisterUpdaters */
+func (sb *Scoreboard) findOfficial(k string) *official {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 1 {
+		return nil
+	}
+	id := ids[0]
+
+	o, ok := sb.officials[id]
+	if !ok {
+		o = blankOfficial(sb, id)
+		sb.officials[id] = o
+	}
+	return o
+}
+
+func (sb *Scoreboard) oSetName(k, v string) error {
+	if o := sb.findOfficial(k); o != nil {
+		o.setName(v)
+		return nil
+	}
+	return errOfficialNotFound
+}
+func (sb *Scoreboard) oSetRole(k, v string) error {
+	if o := sb.findOfficial(k); o != nil {
+		o.setRole(v)
+		return nil
+	}
+	return errOfficialNotFound
+}
@@ -0,0 +1,196 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package scoreboard
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+type penalty struct {
+	sb       *Scoreboard
+	s        *skater
+	base     string
+	code     string
+	jamIdx   int64
+	stateIDs map[string]string
+}
+
+var errPenaltyNotFound = errors.New("Penalty Not Found")
+
+func blankPenalty(s *skater, idx int64) *penalty {
+	p := &penalty{
+		sb:       s.t.sb,
+		s:        s,
+		base:     fmt.Sprintf("%v.Penalty(%v)", s.base, idx),
+		stateIDs: make(map[string]string),
+	}
+
+	p.stateIDs["code"] = p.base + ".Code"
+	p.stateIDs["jamIdx"] = p.base + ".JamIdx"
+	p.stateIDs["period"] = p.base + ".Period"
+	p.stateIDs["jam"] = p.base + ".Jam"
+
+	return p
+}
+
+func newPenalty(s *skater, idx int64, code string, jamIdx int64) *penalty {
+	p := blankPenalty(s, idx)
+
+	p.setCode(code)
+	p.setJamIdx(jamIdx)
+
+	return p
+}
+
+func (p *penalty) setCode(v string) {
+	p.code = v
+	p.sb.sm.StateUpdateString(p.stateIDs["code"], v)
+}
+
+func (p *penalty) setJamIdx(v int64) {
+	if v >= 0 && v < int64(len(p.sb.jams)) {
+		jam := p.sb.jams[v]
+		p.jamIdx = jam.idx
+		p.sb.sm.StateUpdateInt64(p.stateIDs["jamIdx"], jam.idx)
+		p.sb.sm.StateUpdateInt64(p.stateIDs["period"], jam.period)
+		p.sb.sm.StateUpdateInt64(p.stateIDs["jam"], jam.jam)
+		return
+	}
+
+	p.jamIdx = -1
+	p.sb.sm.StateDelete(p.stateIDs["jamIdx"])
+	p.sb.sm.StateDelete(p.stateIDs["period"])
+	p.sb.sm.StateDelete(p.stateIDs["jam"])
+}
+
+// addPenalty is the Scoreboard.Team(*).AddPenalty command.  The penalty is
+// recorded against the current jam.  Returns the key of the new penalty.
+// data: [skaterID, code]
+func (t *team) addPenalty(data []string) (interface{}, error) {
+	if len(data) < 2 {
+		return nil, errPenaltyNotFound
+	}
+	s, ok := t.skaters[data[0]]
+	if !ok {
+		return nil, errSkaterNotFound
+	}
+	p := newPenalty(s, int64(len(s.penalties)), data[1], int64(len(t.sb.jams)-1))
+	s.penalties = append(s.penalties, p)
+	t.updateStats()
+	return p.base, nil
+}
+
+// deletePenalty is the Scoreboard.Team(*).DeletePenalty command.
+// data: [skaterID, penaltyIdx]
+func (t *team) deletePenalty(data []string) error {
+	if len(data) < 2 {
+		return errPenaltyNotFound
+	}
+	s, ok := t.skaters[data[0]]
+	if !ok {
+		return errSkaterNotFound
+	}
+	idx, err := strconv.Atoi(data[1])
+	if err != nil || idx < 0 || idx >= len(s.penalties) {
+		return errPenaltyNotFound
+	}
+
+	// Renumber the remaining penalties
+	old := s.penalties
+	s.penalties = nil
+	for _, p := range old {
+		t.sb.sm.StateDelete(p.base)
+	}
+	for i, p := range old {
+		if i != idx {
+			s.penalties = append(s.penalties, newPenalty(s, int64(len(s.penalties)), p.code, p.jamIdx))
+		}
+	}
+	t.updateStats()
+	return nil
+}
+
+// mergePenalties moves the penalties of from to s, in the order they were
+// called
+func (s *skater) mergePenalties(from *skater) {
+	penalties := append(append([]*penalty(nil), s.penalties...), from.penalties...)
+	sort.SliceStable(penalties, func(i, j int) bool { return penalties[i].jamIdx < penalties[j].jamIdx })
+
+	for _, p := range s.penalties {
+		s.t.sb.sm.StateDelete(p.base)
+	}
+	s.penalties = nil
+	for _, p := range penalties {
+		s.penalties = append(s.penalties, newPenalty(s, int64(len(s.penalties)), p.code, p.jamIdx))
+	}
+}
+
+// removePenalty removes a penalty, and any after it, whose keys were
+// removed by a rollback or journal replay
+func (t *team) removePenalty(k string) {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 3 {
+		return
+	}
+	s, ok := t.skaters[ids[1]]
+	if !ok {
+		return
+	}
+	idx, err := strconv.Atoi(ids[2])
+	if err != nil || idx < 0 || idx >= len(s.penalties) {
+		return
+	}
+	for _, p := range s.penalties[idx:] {
+		t.sb.sm.StateDelete(p.base)
+	}
+	s.penalties = s.penalties[:idx]
+	t.updateStats()
+}
+
+/* Helper functions to find the penalty for RegisterUpdaters */
+func (s *skater) findPenalty(k string) *penalty {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 3 {
+		return nil
+	}
+	id, err := strconv.ParseInt(ids[2], 10, 64)
+	if err != nil || id < 0 || id > int64(len(s.penalties)) {
+		return nil
+	}
+
+	// Generate a blank penalty for the next index
+	if id == int64(len(s.penalties)) {
+		s.penalties = append(s.penalties, blankPenalty(s, id))
+	}
+
+	return s.penalties[id]
+}
+
+func (t *team) pSetCode(k, v string) error {
+	if s := t.findSkater(k); s != nil {
+		if p := s.findPenalty(k); p != nil {
+			p.setCode(v)
+			return nil
+		}
+		return errPenaltyNotFound
+	}
+	return errSkaterNotFound
+}
+func (t *team) pSetJamIdx(k string, v int64) error {
+	if s := t.findSkater(k); s != nil {
+		if p := s.findPenalty(k); p != nil {
+			p.setJamIdx(v)
+			return nil
+		}
+		return errPenaltyNotFound
+	}
+	return errSkaterNotFound
+}
@@ -6,8 +6,14 @@
 package scoreboard
 
 import (
+	"fmt"
 	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
 
+	"github.com/rollerderby/crg/leagues"
 	"github.com/rollerderby/crg/statemanager"
 )
 
@@ -15,14 +21,17 @@ import (
 // live state for the scoreboard are contained within and exported
 // via github.com/rollerderby/crg/statemanager
 type Scoreboard struct {
-	stateIDs       map[string]string
-	teams          []*team
-	masterClock    *masterClock
-	state          string
-	snapshots      []*stateSnapshot
-	jams           []*jam
-	activeSnapshot *stateSnapshot
-	activeJam      *jam
+	sm              *statemanager.StateManager
+	stateIDs        map[string]string
+	teams           []*team
+	masterClock     *masterClock
+	state           string
+	snapshots       []*stateSnapshot
+	jams            []*jam
+	activeSnapshot  *stateSnapshot
+	activeJam       *jam
+	officials       map[string]*official
+	unregisterMerge func()
 }
 
 const (
@@ -40,47 +49,115 @@ const (
 	stateFinal        = "Final"
 )
 
+// ArchivePath is the directory (relative to the statemanager BaseFilePath)
+// that archived games are written to by the Scoreboard.Archive command
+const ArchivePath = "games"
+
+// RedactArchives leaves personal data out of archived games, unless
+// Scoreboard.Archive is told otherwise
+var RedactArchives = false
+
 type parent interface {
 	stateBase() string
 }
 
-// New initialized a default state for the scoreboard.  Additional setup
-// of the scoreboard is required from either a saved state or via
-// the web interface.  Returns a *Scoreboard
-func New() *Scoreboard {
-	sb := &Scoreboard{}
+// New initialized a default state for the scoreboard in the StateManager sm.
+// Additional setup of the scoreboard is required from either a saved state
+// or via the web interface.  Returns a *Scoreboard
+func New(sm *statemanager.StateManager) *Scoreboard {
+	sb := &Scoreboard{sm: sm, officials: make(map[string]*official)}
 	sb.teams = append(sb.teams, newTeam(sb, 1), newTeam(sb, 2))
 	sb.masterClock = newMasterClock(sb)
 
 	sb.stateIDs = make(map[string]string)
 	sb.stateIDs["state"] = sb.stateBase() + ".State"
 
-	statemanager.RegisterUpdaterString(sb.stateIDs["state"], 0, sb.setState)
-
-	statemanager.RegisterCommand("Scoreboard.StartJam", sb.startJam)
-	statemanager.RegisterCommand("Scoreboard.StopJam", sb.stopJam)
-	statemanager.RegisterCommand("Scoreboard.Timeout", sb.timeout)
-	statemanager.RegisterCommand("Scoreboard.EndTimeout", sb.endTimeout)
-	statemanager.RegisterCommand("Scoreboard.Undo", sb.undo)
-
-	statemanager.RegisterCommand("Scoreboard.Reset", sb.reset)
+	sb.sm.RegisterUpdaterString(sb.stateIDs["state"], 0, sb.setState)
+	sb.sm.RegisterKey(sb.stateIDs["state"], statemanager.TypeString, "State of the game").Enum(stateNotRunning, statePreGame, stateJam, stateLineup, stateOTO, stateTTO1, stateTTO2, stateOR1, stateOR2, stateIntermission, stateUnofficial, stateFinal)
+
+	sb.sm.RegisterCommand("Scoreboard.StartJam", sb.startJam)
+	sb.sm.RegisterCommand("Scoreboard.StopJam", sb.stopJam)
+	sb.sm.RegisterCommand("Scoreboard.Timeout", sb.timeout)
+	sb.sm.RegisterCommand("Scoreboard.EndTimeout", sb.endTimeout)
+	sb.sm.RegisterCommand("Scoreboard.Undo", sb.undo)
+
+	sb.sm.RegisterCommand("Scoreboard.Reset", sb.reset)
+	sb.sm.RegisterCommandResult("Scoreboard.Archive", sb.archive)
+	sb.sm.RegisterCommand("Scoreboard.DeleteOfficial", sb.deleteOfficial)
+	sb.sm.DescribeCommand("Scoreboard.StartJam", "Starts a jam")
+	sb.sm.DescribeCommand("Scoreboard.StopJam", "Stops the jam, lineup or timeout")
+	sb.sm.DescribeCommand("Scoreboard.Timeout", "Starts a timeout",
+		statemanager.Arg{Name: "State", Type: statemanager.TypeString, Description: "Timeout state, defaults to an official timeout", Optional: true})
+	sb.sm.DescribeCommand("Scoreboard.EndTimeout", "Ends the timeout")
+	sb.sm.DescribeCommand("Scoreboard.Undo", "Undoes the last change of state")
+	sb.sm.DescribeCommand("Scoreboard.Reset", "Resets the scoreboard for a new game")
+	sb.sm.DescribeCommand("Scoreboard.Archive", "Saves the game to the archive, returning its name",
+		statemanager.Arg{Name: "Redact", Type: statemanager.TypeBool, Description: "Leave out personal data, defaults to RedactArchives", Optional: true})
+	sb.sm.DescribeCommand("Scoreboard.DeleteOfficial", "Deletes an official",
+		statemanager.Arg{Name: "OfficialID", Type: statemanager.TypeString})
+
+	// Setup Updaters for officials (functions located in official.go)
+	sb.sm.RegisterPatternUpdaterString(sb.stateBase()+".Official(*).Name", 0, sb.oSetName)
+	sb.sm.RegisterPatternUpdaterString(sb.stateBase()+".Official(*).Role", 0, sb.oSetRole)
+	sb.sm.RegisterRemove(sb.stateBase()+".Official(*).ID", sb.removeOfficial)
+	sb.sm.RegisterKey(sb.stateBase()+".Official(*).ID", statemanager.TypeString, "ID of the official")
+	sb.sm.RegisterKey(sb.stateBase()+".Official(*).Name", statemanager.TypeString, "Name of the official").MaxLength(64)
+	sb.sm.RegisterKey(sb.stateBase()+".Official(*).Role", statemanager.TypeString, "Role of the official").MaxLength(64)
+
+	sb.unregisterMerge = leagues.RegisterMergeFunc(sb.mergePerson)
 
 	// Setup Updaters for stateSnapshots (functions located in state_snapshot.go)
-	statemanager.RegisterPatternUpdaterString(sb.stateBase()+".Snapshot(*).State", 0, sb.ssSetState)
-	statemanager.RegisterPatternUpdaterBool(sb.stateBase()+".Snapshot(*).InProgress", 0, sb.ssSetInProgress)
-	statemanager.RegisterPatternUpdaterBool(sb.stateBase()+".Snapshot(*).CanRevert", 0, sb.ssSetCanRevert)
-	statemanager.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).StartTicks", 0, sb.ssSetStartTicks)
-	statemanager.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).EndTicks", 0, sb.ssSetEndTicks)
-	statemanager.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Length", 0, sb.ssSetLength)
-	statemanager.RegisterPatternUpdaterTime(sb.stateBase()+".Snapshot(*).StartTime", 0, sb.ssSetStartTime)
-	statemanager.RegisterPatternUpdaterTime(sb.stateBase()+".Snapshot(*).EndTime", 0, sb.ssSetEndTime)
-	statemanager.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Clock(*).Number", 0, sb.sscSetNumber)
-	statemanager.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Clock(*).StartTime", 0, sb.sscSetStartTime)
-	statemanager.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Clock(*).EndTime", 0, sb.sscSetEndTime)
-	statemanager.RegisterPatternUpdaterBool(sb.stateBase()+".Snapshot(*).Clock(*).Running", 0, sb.sscSetRunning)
-	statemanager.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Team(*).Timeouts", 0, sb.sstSetTimeouts)
-	statemanager.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Team(*).OfficialReviews", 0, sb.sstSetOfficialReviews)
-	statemanager.RegisterPatternUpdaterBool(sb.stateBase()+".Snapshot(*).Team(*).OfficialReviewRetained", 0, sb.sstSetOfficialReviewRetained)
+	sb.sm.RegisterPatternUpdaterString(sb.stateBase()+".Snapshot(*).State", 0, sb.ssSetState)
+	sb.sm.RegisterPatternUpdaterBool(sb.stateBase()+".Snapshot(*).InProgress", 0, sb.ssSetInProgress)
+	sb.sm.RegisterPatternUpdaterBool(sb.stateBase()+".Snapshot(*).CanRevert", 0, sb.ssSetCanRevert)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).StartTicks", 0, sb.ssSetStartTicks)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).EndTicks", 0, sb.ssSetEndTicks)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Length", 0, sb.ssSetLength)
+	sb.sm.RegisterPatternUpdaterTime(sb.stateBase()+".Snapshot(*).StartTime", 0, sb.ssSetStartTime)
+	sb.sm.RegisterPatternUpdaterTime(sb.stateBase()+".Snapshot(*).EndTime", 0, sb.ssSetEndTime)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Clock(*).Number", 0, sb.sscSetNumber)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Clock(*).StartTime", 0, sb.sscSetStartTime)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Clock(*).EndTime", 0, sb.sscSetEndTime)
+	sb.sm.RegisterPatternUpdaterBool(sb.stateBase()+".Snapshot(*).Clock(*).Running", 0, sb.sscSetRunning)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Team(*).Timeouts", 0, sb.sstSetTimeouts)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Snapshot(*).Team(*).OfficialReviews", 0, sb.sstSetOfficialReviews)
+	sb.sm.RegisterPatternUpdaterBool(sb.stateBase()+".Snapshot(*).Team(*).OfficialReviewRetained", 0, sb.sstSetOfficialReviewRetained)
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).State", statemanager.TypeString, "State of the game during the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).InProgress", statemanager.TypeBool, "Set while the snapshot is the current one")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).CanRevert", statemanager.TypeBool, "Set if Scoreboard.Undo can return to the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).StartTicks", statemanager.TypeInt64, "Master clock ticks at the start of the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).EndTicks", statemanager.TypeInt64, "Master clock ticks at the end of the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).Length", statemanager.TypeInt64, "Length of the snapshot in milliseconds")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).StartTime", statemanager.TypeTime, "Wall clock time at the start of the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).EndTime", statemanager.TypeTime, "Wall clock time at the end of the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).Clock(*).Number", statemanager.TypeInt64, "Number of the clock during the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).Clock(*).StartTime", statemanager.TypeInt64, "Time of the clock at the start of the snapshot in milliseconds")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).Clock(*).EndTime", statemanager.TypeInt64, "Time of the clock at the end of the snapshot in milliseconds")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).Clock(*).Running", statemanager.TypeBool, "Set if the clock was running during the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).Team(*).Timeouts", statemanager.TypeInt64, "Timeouts remaining for the team during the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).Team(*).OfficialReviews", statemanager.TypeInt64, "Official reviews remaining for the team during the snapshot")
+	sb.sm.RegisterKey(sb.stateBase()+".Snapshot(*).Team(*).OfficialReviewRetained", statemanager.TypeBool, "Set if the team retained its official review during the snapshot")
+
+	// Setup Updaters for jams (functions located in jam.go)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Jam(*).Period", 0, sb.jSetPeriod)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Jam(*).Jam", 0, sb.jSetJam)
+	sb.sm.RegisterPatternUpdaterBool(sb.stateBase()+".Jam(*).Started", 0, sb.jSetStarted)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Jam(*).Team(*).StartScore", 0, sb.jtSetStartScore)
+	sb.sm.RegisterPatternUpdaterInt64(sb.stateBase()+".Jam(*).Team(*).Score", 0, sb.jtSetScore)
+	sb.sm.RegisterPatternUpdaterString(sb.stateBase()+".Jam(*).Team(*).Lead", 0, sb.jtSetLead)
+	sb.sm.RegisterPatternUpdaterString(sb.stateBase()+".Jam(*).Team(*).Jammer", 0, sb.jtSetJammer)
+	sb.sm.RegisterPatternUpdaterString(sb.stateBase()+".Jam(*).Team(*).Pivot", 0, sb.jtSetPivot)
+	sb.sm.RegisterPatternUpdaterString(sb.stateBase()+".Jam(*).Team(*).Blocker(*)", 0, sb.jtSetBlocker)
+	sb.sm.RegisterRemove(sb.stateBase()+".Jam(*).Period", sb.removeJam)
+	sb.sm.RegisterKey(sb.stateBase()+".Jam(*).Period", statemanager.TypeInt64, "Period the jam was in")
+	sb.sm.RegisterKey(sb.stateBase()+".Jam(*).Jam", statemanager.TypeInt64, "Number of the jam in its period")
+	sb.sm.RegisterKey(sb.stateBase()+".Jam(*).Started", statemanager.TypeBool, "Set once the jam has started")
+	sb.sm.RegisterKey(sb.stateBase()+".Jam(*).Team(*).StartScore", statemanager.TypeInt64, "Score of the team at the start of the jam")
+	sb.sm.RegisterKey(sb.stateBase()+".Jam(*).Team(*).Score", statemanager.TypeInt64, "Points scored by the team in the jam")
+	sb.sm.RegisterKey(sb.stateBase()+".Jam(*).Team(*).Lead", statemanager.TypeString, "Lead status of the team's jammer in the jam: Lead, No or Lost")
+	sb.sm.RegisterKey(sb.stateBase()+".Jam(*).Team(*).Jammer", statemanager.TypeString, "ID of the team's jammer in the jam")
+	sb.sm.RegisterKey(sb.stateBase()+".Jam(*).Team(*).Pivot", statemanager.TypeString, "ID of the team's pivot in the jam")
+	sb.sm.RegisterKey(sb.stateBase()+".Jam(*).Team(*).Blocker(*)", statemanager.TypeString, "ID of one of the team's blockers in the jam")
 
 	sb.reset(nil)
 
@@ -107,11 +184,75 @@ func (sb *Scoreboard) reset(_ []string) error {
 	sb.snapshotStateStart()
 
 	newJam(sb)
+	sb.updateStats()
 	log.Printf("sb.jams: %+v %v", sb.jams, len(sb.jams))
 
 	return nil
 }
 
+// archive writes the current game to games/ (relative to the statemanager
+// BaseFilePath) so it can be used for statistics and reports after the
+// scoreboard has been reset
+func (sb *Scoreboard) archive(data []string) (interface{}, error) {
+	redact := RedactArchives
+	if len(data) > 0 {
+		redact, _ = strconv.ParseBool(data[0])
+	}
+
+	name := fmt.Sprintf("%v_%v_vs_%v",
+		sb.masterClock.startTime.UTC().Format("2006-01-02_150405"),
+		archiveName(sb.teams[0].name),
+		archiveName(sb.teams[1].name))
+	log.Printf("Scoreboard.archive: Archiving game to %v", name)
+	save := sb.sm.SaveState
+	if redact {
+		save = sb.sm.SaveStateRedacted
+	}
+	if err := save(filepath.Join(ArchivePath, name), sb.stateBase()); err != nil {
+		return nil, err
+	}
+	return name, nil
+}
+
+func archiveName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Close stops the Scoreboard from following changes outside its
+// StateManager, such as merged Persons
+func (sb *Scoreboard) Close() {
+	sb.unregisterMerge()
+}
+
+// mergePerson moves skaters referring to the merged Person over to the survivor.
+// When the leagues live on another StateManager the rename is done in the
+// background, as the Scoreboard's StateManager must be locked before theirs,
+// and the returned channel is closed once it is done.
+func (sb *Scoreboard) mergePerson(survivorID, mergedID string) <-chan struct{} {
+	rename := func() {
+		for _, t := range sb.teams {
+			t.renameSkater(mergedID, survivorID)
+		}
+	}
+	if leagues.StateManager() == sb.sm {
+		rename()
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sb.sm.Lock()
+		defer sb.sm.Unlock()
+		rename()
+	}()
+	return done
+}
+
 func (sb *Scoreboard) snapshotStateStart() {
 	sb.activeSnapshot = newStateSnapshot(sb, int64(len(sb.snapshots)), sb.masterClock.CurrentTime())
 	sb.snapshots = append(sb.snapshots, sb.activeSnapshot)
@@ -155,6 +296,7 @@ func (sb *Scoreboard) clocksExpired() {
 func (sb *Scoreboard) endOfPeriod(canUndo bool) {
 	sb.snapshotStateEnd(canUndo)
 	defer sb.snapshotStateStart()
+	sb.updateStats()
 	if sb.masterClock.period.number.num == 1 {
 		sb.setState(stateIntermission)
 
@@ -180,7 +322,7 @@ func (sb *Scoreboard) stateBase() string {
 func (sb *Scoreboard) setState(state string) error {
 	log.Printf("scoreboard: setState(%+v)", state)
 	sb.state = state
-	statemanager.StateUpdateString(sb.stateIDs["state"], state)
+	sb.sm.StateUpdateString(sb.stateIDs["state"], state)
 
 	adjustable := false
 	if isTimeoutState(state) {
@@ -205,12 +347,17 @@ func (sb *Scoreboard) startJam(_ []string) error {
 	}
 
 	sb.setState(stateJam)
+	if sb.activeJam.started {
+		// The period ended during the last jam, so no lineup started a new one
+		newJam(sb)
+	}
 
 	// Reset jam clock and increment jam number
 	sb.masterClock.jam.reset(false, true)
 	// Start clocks Period, Jam
 	sb.masterClock.setRunningClocks(clockPeriod, clockJam)
 	sb.activeJam.updateJam()
+	sb.updateStats()
 	return nil
 }
 
@@ -229,6 +376,7 @@ func (sb *Scoreboard) stopJam(_ []string) error {
 	sb.snapshotStateEnd(sb.masterClock.jam.time.num != sb.masterClock.jam.time.min)
 	defer sb.snapshotStateStart()
 	sb.setState(stateLineup)
+	sb.updateStats()
 	newJam(sb)
 
 	// Reset lineup clock
@@ -347,6 +495,7 @@ func (sb *Scoreboard) undo(_ []string) error {
 		sb.snapshots = sb.snapshots[:len(sb.snapshots)-1]
 
 		sb.masterClock.ticker()
+		sb.updateStats()
 	}
 	return nil
 }
@@ -0,0 +1,485 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package scoreboard
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rollerderby/crg/leagues"
+	"github.com/rollerderby/crg/statemanager"
+)
+
+func TestTxRollbackModel(t *testing.T) {
+	sm := statemanager.New()
+	sb := New(sm)
+
+	sm.Lock()
+	defer sm.Unlock()
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Name", "Alice")
+	sm.StateSet("Scoreboard.Team(1).Skater(b).Name", "Bea")
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Position", positionJammer)
+	team := sb.teams[0]
+	before := sm.StateQuery("Scoreboard")
+
+	tx := sm.Begin()
+	tx.Set("Scoreboard.Team(1).Name", "Changed")
+	tx.Set("Scoreboard.Team(1).Skater(c).Name", "Cat")
+	tx.Set("Scoreboard.Team(1).Skater(b).Position", positionJammer)
+	tx.Set("Scoreboard.Team(1).Skater(b).InBox", "true")
+	tx.Command("Scoreboard.Team(1).AddPenalty", []string{"b", "X"})
+	tx.Set("Scoreboard.Team(1).Color", "Dark Blue")
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Commit: expected the invalid color to fail")
+	}
+
+	if team.name != "Team 1" {
+		t.Errorf("Team name not rolled back: %v", team.name)
+	}
+	if _, ok := team.skaters["c"]; ok || len(team.skaters) != 2 {
+		t.Errorf("Skater created in the transaction was kept: %v", team.skaters)
+	}
+	a, b := team.skaters["a"], team.skaters["b"]
+	if a.position != positionJammer || b.position != positionBench || team.jammer != "a" {
+		t.Errorf("Positions not rolled back: a %v, b %v, jammer %v", a.position, b.position, team.jammer)
+	}
+	if b.inBox() || len(b.boxTrips) != 0 || len(b.penalties) != 0 {
+		t.Errorf("Box trip or penalty kept: in box %v, %v trips, %v penalties", b.inBox(), len(b.boxTrips), len(b.penalties))
+	}
+	after := sm.StateQuery("Scoreboard")
+	for k, v := range before {
+		if after[k] != v {
+			t.Errorf("%v: expected %q after rollback got %q", k, v, after[k])
+		}
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			t.Errorf("%v: kept after rollback", k)
+		}
+	}
+}
+
+func TestJournalReplayDeletesSkater(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scoreboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer statemanager.SetBaseFilePath(statemanager.BaseFilePath())
+	statemanager.SetBaseFilePath(dir)
+
+	// The skater is deleted after the Saver last wrote the file
+	sm := statemanager.New()
+	New(sm)
+	sm.Lock()
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Name", "Alice")
+	saved := sm.StateQuery("Scoreboard")
+	sm.Unlock()
+	j := sm.NewJournal("scoreboard", "Scoreboard", 0)
+	sm.Command("Scoreboard.Team(1).DeleteSkater", []string{"a"})
+	j.Close()
+
+	sm2 := statemanager.New()
+	sb2 := New(sm2)
+	sm2.Lock()
+	sm2.StateSetGroup(saved)
+	sm2.Unlock()
+	j2 := sm2.NewJournal("scoreboard", "Scoreboard", 0)
+	defer j2.Close()
+
+	sm2.Lock()
+	defer sm2.Unlock()
+	if _, ok := sb2.teams[0].skaters["a"]; ok {
+		t.Error("Deleted skater came back after replay")
+	}
+	if q := sm2.StateQuery("Scoreboard.Team(1).Skater(a)"); len(q) != 0 {
+		t.Errorf("Deleted skater's keys came back: %v", q)
+	}
+}
+
+func TestJournalReplayJams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scoreboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer statemanager.SetBaseFilePath(statemanager.BaseFilePath())
+	statemanager.SetBaseFilePath(dir)
+
+	// The jams are played after the Saver last wrote the file
+	sm := statemanager.New()
+	sb := New(sm)
+	sm.Lock()
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Name", "Alice")
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Position", positionJammer)
+	saved := sm.StateQuery("Scoreboard")
+	sm.Unlock()
+	j := sm.NewJournal("scoreboard", "Scoreboard", 0)
+	sm.Command("Scoreboard.StartJam", nil)
+	sm.Command("Scoreboard.Team(1).Score.Inc", nil)
+	sm.Command("Scoreboard.Team(1).AddPenalty", []string{"a", "X"})
+	sm.Command("Scoreboard.StopJam", nil)
+	sm.Command("Scoreboard.StartJam", nil)
+	sm.Command("Set", []string{"Scoreboard.Team(1).Skater(a).InBox", "true"})
+	sm.Command("Scoreboard.StopJam", nil)
+	j.Close()
+
+	sm2 := statemanager.New()
+	sb2 := New(sm2)
+	sm2.Lock()
+	sm2.StateSetGroup(saved)
+	sm2.Unlock()
+	j2 := sm2.NewJournal("scoreboard", "Scoreboard", 0)
+	defer j2.Close()
+
+	sm.Lock()
+	defer sm.Unlock()
+	sm2.Lock()
+	defer sm2.Unlock()
+	if len(sb2.jams) != len(sb.jams) {
+		t.Fatalf("Expected %v jams after replay got %v", len(sb.jams), len(sb2.jams))
+	}
+	if jt := sb2.jams[0].teams[0]; jt.jammer != "a" || jt.score != 1 {
+		t.Errorf("First jam after replay: %+v", jt)
+	}
+	a := sb2.teams[0].skaters["a"]
+	if len(a.penalties) != 1 || a.penalties[0].jamIdx != 0 || len(a.boxTrips) != 1 || !a.inBox() {
+		t.Errorf("Penalties and box trips after replay: %v penalties, %v trips, in box %v", len(a.penalties), len(a.boxTrips), a.inBox())
+	}
+	for _, k := range []string{"Scoreboard.Team(1).Stats.Jams", "Scoreboard.Team(1).Skater(a).Stats.JammerPoints", "Scoreboard.Team(1).Skater(a).Stats.BoxTrips"} {
+		v, _ := sm.StateGetInt64(k)
+		if v2, _ := sm2.StateGetInt64(k); v2 != v {
+			t.Errorf("%v: expected %v after replay got %v", k, v, v2)
+		}
+	}
+}
+
+func TestSaveAndReload(t *testing.T) {
+	sm := statemanager.New()
+	sb := New(sm)
+
+	sm.Lock()
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Name", "Alice")
+	sm.StateSet("Scoreboard.Team(1).Skater(b).Name", "Bea")
+	sm.StateSet("Scoreboard.Team(1).Skater(c).Name", "Cat")
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Position", positionJammer)
+	sm.StateSet("Scoreboard.Team(1).Skater(b).Position", positionBlocker)
+	sm.StateSet("Scoreboard.Team(1).Skater(c).Position", positionPivot)
+	team := sb.teams[0]
+	sb.startJam(nil)
+	team.setScore(4)
+	team.setLead(leadLead)
+	team.skaters["b"].setInBox(true)
+	team.addPenalty([]string{"b", "X"})
+	sb.stopJam(nil)
+	sb.startJam(nil)
+	team.skaters["b"].setInBox(false)
+	team.skaters["b"].setInBox(true)
+	team.addPenalty([]string{"b", "B"})
+	team.setScore(6)
+	sb.stopJam(nil)
+	saved := sm.StateQuery("Scoreboard")
+	sm.Unlock()
+
+	sm2 := statemanager.New()
+	sb2 := New(sm2)
+	sm2.Lock()
+	defer sm2.Unlock()
+	sm2.StateSetGroup(saved)
+
+	loaded := sm2.StateQuery("Scoreboard")
+	for k, v := range saved {
+		if loaded[k] != v {
+			t.Errorf("%v: expected %q after reload got %q", k, v, loaded[k])
+		}
+	}
+	for k := range loaded {
+		if _, ok := saved[k]; !ok {
+			t.Errorf("%v: not in the saved state", k)
+		}
+	}
+
+	if len(sb2.jams) != len(sb.jams) || sb2.activeJam != sb2.jams[len(sb2.jams)-1] {
+		t.Fatalf("Expected %v jams got %v", len(sb.jams), len(sb2.jams))
+	}
+	for i, j := range sb.jams {
+		if j2 := sb2.jams[i]; j2.period != j.period || j2.jam != j.jam || j2.started != j.started {
+			t.Errorf("Jam %v: expected %v/%v/%v got %v/%v/%v", i, j.period, j.jam, j.started, j2.period, j2.jam, j2.started)
+		}
+	}
+	jt := sb2.jams[0].teams[0]
+	if jt.jammer != "a" || jt.pivot != "c" || len(jt.blockers) != 1 || jt.blockers[0] != "b" || jt.score != 4 || jt.lead != leadLead {
+		t.Errorf("First jam: %+v", jt)
+	}
+	b := sb2.teams[0].skaters["b"]
+	if len(b.penalties) != 2 || b.penalties[0].jamIdx != 0 || b.penalties[1].jamIdx != 1 {
+		t.Errorf("Penalties not reloaded: %v", len(b.penalties))
+	}
+	if len(b.boxTrips) != 2 || b.boxTrips[0].out.jamIdx != 1 || b.curBoxTrip != b.boxTrips[1] {
+		t.Errorf("Box trips not reloaded: %v, in box %v", len(b.boxTrips), b.inBox())
+	}
+
+	// Points after the reload are credited to the reloaded jam
+	sb2.teams[0].setScore(7)
+	if v := sb2.jams[1].teams[0].score; v != 3 {
+		t.Errorf("Jam score after reload: expected 3 got %v", v)
+	}
+}
+
+func TestJamScoreAfterLineup(t *testing.T) {
+	sm := statemanager.New()
+	sb := New(sm)
+
+	sm.Lock()
+	defer sm.Unlock()
+	team := sb.teams[0]
+	team.setScore(2)
+	team.setLead(leadLead)
+	sb.startJam(nil)
+	team.setScore(6)
+	sb.stopJam(nil)
+
+	// Points entered during the lineup belong to the jam that just ended
+	team.setScore(9)
+	team.setLead(leadNo)
+	first := sb.jams[0]
+	if jt := first.teams[0]; jt.score != 7 || jt.lead != "" {
+		t.Errorf("First jam: got score %v lead %q, expected 7 and no lead", jt.score, jt.lead)
+	}
+	if team.lastScore != 0 || team.lead != leadNo {
+		t.Errorf("Team values changed: last score %v lead %v", team.lastScore, team.lead)
+	}
+
+	sb.startJam(nil)
+	team.setScore(10)
+	team.setLead(leadLead)
+	if sb.activeJam == first || len(sb.jams) != 2 {
+		t.Fatalf("Expected a second jam, got %v", len(sb.jams))
+	}
+	if jt := sb.activeJam.teams[0]; jt.score != 1 || jt.lead != leadLead {
+		t.Errorf("Second jam: got score %v lead %q", jt.score, jt.lead)
+	}
+	if first.teams[0].score != 7 {
+		t.Errorf("First jam score changed to %v", first.teams[0].score)
+	}
+}
+
+func TestRenameSkaterMerge(t *testing.T) {
+	sm := statemanager.New()
+	sb := New(sm)
+
+	sm.Lock()
+	defer sm.Unlock()
+	sm.StateSet("Scoreboard.Team(1).Skater(keep).Name", "Smash Lee")
+	sm.StateSet("Scoreboard.Team(1).Skater(other).Name", "Smash Le")
+	sm.StateSet("Scoreboard.Team(1).Skater(other).Position", positionBlocker)
+	team := sb.teams[0]
+	team.addPenalty([]string{"other", "X"})
+	team.addPenalty([]string{"keep", "B"})
+	sm.StateSet("Scoreboard.Team(1).Skater(other).InBox", "true")
+
+	team.renameSkater("other", "keep")
+
+	if _, ok := team.skaters["other"]; ok {
+		t.Errorf("Merged skater kept")
+	}
+	keep := team.skaters["keep"]
+	if len(keep.penalties) != 2 || keep.penalties[0].code != "B" || keep.penalties[1].code != "X" {
+		t.Errorf("Penalties not merged: %v", len(keep.penalties))
+	}
+	if len(keep.boxTrips) != 1 || !keep.inBox() || keep.position != positionBlocker {
+		t.Errorf("Box trip or position not merged: %v trips, in box %v, %v", len(keep.boxTrips), keep.inBox(), keep.position)
+	}
+	if v, _ := sm.StateGetString("Scoreboard.Team(1).Skater(keep).Penalty(1).Code"); v != "X" {
+		t.Errorf("Penalty state not renumbered: %q", v)
+	}
+	if v, _ := sm.StateGetString("Scoreboard.Team(1).Skater(other).Name"); v != "" {
+		t.Errorf("Merged skater's state kept: %q", v)
+	}
+}
+
+func TestMergePersonsOtherStateManager(t *testing.T) {
+	shared := statemanager.New()
+	leagues.Initialize(shared)
+	sm := statemanager.New()
+	sb := New(sm)
+	defer sb.Close()
+
+	shared.Lock()
+	leagues.NewPerson("keep", "Smash Lee", "", "", "12")
+	leagues.NewPerson("other", "Smash Le", "", "", "21")
+	shared.Unlock()
+	sm.Lock()
+	sm.StateSet("Scoreboard.Team(1).Skater(other).Name", "Smash Le")
+	sm.Unlock()
+
+	// The merge is passed on from the track, holding the Scoreboard's lock
+	sm.Lock()
+	shared.Lock()
+	if err := leagues.MergePersons("keep", "other"); err != nil {
+		t.Fatal(err)
+	}
+	shared.Unlock()
+	sm.Unlock()
+
+	// The merged Person is deleted once the skater has been renamed
+	for i := 0; ; i++ {
+		shared.Lock()
+		v, _ := shared.StateGetString("Leagues.Person(other).Name")
+		shared.Unlock()
+		if v == "" {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Merged person not deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sm.Lock()
+	defer sm.Unlock()
+	if _, ok := sb.teams[0].skaters["other"]; ok {
+		t.Error("Merged person deleted before the skater was renamed")
+	}
+	if _, ok := sb.teams[0].skaters["keep"]; !ok {
+		t.Error("Skater not renamed to the surviving person")
+	}
+}
+
+func TestDeletePenalty(t *testing.T) {
+	sm := statemanager.New()
+	sb := New(sm)
+
+	sm.Lock()
+	defer sm.Unlock()
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Name", "Alice")
+	team := sb.teams[0]
+	team.addPenalty([]string{"a", "X"})
+	team.addPenalty([]string{"a", "B"})
+
+	if err := team.deletePenalty([]string{"a", "0"}); err != nil {
+		t.Fatal(err)
+	}
+	a := team.skaters["a"]
+	if len(a.penalties) != 1 || a.penalties[0].code != "B" {
+		t.Errorf("Expected only penalty B, got %v", len(a.penalties))
+	}
+	if v, _ := sm.StateGetString("Scoreboard.Team(1).Skater(a).Penalty(0).Code"); v != "B" {
+		t.Errorf("Penalty(0).Code: got %q", v)
+	}
+	if v, _ := sm.StateGetString("Scoreboard.Team(1).Skater(a).Penalty(1).Code"); v != "" {
+		t.Errorf("Penalty(1).Code kept: %q", v)
+	}
+
+	// Penalties can only be added at the end of the list
+	if err := sm.StateSet("Scoreboard.Team(1).Skater(a).Penalty(1000000).Code", "X"); err != errPenaltyNotFound {
+		t.Errorf("Set past the end of the penalties: got %v", err)
+	}
+	if len(a.penalties) != 1 {
+		t.Errorf("Blank penalties added: %v", len(a.penalties))
+	}
+}
+
+func TestStatsPerJam(t *testing.T) {
+	sm := statemanager.New()
+	sb := New(sm)
+
+	sm.Lock()
+	defer sm.Unlock()
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Name", "Alice")
+	sm.StateSet("Scoreboard.Team(1).Skater(b).Name", "Bea")
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Position", positionJammer)
+	sm.StateSet("Scoreboard.Team(1).Skater(b).Position", positionBlocker)
+	if v, _ := sm.StateGetInt64("Scoreboard.Team(1).Skater(b).Stats.Jams"); v != 0 {
+		t.Errorf("Jams before the first jam: %v", v)
+	}
+
+	team := sb.teams[0]
+	sb.startJam(nil)
+	team.setScore(4)
+	team.setLead(leadLead)
+	sb.stopJam(nil)
+
+	for k, expected := range map[string]int64{
+		"Scoreboard.Team(1).Stats.Jams":                  1,
+		"Scoreboard.Team(1).Stats.JammerPoints":          4,
+		"Scoreboard.Team(1).Stats.Lead":                  1,
+		"Scoreboard.Team(1).Skater(a).Stats.JamsJammer":  1,
+		"Scoreboard.Team(1).Skater(a).Stats.Lead":        1,
+		"Scoreboard.Team(1).Skater(b).Stats.JamsBlocker": 1,
+		"Scoreboard.Team(1).Skater(b).Stats.Jams":        1,
+	} {
+		if v, _ := sm.StateGetInt64(k); v != expected {
+			t.Errorf("%v: expected %v got %v", k, expected, v)
+		}
+	}
+}
+
+func TestJamLineupKept(t *testing.T) {
+	sm := statemanager.New()
+	sb := New(sm)
+
+	sm.Lock()
+	defer sm.Unlock()
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Name", "Alice")
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Position", positionJammer)
+	sb.startJam(nil)
+	sb.stopJam(nil)
+	sb.startJam(nil)
+	sb.stopJam(nil)
+
+	if jt := sb.jams[1].teams[0]; jt.jammer != "a" {
+		t.Errorf("Second jam lineup: jammer %q", jt.jammer)
+	}
+	if v, _ := sm.StateGetInt64("Scoreboard.Team(1).Skater(a).Stats.JamsJammer"); v != 2 {
+		t.Errorf("JamsJammer: expected 2 got %v", v)
+	}
+}
+
+func TestLoadTeam(t *testing.T) {
+	sm := statemanager.New()
+	leagues.Initialize(sm)
+	sb := New(sm)
+
+	sm.Lock()
+	defer sm.Unlock()
+	l := leagues.NewLeague("l", "League", "", "", "")
+	lt := l.NewTeam("a", "A Team", leagues.TeamTypeA, "Red", "a.png")
+	lt.AddMember(leagues.NewPerson("p1", "Smash Lee", "Jane Doe", "", "12"), leagues.RoleCaptain, true)
+	lt.AddMember(leagues.NewPerson("p2", "Out Injured", "", "", "7"), leagues.RoleSkater, false)
+	sm.StateSet("Scoreboard.Team(1).Skater(old).Name", "Old")
+	sm.StateSet("Scoreboard.Team(1).Skater(old).Position", positionJammer)
+	sb.startJam(nil)
+	sb.stopJam(nil)
+
+	if _, ok := sb.teams[0].loadTeam([]string{"l"}).(*statemanager.ArgumentError); !ok {
+		t.Error("LoadTeam without a team: expected an ArgumentError")
+	}
+	if err := sb.teams[0].loadTeam([]string{"l", "missing"}); err != errLeagueTeamNotFound {
+		t.Errorf("LoadTeam of a missing team: got %v", err)
+	}
+	if err := sb.teams[0].loadTeam([]string{"l", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	team := sb.teams[0]
+	if team.name != "A Team" || team.color != "Red" || team.logo != "a.png" {
+		t.Errorf("Team: got %v %v %v", team.name, team.color, team.logo)
+	}
+	if len(team.skaters) != 1 {
+		t.Fatalf("Expected only the active roster, got %v skaters", len(team.skaters))
+	}
+	s := team.skaters["p1"]
+	if s == nil || s.name != "Smash Lee" || s.number != "12" || !s.isCaptain {
+		t.Errorf("Skater not loaded from the roster: %+v", s)
+	}
+	if v, _ := sm.StateGetString("Scoreboard.Team(1).Skater(old).Name"); v != "" {
+		t.Errorf("Old skater kept: %v", v)
+	}
+	if v, _ := sm.StateGetString("Scoreboard.Jam(0).Team(1).Jammer"); v != "" || sb.jams[0].teams[0].jammer != "" {
+		t.Errorf("Old skater kept in the jam lineup: %v", v)
+	}
+}
@@ -34,6 +34,7 @@ type skater struct {
 	isBenchStaff    bool
 	boxTrips        []*boxTrip
 	curBoxTrip      *boxTrip
+	penalties       []*penalty
 	stateIDs        map[string]string
 }
 
@@ -74,6 +75,7 @@ func blankSkater(t *team, id string) *skater {
 	s.setIsCaptain(false)
 	s.setIsAltCaptain(false)
 	s.setIsBenchStaff(false)
+	(&gameStats{}).update(t.sb.sm, s.base)
 	s.setPosition(positionBench)
 	s.setInLastJam(false)
 
@@ -96,51 +98,47 @@ func newSkater(t *team, id, name, legalName, insuranceNumber, number string, isA
 
 func (s *skater) setID(v string) error {
 	s.id = v
-	return statemanager.StateUpdateString(s.stateIDs["id"], v)
+	return s.t.sb.sm.StateUpdateString(s.stateIDs["id"], v)
 }
 
 func (s *skater) setName(v string) error {
 	s.name = v
-	return statemanager.StateUpdateString(s.stateIDs["name"], v)
+	return s.t.sb.sm.StateUpdateString(s.stateIDs["name"], v)
 }
 
 func (s *skater) setLegalName(v string) error {
 	s.legalName = v
-	return statemanager.StateUpdateString(s.stateIDs["legalName"], v)
+	return s.t.sb.sm.StateUpdateString(s.stateIDs["legalName"], v)
 }
 
 func (s *skater) setInsuranceNumber(v string) error {
 	s.insuranceNumber = v
-	return statemanager.StateUpdateString(s.stateIDs["insuranceNumber"], v)
+	return s.t.sb.sm.StateUpdateString(s.stateIDs["insuranceNumber"], v)
 }
 
 func (s *skater) setNumber(v string) error {
 	s.number = v
-	return statemanager.StateUpdateString(s.stateIDs["number"], v)
+	return s.t.sb.sm.StateUpdateString(s.stateIDs["number"], v)
 }
 
 func (s *skater) setIsAlt(v bool) error {
 	s.isAlt = v
-	s.setDescription()
-	return statemanager.StateUpdateBool(s.stateIDs["isAlt"], v)
+	return s.t.sb.sm.StateUpdateBool(s.stateIDs["isAlt"], v)
 }
 
 func (s *skater) setIsCaptain(v bool) error {
 	s.isCaptain = v
-	s.setDescription()
-	return statemanager.StateUpdateBool(s.stateIDs["isCaptain"], v)
+	return s.t.sb.sm.StateUpdateBool(s.stateIDs["isCaptain"], v)
 }
 
 func (s *skater) setIsAltCaptain(v bool) error {
 	s.isAltCaptain = v
-	s.setDescription()
-	return statemanager.StateUpdateBool(s.stateIDs["isAltCaptain"], v)
+	return s.t.sb.sm.StateUpdateBool(s.stateIDs["isAltCaptain"], v)
 }
 
 func (s *skater) setIsBenchStaff(v bool) error {
 	s.isBenchStaff = v
-	s.setDescription()
-	return statemanager.StateUpdateBool(s.stateIDs["isBenchStaff"], v)
+	return s.t.sb.sm.StateUpdateBool(s.stateIDs["isBenchStaff"], v)
 }
 
 func (s *skater) inBox() bool {
@@ -148,10 +146,13 @@ func (s *skater) inBox() bool {
 }
 
 func (s *skater) setInLastJam(v bool) error {
-	return statemanager.StateUpdateBool(s.stateIDs["inLastJam"], v)
+	return s.t.sb.sm.StateUpdateBool(s.stateIDs["inLastJam"], v)
 }
 
 func (s *skater) setInBox(v bool) error {
+	if v == s.inBox() {
+		return s.t.sb.sm.StateUpdateBool(s.stateIDs["inBox"], v)
+	}
 	if s.position == positionBench && v {
 		return errSkaterOnBench
 	}
@@ -165,20 +166,21 @@ func (s *skater) setInBox(v bool) error {
 	} else {
 		s.curBoxTrip = newBoxTrip(s, int64(len(s.t.sb.jams)-1), false, s.t.starPass)
 		s.boxTrips = append(s.boxTrips, s.curBoxTrip)
+		s.t.updateStats()
 	}
 
 	if s.position == positionJammer || s.position == positionPivot {
 		s.t.updatePositions()
 	}
 
-	return statemanager.StateUpdateBool(s.stateIDs["inBox"], v)
+	return s.t.sb.sm.StateUpdateBool(s.stateIDs["inBox"], v)
 }
 
 func (s *skater) setPosition(v string) error {
 	var set = func(v string) error {
 		s.position = v
 		s.t.updatePositions()
-		return statemanager.StateUpdateString(s.stateIDs["position"], v)
+		return s.t.sb.sm.StateUpdateString(s.stateIDs["position"], v)
 	}
 
 	if v == s.position {
@@ -232,7 +234,8 @@ func (s *skater) setPosition(v string) error {
 	return set(v)
 }
 
-func (s *skater) setDescription() {
+// description describes the roles of the skater, in full and abbreviated
+func (s *skater) description() (string, string) {
 	var long, short []string
 	if s.isAlt {
 		long = append(long, "Alternate")
@@ -250,8 +253,51 @@ func (s *skater) setDescription() {
 		long = append(long, "Bench Staff")
 		short = append(short, "B")
 	}
-	statemanager.StateUpdateString(s.stateIDs["description"], strings.Join(long, ", "))
-	statemanager.StateUpdateString(s.stateIDs["shortDescription"], strings.Join(short, ""))
+	return strings.Join(long, ", "), strings.Join(short, "")
+}
+
+// renameSkater moves the skater with id from to id to, keeping its position,
+// box trips, penalties and jam lineups.  If the team already has a skater
+// with id to, the box trips and penalties are merged into that skater.
+func (t *team) renameSkater(from, to string) {
+	s, ok := t.skaters[from]
+	if !ok {
+		return
+	}
+	delete(t.skaters, from)
+	t.sb.sm.StateDelete(s.base)
+	for _, j := range t.sb.jams {
+		j.renameSkater(t, from, to)
+	}
+
+	ns, ok := t.skaters[to]
+	if !ok {
+		ns = newSkater(t, to, s.name, s.legalName, s.insuranceNumber, s.number, s.isAlt, s.isCaptain, s.isAltCaptain, s.isBenchStaff)
+		t.skaters[to] = ns
+	}
+	ns.mergeBoxTrips(s)
+	ns.mergePenalties(s)
+	if ns.position == positionBench {
+		ns.position = s.position
+	}
+	t.sb.sm.StateUpdateString(ns.stateIDs["position"], ns.position)
+	t.sb.sm.StateUpdateBool(ns.stateIDs["inBox"], ns.inBox())
+	t.updatePositions()
+	t.updateStats()
+}
+
+// removeSkater removes a skater whose keys were removed by a rollback or
+// journal replay, along with any keys updated for it since
+func (t *team) removeSkater(k string) {
+	ids := statemanager.ParseIDs(k)
+	if len(ids) < 2 {
+		return
+	}
+	if s, ok := t.skaters[ids[1]]; ok {
+		delete(t.skaters, ids[1])
+		t.sb.sm.StateDelete(s.base)
+		t.updateStats()
+	}
 }
 
 /* Helper functions to find the skater for RegisterUpdaters */
@@ -33,6 +33,7 @@ type stateSnapshot struct {
 }
 
 type stateSnapshotTeam struct {
+	sm                     *statemanager.StateManager
 	base                   string
 	timeouts               int64
 	officialReviews        int64
@@ -40,6 +41,7 @@ type stateSnapshotTeam struct {
 }
 
 type stateSnapshotClock struct {
+	sm        *statemanager.StateManager
 	base      string
 	number    int64
 	startTime int64
@@ -71,12 +73,12 @@ func blankStateSnapshot(sb *Scoreboard, idx int64) *stateSnapshot {
 	ss.stateIDs["endTime"] = ss.base + ".EndTime"
 
 	for id := range sb.teams {
-		t := &stateSnapshotTeam{base: fmt.Sprintf("%v.Team(%v)", ss.base, id)}
+		t := &stateSnapshotTeam{sm: sb.sm, base: fmt.Sprintf("%v.Team(%v)", ss.base, id)}
 		ss.teams[id] = t
 	}
 
 	for id := range sb.masterClock.clocks {
-		c := &stateSnapshotClock{base: ss.base + ".Clock(" + id + ")"}
+		c := &stateSnapshotClock{sm: sb.sm, base: ss.base + ".Clock(" + id + ")"}
 		ss.clocks[id] = c
 	}
 	return ss
@@ -109,7 +111,7 @@ func newStateSnapshot(sb *Scoreboard, idx int64, startTime time.Time) *stateSnap
 }
 
 func (ss *stateSnapshot) delete() {
-	statemanager.StateDelete(ss.base)
+	ss.sb.sm.StateDelete(ss.base)
 	ss.sb = nil
 }
 
@@ -134,7 +136,7 @@ func (ss *stateSnapshot) unend() {
 
 	for name, c := range ss.clocks {
 		c.setEndTime(0)
-		statemanager.StateUpdateInt64(ss.stateIDs[name+".endTime"], c.endTime)
+		ss.sb.sm.StateUpdateInt64(ss.stateIDs[name+".endTime"], c.endTime)
 	}
 }
 
@@ -154,77 +156,77 @@ func (ss *stateSnapshot) jam() int64 {
 
 func (ss *stateSnapshot) setState(v string) {
 	ss.state = v
-	statemanager.StateUpdateString(ss.stateIDs["state"], v)
+	ss.sb.sm.StateUpdateString(ss.stateIDs["state"], v)
 }
 
 func (ss *stateSnapshot) setInProgress(v bool) {
 	ss.inProgress = v
-	statemanager.StateUpdateBool(ss.stateIDs["inProgress"], v)
+	ss.sb.sm.StateUpdateBool(ss.stateIDs["inProgress"], v)
 }
 
 func (ss *stateSnapshot) setCanRevert(v bool) {
 	ss.canRevert = v
-	statemanager.StateUpdateBool(ss.stateIDs["canRevert"], v)
+	ss.sb.sm.StateUpdateBool(ss.stateIDs["canRevert"], v)
 }
 
 func (ss *stateSnapshot) setStartTicks(v int64) {
 	ss.startTicks = v
-	statemanager.StateUpdateInt64(ss.stateIDs["startTicks"], v)
+	ss.sb.sm.StateUpdateInt64(ss.stateIDs["startTicks"], v)
 }
 
 func (ss *stateSnapshot) setEndTicks(v int64) {
 	ss.endTicks = v
-	statemanager.StateUpdateInt64(ss.stateIDs["endTicks"], v)
+	ss.sb.sm.StateUpdateInt64(ss.stateIDs["endTicks"], v)
 }
 
 func (ss *stateSnapshot) setStartTime(v time.Time) {
 	ss.startTime = v
-	statemanager.StateUpdateTime(ss.stateIDs["startTime"], v)
+	ss.sb.sm.StateUpdateTime(ss.stateIDs["startTime"], v)
 }
 
 func (ss *stateSnapshot) setEndTime(v time.Time) {
 	ss.endTime = v
-	statemanager.StateUpdateTime(ss.stateIDs["endTime"], v)
+	ss.sb.sm.StateUpdateTime(ss.stateIDs["endTime"], v)
 }
 
 func (ss *stateSnapshot) setLength(v int64) {
 	ss.length = v
-	statemanager.StateUpdateInt64(ss.stateIDs["length"], v)
+	ss.sb.sm.StateUpdateInt64(ss.stateIDs["length"], v)
 }
 
 func (c *stateSnapshotClock) setNumber(v int64) {
 	c.number = v
-	statemanager.StateUpdateInt64(c.base+".Number", v)
+	c.sm.StateUpdateInt64(c.base+".Number", v)
 }
 
 func (c *stateSnapshotClock) setStartTime(v int64) {
 	c.startTime = v
-	statemanager.StateUpdateInt64(c.base+".StartTime", v)
+	c.sm.StateUpdateInt64(c.base+".StartTime", v)
 }
 
 func (c *stateSnapshotClock) setEndTime(v int64) {
 	c.endTime = v
-	statemanager.StateUpdateInt64(c.base+".EndTime", v)
+	c.sm.StateUpdateInt64(c.base+".EndTime", v)
 }
 
 func (c *stateSnapshotClock) setRunning(v bool) {
 	c.running = v
-	statemanager.StateUpdateBool(c.base+".Running", v)
+	c.sm.StateUpdateBool(c.base+".Running", v)
 }
 
 func (t *stateSnapshotTeam) setTimeouts(v int64) {
 	t.timeouts = v
-	statemanager.StateUpdateInt64(t.base+".Timeouts", v)
+	t.sm.StateUpdateInt64(t.base+".Timeouts", v)
 }
 
 func (t *stateSnapshotTeam) setOfficialReviews(v int64) {
 	t.officialReviews = v
-	statemanager.StateUpdateInt64(t.base+".OfficialReviews", v)
+	t.sm.StateUpdateInt64(t.base+".OfficialReviews", v)
 }
 
 func (t *stateSnapshotTeam) setOfficialReviewRetained(v bool) {
 	t.officialReviewRetained = v
-	statemanager.StateUpdateBool(t.base+".OfficialReviewRetained", v)
+	t.sm.StateUpdateBool(t.base+".OfficialReviewRetained", v)
 }
 
 func (ss *stateSnapshot) findClock(k string) *stateSnapshotClock {
@@ -0,0 +1,120 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package scoreboard
+
+import (
+	"github.com/rollerderby/crg/statemanager"
+)
+
+// gameStats are the live statistics kept under Team(*).Stats and Skater(*).Stats
+type gameStats struct {
+	jams         int64
+	jamsJammer   int64
+	jamsPivot    int64
+	jamsBlocker  int64
+	jammerPoints int64
+	lead         int64
+	penalties    int64
+	boxTrips     int64
+}
+
+func (gs *gameStats) update(sm *statemanager.StateManager, base string) {
+	sm.StateUpdateInt64(base+".Stats.Jams", gs.jams)
+	sm.StateUpdateInt64(base+".Stats.JamsJammer", gs.jamsJammer)
+	sm.StateUpdateInt64(base+".Stats.JamsPivot", gs.jamsPivot)
+	sm.StateUpdateInt64(base+".Stats.JamsBlocker", gs.jamsBlocker)
+	sm.StateUpdateInt64(base+".Stats.JammerPoints", gs.jammerPoints)
+	sm.StateUpdateInt64(base+".Stats.Lead", gs.lead)
+	sm.StateUpdateInt64(base+".Stats.Penalties", gs.penalties)
+	sm.StateUpdateInt64(base+".Stats.BoxTrips", gs.boxTrips)
+}
+
+// registerStatsKeys describes the keys written by gameStats.update for base
+func registerStatsKeys(sm *statemanager.StateManager, base, who string) {
+	sm.RegisterKey(base+".Stats.Jams", statemanager.TypeInt64, "Jams skated by "+who)
+	sm.RegisterKey(base+".Stats.JamsJammer", statemanager.TypeInt64, "Jams skated as jammer by "+who)
+	sm.RegisterKey(base+".Stats.JamsPivot", statemanager.TypeInt64, "Jams skated as pivot by "+who)
+	sm.RegisterKey(base+".Stats.JamsBlocker", statemanager.TypeInt64, "Jams skated as blocker by "+who)
+	sm.RegisterKey(base+".Stats.JammerPoints", statemanager.TypeInt64, "Points scored as jammer by "+who)
+	sm.RegisterKey(base+".Stats.Lead", statemanager.TypeInt64, "Jams with lead jammer for "+who)
+	sm.RegisterKey(base+".Stats.Penalties", statemanager.TypeInt64, "Penalties for "+who)
+	sm.RegisterKey(base+".Stats.BoxTrips", statemanager.TypeInt64, "Box trips for "+who)
+}
+
+// updateStats recalculates the statistics for both teams
+func (sb *Scoreboard) updateStats() {
+	for _, t := range sb.teams {
+		t.updateStats()
+	}
+}
+
+// loadStats recalculates the statistics of a loaded game, the saved values
+// are not used
+func (t *team) loadStats(_ int64) error {
+	t.updateStats()
+	return nil
+}
+
+// updateStats recalculates the statistics for the team and its skaters
+// from the jams played so far (including the current jam), the jam
+// lineups and the skaters' box trips and penalties.  As it walks every
+// jam it is called once per event (a jam starting or ending, a score or
+// lead change, a penalty or box trip, a skater deleted or merged) rather
+// than by the setters for positions and skaters, which run for every key
+// loaded.
+func (t *team) updateStats() {
+	var ts gameStats
+	ss := make(map[string]*gameStats)
+	for id := range t.skaters {
+		ss[id] = &gameStats{}
+	}
+
+	for _, j := range t.sb.jams {
+		if !j.started {
+			continue
+		}
+		jt := j.teams[t.id-1]
+		lead := jt.lead == leadLead || jt.lead == leadLost
+
+		ts.jams++
+		ts.jammerPoints += jt.score
+		if lead {
+			ts.lead++
+		}
+
+		if s, ok := ss[jt.jammer]; ok {
+			s.jams++
+			s.jamsJammer++
+			s.jammerPoints += jt.score
+			if lead {
+				s.lead++
+			}
+		}
+		if s, ok := ss[jt.pivot]; ok {
+			s.jams++
+			s.jamsPivot++
+		}
+		for _, id := range jt.blockers {
+			if s, ok := ss[id]; ok {
+				s.jams++
+				s.jamsBlocker++
+			}
+		}
+	}
+
+	for id, s := range t.skaters {
+		gs := ss[id]
+		gs.penalties = int64(len(s.penalties))
+		gs.boxTrips = int64(len(s.boxTrips))
+		ts.jamsJammer += gs.jamsJammer
+		ts.jamsPivot += gs.jamsPivot
+		ts.jamsBlocker += gs.jamsBlocker
+		ts.penalties += gs.penalties
+		ts.boxTrips += gs.boxTrips
+		gs.update(t.sb.sm, s.base)
+	}
+	ts.update(t.sb.sm, t.base)
+}
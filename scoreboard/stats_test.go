@@ -0,0 +1,40 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package scoreboard
+
+import (
+	"testing"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+func TestJamPivot(t *testing.T) {
+	sm := statemanager.New()
+	sb := New(sm)
+
+	sm.Lock()
+	defer sm.Unlock()
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Name", "Alice")
+	sm.StateSet("Scoreboard.Team(1).Skater(b).Name", "Bea")
+	sm.StateSet("Scoreboard.Team(1).Skater(a).Position", positionJammer)
+	sm.StateSet("Scoreboard.Team(1).Skater(b).Position", positionPivot)
+	sb.startJam(nil)
+	sb.teams[0].setScore(3)
+	sb.stopJam(nil)
+
+	// The pivot used to be recorded in the jam as the jammer, crediting
+	// the jammer's points to whichever of the two came last
+	jt := sb.jams[0].teams[0]
+	if jt.jammer != "a" || jt.pivot != "b" {
+		t.Errorf("Jam lineup: jammer %q pivot %q", jt.jammer, jt.pivot)
+	}
+	if v, _ := sm.StateGetInt64("Scoreboard.Team(1).Skater(b).Stats.JamsPivot"); v != 1 {
+		t.Errorf("Pivot JamsPivot: got %v", v)
+	}
+	if v, _ := sm.StateGetInt64("Scoreboard.Team(1).Skater(b).Stats.JammerPoints"); v != 0 {
+		t.Errorf("Pivot credited with jammer points: %v", v)
+	}
+}
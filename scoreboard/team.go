@@ -6,8 +6,10 @@
 package scoreboard
 
 import (
+	"errors"
 	"fmt"
 
+	"github.com/rollerderby/crg/leagues"
 	"github.com/rollerderby/crg/statemanager"
 )
 
@@ -17,12 +19,17 @@ const (
 	leadLost = "Lost"
 )
 
+var errLeagueTeamNotFound = errors.New("League Team Not Found")
+
 type team struct {
 	sb                     *Scoreboard
 	base                   string
 	id                     uint8
 	name                   string
 	color                  string
+	logo                   string
+	leagueID               string
+	leagueTeamID           string
 	score                  int64
 	lastScore              int64
 	timeouts               int64
@@ -50,6 +57,9 @@ func newTeam(sb *Scoreboard, id uint8) *team {
 	t.stateIDs["id"] = fmt.Sprintf("%s.ID", t.base)
 	t.stateIDs["name"] = fmt.Sprintf("%s.Name", t.base)
 	t.stateIDs["color"] = fmt.Sprintf("%s.Color", t.base)
+	t.stateIDs["logo"] = fmt.Sprintf("%s.Logo", t.base)
+	t.stateIDs["leagueID"] = fmt.Sprintf("%s.League.ID", t.base)
+	t.stateIDs["leagueTeamID"] = fmt.Sprintf("%s.League.TeamID", t.base)
 	t.stateIDs["score"] = fmt.Sprintf("%s.Score", t.base)
 	t.stateIDs["lastScore"] = fmt.Sprintf("%s.LastScore", t.base)
 	t.stateIDs["jamScore"] = fmt.Sprintf("%s.JamScore", t.base)
@@ -63,44 +73,162 @@ func newTeam(sb *Scoreboard, id uint8) *team {
 	t.stateIDs["pivot"] = fmt.Sprintf("%s.Pivot.ID", t.base)
 	t.stateIDs["pivotInBox"] = fmt.Sprintf("%s.Pivot.InBox", t.base)
 
-	statemanager.StateUpdateInt64(t.stateIDs["id"], int64(id))
-
-	statemanager.RegisterUpdaterString(t.stateIDs["name"], 0, t.setName)
-	statemanager.RegisterUpdaterString(t.stateIDs["color"], 0, t.setColor)
-	statemanager.RegisterUpdaterInt64(t.stateIDs["score"], 0, t.setScore)
-	statemanager.RegisterUpdaterInt64(t.stateIDs["lastScore"], 0, t.setLastScore)
-	statemanager.RegisterUpdaterInt64(t.stateIDs["timeouts"], 0, t.setTimeouts)
-	statemanager.RegisterUpdaterInt64(t.stateIDs["officialReviews"], 0, t.setOfficialReviews)
-	statemanager.RegisterUpdaterBool(t.stateIDs["officialReviewRetained"], 0, t.setOfficialReviewRetained)
-	statemanager.RegisterUpdaterString(t.stateIDs["lead"], 0, t.setLead)
-	statemanager.RegisterUpdaterBool(t.stateIDs["starPass"], 0, t.setStarPass)
-
-	statemanager.RegisterUpdaterString(t.stateIDs["jammer"], 1, t.setJammer)         // Must be after skaters are loaded
-	statemanager.RegisterUpdaterString(t.stateIDs["pivot"], 1, t.setPivot)           // Must be after skaters are loaded
-	statemanager.RegisterUpdaterBool(t.stateIDs["jammerInBox"], 1, t.setJammerInBox) // Must be after skaters are loaded
-	statemanager.RegisterUpdaterBool(t.stateIDs["pivotInBox"], 1, t.setPivotInBox)   // Must be after skaters are loaded
-
-	statemanager.RegisterCommand(t.stateIDs["score"]+".Inc", t.incScore)
-	statemanager.RegisterCommand(t.stateIDs["score"]+".Dec", t.decScore)
-	statemanager.RegisterCommand(t.stateIDs["lastScore"]+".Inc", t.incLastScore)
-	statemanager.RegisterCommand(t.stateIDs["lastScore"]+".Dec", t.decLastScore)
-	statemanager.RegisterCommand(t.stateIDs["timeouts"]+".Start", t.startTimeout)
-	statemanager.RegisterCommand(t.stateIDs["officialReviews"]+".Start", t.startOfficialReview)
-	statemanager.RegisterCommand(t.stateIDs["officialReviews"]+".Retained", t.retainOfficialReview)
-	statemanager.RegisterCommand(t.base+".DeleteSkater", t.deleteSkater)
+	sb.sm.StateUpdateInt64(t.stateIDs["id"], int64(id))
+
+	sb.sm.RegisterUpdaterString(t.stateIDs["name"], 0, t.setName)
+	sb.sm.RegisterUpdaterString(t.stateIDs["color"], 0, t.setColor)
+	sb.sm.RegisterUpdaterString(t.stateIDs["logo"], 0, t.setLogo)
+	sb.sm.RegisterUpdaterString(t.stateIDs["leagueID"], 0, t.setLeagueID)
+	sb.sm.RegisterUpdaterString(t.stateIDs["leagueTeamID"], 0, t.setLeagueTeamID)
+	sb.sm.RegisterUpdaterInt64(t.stateIDs["score"], 0, t.setScore)
+	sb.sm.RegisterUpdaterInt64(t.stateIDs["lastScore"], 0, t.setLastScore)
+	sb.sm.RegisterUpdaterInt64(t.stateIDs["timeouts"], 0, t.setTimeouts)
+	sb.sm.RegisterUpdaterInt64(t.stateIDs["officialReviews"], 0, t.setOfficialReviews)
+	sb.sm.RegisterUpdaterBool(t.stateIDs["officialReviewRetained"], 0, t.setOfficialReviewRetained)
+	sb.sm.RegisterUpdaterString(t.stateIDs["lead"], 0, t.setLead)
+	sb.sm.RegisterUpdaterBool(t.stateIDs["starPass"], 0, t.setStarPass)
+
+	sb.sm.RegisterUpdaterString(t.stateIDs["jammer"], 1, t.setJammer)         // Must be after skaters are loaded
+	sb.sm.RegisterUpdaterString(t.stateIDs["pivot"], 1, t.setPivot)           // Must be after skaters are loaded
+	sb.sm.RegisterUpdaterBool(t.stateIDs["jammerInBox"], 2, t.setJammerInBox) // Must be after box trips are loaded
+	sb.sm.RegisterUpdaterBool(t.stateIDs["pivotInBox"], 2, t.setPivotInBox)   // Must be after box trips are loaded
+
+	sb.sm.RegisterKey(t.stateIDs["id"], statemanager.TypeInt64, "ID of the team").Range(1, 2)
+	sb.sm.RegisterKey(t.stateIDs["name"], statemanager.TypeString, "Name of the team").MaxLength(64)
+	sb.sm.RegisterKey(t.stateIDs["color"], statemanager.TypeString, "Color of the team").Match(`#[0-9A-Fa-f]{3}|#[0-9A-Fa-f]{6}|[A-Za-z]+`)
+	sb.sm.RegisterKey(t.stateIDs["logo"], statemanager.TypeString, "Logo of the team, relative to /images").MaxLength(256)
+	sb.sm.RegisterKey(t.stateIDs["leagueID"], statemanager.TypeString, "ID of the league the team was loaded from")
+	sb.sm.RegisterKey(t.stateIDs["leagueTeamID"], statemanager.TypeString, "ID of the league team the team was loaded from")
+	sb.sm.RegisterKey(t.stateIDs["score"], statemanager.TypeInt64, "Score of the team").AtLeast(0)
+	sb.sm.RegisterKey(t.stateIDs["lastScore"], statemanager.TypeInt64, "Score of the team at the start of the jam").AtLeast(0)
+	sb.sm.RegisterKey(t.stateIDs["jamScore"], statemanager.TypeInt64, "Points scored by the team in the current jam")
+	sb.sm.RegisterKey(t.stateIDs["timeouts"], statemanager.TypeInt64, "Timeouts remaining for the team").AtLeast(0)
+	sb.sm.RegisterKey(t.stateIDs["officialReviews"], statemanager.TypeInt64, "Official reviews remaining for the team").AtLeast(0)
+	sb.sm.RegisterKey(t.stateIDs["officialReviewRetained"], statemanager.TypeBool, "Set if the team retained its official review")
+	sb.sm.RegisterKey(t.stateIDs["lead"], statemanager.TypeString, "Lead status of the team's jammer: Lead, No or Lost").Enum(leadLead, leadNo, leadLost)
+	sb.sm.RegisterKey(t.stateIDs["starPass"], statemanager.TypeBool, "Set if the team's jammer passed the star")
+	sb.sm.RegisterKey(t.stateIDs["jammer"], statemanager.TypeString, "ID of the team's jammer")
+	sb.sm.RegisterKey(t.base+".Jammer.Name", statemanager.TypeString, "Name of the team's jammer")
+	sb.sm.RegisterKey(t.base+".Jammer.Number", statemanager.TypeString, "Number of the team's jammer")
+	sb.sm.RegisterKey(t.stateIDs["jammerInBox"], statemanager.TypeBool, "Set if the team's jammer is in the penalty box")
+	sb.sm.RegisterKey(t.stateIDs["pivot"], statemanager.TypeString, "ID of the team's pivot")
+	sb.sm.RegisterKey(t.base+".Pivot.Name", statemanager.TypeString, "Name of the team's pivot")
+	sb.sm.RegisterKey(t.base+".Pivot.Number", statemanager.TypeString, "Number of the team's pivot")
+	sb.sm.RegisterKey(t.stateIDs["pivotInBox"], statemanager.TypeBool, "Set if the team's pivot is in the penalty box")
+	sb.sm.RegisterUpdaterInt64(t.base+".Stats.Jams", 3, t.loadStats) // Must be after jams, penalties and box trips are loaded
+	registerStatsKeys(sb.sm, t.base, "the team")
+	registerStatsKeys(sb.sm, t.base+".Skater(*)", "the skater")
+
+	sb.sm.RegisterCommand(t.stateIDs["score"]+".Inc", t.incScore)
+	sb.sm.RegisterCommand(t.stateIDs["score"]+".Dec", t.decScore)
+	sb.sm.RegisterCommand(t.stateIDs["lastScore"]+".Inc", t.incLastScore)
+	sb.sm.RegisterCommand(t.stateIDs["lastScore"]+".Dec", t.decLastScore)
+	sb.sm.RegisterCommand(t.stateIDs["timeouts"]+".Start", t.startTimeout)
+	sb.sm.RegisterCommand(t.stateIDs["officialReviews"]+".Start", t.startOfficialReview)
+	sb.sm.RegisterCommand(t.stateIDs["officialReviews"]+".Retained", t.retainOfficialReview)
+	sb.sm.RegisterCommand(t.base+".DeleteSkater", t.deleteSkater)
+	sb.sm.RegisterCommand(t.base+".LoadTeam", t.loadTeam)
+	sb.sm.RegisterCommandResult(t.base+".AddPenalty", t.addPenalty)
+	sb.sm.RegisterCommand(t.base+".DeletePenalty", t.deletePenalty)
+	sb.sm.DescribeCommand(t.stateIDs["score"]+".Inc", "Adds a point to the team's score")
+	sb.sm.DescribeCommand(t.stateIDs["score"]+".Dec", "Takes a point from the team's score")
+	sb.sm.DescribeCommand(t.stateIDs["lastScore"]+".Inc", "Adds a point to the team's score before the jam")
+	sb.sm.DescribeCommand(t.stateIDs["lastScore"]+".Dec", "Takes a point from the team's score before the jam")
+	sb.sm.DescribeCommand(t.stateIDs["timeouts"]+".Start", "Starts a team timeout")
+	sb.sm.DescribeCommand(t.stateIDs["officialReviews"]+".Start", "Starts an official review")
+	sb.sm.DescribeCommand(t.stateIDs["officialReviews"]+".Retained", "Retains the team's official review")
+	sb.sm.DescribeCommand(t.base+".DeleteSkater", "Deletes a skater from the team",
+		statemanager.Arg{Name: "SkaterID", Type: statemanager.TypeString})
+	sb.sm.DescribeCommand(t.base+".LoadTeam", "Replaces the team with a team from the leagues",
+		statemanager.Arg{Name: "LeagueID", Type: statemanager.TypeString},
+		statemanager.Arg{Name: "TeamID", Type: statemanager.TypeString})
+	sb.sm.DescribeCommand(t.base+".AddPenalty", "Adds a penalty in the current jam, returning its key",
+		statemanager.Arg{Name: "SkaterID", Type: statemanager.TypeString},
+		statemanager.Arg{Name: "Code", Type: statemanager.TypeString})
+	sb.sm.DescribeCommand(t.base+".DeletePenalty", "Deletes a penalty, renumbering the rest",
+		statemanager.Arg{Name: "SkaterID", Type: statemanager.TypeString},
+		statemanager.Arg{Name: "PenaltyIdx", Type: statemanager.TypeInt64})
+
+	// Keys computed from other keys
+	sb.sm.RegisterComputed(t.stateIDs["jamScore"], []string{t.stateIDs["score"], t.stateIDs["lastScore"]}, func([]string) interface{} {
+		return t.score - t.lastScore
+	})
+	t.computePositionKeys(t.base+".Jammer", &t.jammer)
+	t.computePositionKeys(t.base+".Pivot", &t.pivot)
+	sb.sm.RegisterComputed(t.base+".Skater(*).Description", t.skaterRoleKeys(), func(ids []string) interface{} {
+		if s, ok := t.skaters[ids[0]]; ok {
+			long, _ := s.description()
+			return long
+		}
+		return nil
+	})
+	sb.sm.RegisterComputed(t.base+".Skater(*).ShortDescription", t.skaterRoleKeys(), func(ids []string) interface{} {
+		if s, ok := t.skaters[ids[0]]; ok {
+			_, short := s.description()
+			return short
+		}
+		return nil
+	})
 
 	// Setup Updaters for skaters (functions located in skater.go)
-	statemanager.RegisterPatternUpdaterString(t.base+".Skater(*).ID", 0, t.sSetID)
-	statemanager.RegisterPatternUpdaterString(t.base+".Skater(*).Name", 0, t.sSetName)
-	statemanager.RegisterPatternUpdaterString(t.base+".Skater(*).LegalName", 0, t.sSetLegalName)
-	statemanager.RegisterPatternUpdaterString(t.base+".Skater(*).InsuranceNumber", 0, t.sSetInsuranceNumber)
-	statemanager.RegisterPatternUpdaterString(t.base+".Skater(*).Number", 0, t.sSetNumber)
-	statemanager.RegisterPatternUpdaterString(t.base+".Skater(*).Position", 0, t.sSetPosition)
-	statemanager.RegisterPatternUpdaterBool(t.base+".Skater(*).IsAlt", 0, t.sSetIsAlt)
-	statemanager.RegisterPatternUpdaterBool(t.base+".Skater(*).IsCaptain", 0, t.sSetIsCaptain)
-	statemanager.RegisterPatternUpdaterBool(t.base+".Skater(*).IsAltCaptain", 0, t.sSetIsAltCaptain)
-	statemanager.RegisterPatternUpdaterBool(t.base+".Skater(*).IsBenchStaff", 0, t.sSetIsBenchStaff)
-	statemanager.RegisterPatternUpdaterBool(t.base+".Skater(*).InBox", 0, t.sSetInBox)
+	sb.sm.RegisterPatternUpdaterString(t.base+".Skater(*).ID", 0, t.sSetID)
+	sb.sm.RegisterRemove(t.base+".Skater(*).ID", t.removeSkater)
+	sb.sm.RegisterRemove(t.base+".Skater(*).Penalty(*).Code", t.removePenalty)
+	sb.sm.RegisterRemove(t.base+".Skater(*).BoxTrip(*).Skater", t.removeBoxTrip)
+	sb.sm.RegisterPatternUpdaterString(t.base+".Skater(*).Name", 0, t.sSetName)
+	sb.sm.RegisterPatternUpdaterString(t.base+".Skater(*).LegalName", 0, t.sSetLegalName)
+	sb.sm.RegisterPatternUpdaterString(t.base+".Skater(*).InsuranceNumber", 0, t.sSetInsuranceNumber)
+	sb.sm.RegisterPatternUpdaterString(t.base+".Skater(*).Number", 0, t.sSetNumber)
+	sb.sm.RegisterPatternUpdaterString(t.base+".Skater(*).Position", 0, t.sSetPosition)
+	sb.sm.RegisterPatternUpdaterBool(t.base+".Skater(*).IsAlt", 0, t.sSetIsAlt)
+	sb.sm.RegisterPatternUpdaterBool(t.base+".Skater(*).IsCaptain", 0, t.sSetIsCaptain)
+	sb.sm.RegisterPatternUpdaterBool(t.base+".Skater(*).IsAltCaptain", 0, t.sSetIsAltCaptain)
+	sb.sm.RegisterPatternUpdaterBool(t.base+".Skater(*).IsBenchStaff", 0, t.sSetIsBenchStaff)
+	sb.sm.RegisterPatternUpdaterBool(t.base+".Skater(*).InBox", 2, t.sSetInBox) // Must be after box trips are loaded
+	sb.sm.RegisterKey(t.base+".Skater(*).ID", statemanager.TypeString, "ID of the skater")
+	sb.sm.RegisterKey(t.base+".Skater(*).Name", statemanager.TypeString, "Derby name of the skater").MaxLength(64)
+	sb.sm.RegisterKey(t.base+".Skater(*).LegalName", statemanager.TypeString, "Legal name of the skater").MaxLength(128).Personal()
+	sb.sm.RegisterKey(t.base+".Skater(*).InsuranceNumber", statemanager.TypeString, "Insurance number of the skater").MaxLength(64).Personal()
+	sb.sm.RegisterKey(t.base+".Skater(*).Number", statemanager.TypeString, "Number of the skater").MaxLength(8)
+	sb.sm.RegisterKey(t.base+".Skater(*).Position", statemanager.TypeString, "Position of the skater in the current jam").Enum(positionBench, positionJammer, positionPivot, positionBlocker)
+	sb.sm.RegisterKey(t.base+".Skater(*).IsAlt", statemanager.TypeBool, "Set if the skater is an alternate")
+	sb.sm.RegisterKey(t.base+".Skater(*).IsCaptain", statemanager.TypeBool, "Set if the skater is the captain")
+	sb.sm.RegisterKey(t.base+".Skater(*).IsAltCaptain", statemanager.TypeBool, "Set if the skater is the alternate captain")
+	sb.sm.RegisterKey(t.base+".Skater(*).IsBenchStaff", statemanager.TypeBool, "Set if the skater is bench staff")
+	sb.sm.RegisterKey(t.base+".Skater(*).InBox", statemanager.TypeBool, "Set if the skater is in the penalty box")
+	sb.sm.RegisterKey(t.base+".Skater(*).InLastJam", statemanager.TypeBool, "Set if the skater was in the last jam")
+	sb.sm.RegisterKey(t.base+".Skater(*).Description", statemanager.TypeString, "Description of the skater's roles")
+	sb.sm.RegisterKey(t.base+".Skater(*).ShortDescription", statemanager.TypeString, "Abbreviated description of the skater's roles")
+
+	// Setup Updaters for penalties (functions located in penalty.go)
+	sb.sm.RegisterPatternUpdaterString(t.base+".Skater(*).Penalty(*).Code", 1, t.pSetCode)    // Must be after jams are loaded
+	sb.sm.RegisterPatternUpdaterInt64(t.base+".Skater(*).Penalty(*).JamIdx", 1, t.pSetJamIdx) // Must be after jams are loaded
+	sb.sm.RegisterKey(t.base+".Skater(*).Penalty(*).Code", statemanager.TypeString, "Code of the penalty").MaxLength(4)
+	sb.sm.RegisterKey(t.base+".Skater(*).Penalty(*).JamIdx", statemanager.TypeInt64, "Index of the jam the penalty was in").AtLeast(0)
+	sb.sm.RegisterKey(t.base+".Skater(*).Penalty(*).Period", statemanager.TypeInt64, "Period the penalty was in")
+	sb.sm.RegisterKey(t.base+".Skater(*).Penalty(*).Jam", statemanager.TypeInt64, "Jam the penalty was in")
+
+	// Setup Updaters for box trips (functions located in box_trip.go)
+	sb.sm.RegisterPatternUpdaterString(t.base+".Skater(*).BoxTrip(*).Skater", 1, t.bSetSkater)                    // Must be after jams are loaded
+	sb.sm.RegisterPatternUpdaterInt64(t.base+".Skater(*).BoxTrip(*).In.JamIdx", 1, t.bSetInJamIdx)                // Must be after jams are loaded
+	sb.sm.RegisterPatternUpdaterBool(t.base+".Skater(*).BoxTrip(*).In.BetweenJams", 1, t.bSetInBetweenJams)       // Must be after jams are loaded
+	sb.sm.RegisterPatternUpdaterBool(t.base+".Skater(*).BoxTrip(*).In.AfterStarPass", 1, t.bSetInAfterStarPass)   // Must be after jams are loaded
+	sb.sm.RegisterPatternUpdaterInt64(t.base+".Skater(*).BoxTrip(*).Out.JamIdx", 1, t.bSetOutJamIdx)              // Must be after jams are loaded
+	sb.sm.RegisterPatternUpdaterBool(t.base+".Skater(*).BoxTrip(*).Out.BetweenJams", 1, t.bSetOutBetweenJams)     // Must be after jams are loaded
+	sb.sm.RegisterPatternUpdaterBool(t.base+".Skater(*).BoxTrip(*).Out.AfterStarPass", 1, t.bSetOutAfterStarPass) // Must be after jams are loaded
+	sb.sm.RegisterPatternUpdaterInt64(t.base+".Skater(*).BoxTrip(*).Duration", 1, t.bSetDuration)                 // Must be after jams are loaded
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).Skater", statemanager.TypeString, "ID of the skater in the box")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).In.JamIdx", statemanager.TypeInt64, "Index of the jam the skater entered the box in")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).In.Period", statemanager.TypeInt64, "Period the skater entered the box in")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).In.Jam", statemanager.TypeInt64, "Jam the skater entered the box in")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).In.BetweenJams", statemanager.TypeBool, "Set if the skater entered the box between jams")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).In.AfterStarPass", statemanager.TypeBool, "Set if the skater entered the box after a star pass")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).Out.JamIdx", statemanager.TypeInt64, "Index of the jam the skater left the box in")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).Out.Period", statemanager.TypeInt64, "Period the skater left the box in")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).Out.Jam", statemanager.TypeInt64, "Jam the skater left the box in")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).Out.BetweenJams", statemanager.TypeBool, "Set if the skater left the box between jams")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).Out.AfterStarPass", statemanager.TypeBool, "Set if the skater left the box after a star pass")
+	sb.sm.RegisterKey(t.base+".Skater(*).BoxTrip(*).Duration", statemanager.TypeInt64, "Jam clock time in milliseconds the skater has spent in the box")
 
 	t.reset()
 	return t
@@ -113,6 +241,9 @@ func (t *team) reset() {
 	} else {
 		t.setColor("White")
 	}
+	t.setLogo("")
+	t.setLeagueID("")
+	t.setLeagueTeamID("")
 	t.setScore(0)
 	t.setLastScore(0)
 	t.setTimeouts(3)
@@ -133,7 +264,57 @@ func (t *team) deleteSkater(data []string) error {
 		return errSkaterNotFound
 	}
 	delete(t.skaters, data[0])
-	return statemanager.StateDelete(t.base + ".Skater(" + data[0] + ")")
+	t.sb.sm.StateDelete(t.base + ".Skater(" + data[0] + ")")
+	t.updateStats()
+	return nil
+}
+
+// loadTeam replaces the team's name, color, logo and skaters with those
+// of a team saved in the leagues subsystem.  Only active roster entries
+// are loaded.
+// data: [leagueID, teamID]
+func (t *team) loadTeam(data []string) error {
+	if len(data) < 2 {
+		return &statemanager.ArgumentError{Command: t.base + ".LoadTeam", Reason: fmt.Sprintf("needs at least 2 arguments, got %v", len(data))}
+	}
+	if lsm := leagues.StateManager(); lsm != t.sb.sm {
+		lsm.Lock()
+		defer lsm.Unlock()
+	}
+	l := leagues.FindLeague(data[0])
+	if l == nil {
+		return errLeagueTeamNotFound
+	}
+	lt := l.Team(data[1])
+	if lt == nil {
+		return errLeagueTeamNotFound
+	}
+
+	for id := range t.skaters {
+		delete(t.skaters, id)
+		t.sb.sm.StateDelete(t.base + ".Skater(" + id + ")")
+	}
+	for _, j := range t.sb.jams {
+		j.clearTeamPositions(t)
+	}
+
+	t.setName(lt.Name())
+	t.setColor(lt.Color())
+	t.setLogo(lt.Logo())
+	t.setLeagueID(l.ID())
+	t.setLeagueTeamID(lt.ID())
+
+	for _, re := range lt.Roster(true) {
+		p := re.Person
+		t.skaters[p.ID()] = newSkater(t, p.ID(), p.Name(), p.LegalName(), p.InsuranceNumber(), p.Number(),
+			re.Role == leagues.RoleAlternate,
+			re.Role == leagues.RoleCaptain,
+			re.Role == leagues.RoleAltCaptain,
+			re.Role == leagues.RoleBenchStaff)
+	}
+	t.updatePositions()
+	t.updateStats()
+	return nil
 }
 
 func (t *team) stateBase() string {
@@ -169,12 +350,27 @@ func (t *team) retainOfficialReview(_ []string) error {
 
 func (t *team) setName(v string) error {
 	t.name = v
-	return statemanager.StateUpdateString(t.stateIDs["name"], v)
+	return t.sb.sm.StateUpdateString(t.stateIDs["name"], v)
 }
 
 func (t *team) setColor(v string) error {
 	t.color = v
-	return statemanager.StateUpdateString(t.stateIDs["color"], v)
+	return t.sb.sm.StateUpdateString(t.stateIDs["color"], v)
+}
+
+func (t *team) setLogo(v string) error {
+	t.logo = v
+	return t.sb.sm.StateUpdateString(t.stateIDs["logo"], v)
+}
+
+func (t *team) setLeagueID(v string) error {
+	t.leagueID = v
+	return t.sb.sm.StateUpdateString(t.stateIDs["leagueID"], v)
+}
+
+func (t *team) setLeagueTeamID(v string) error {
+	t.leagueTeamID = v
+	return t.sb.sm.StateUpdateString(t.stateIDs["leagueTeamID"], v)
 }
 
 func (t *team) setScore(v int64) error {
@@ -185,8 +381,8 @@ func (t *team) setScore(v int64) error {
 	if v < t.lastScore {
 		t.setLastScore(v)
 	}
-	statemanager.StateUpdateInt64(t.stateIDs["score"], v)
-	statemanager.StateUpdateInt64(t.stateIDs["jamScore"], t.score-t.lastScore)
+	t.sb.sm.StateUpdateInt64(t.stateIDs["score"], v)
+	t.updateJamScore()
 	return nil
 }
 
@@ -198,36 +394,47 @@ func (t *team) setLastScore(v int64) error {
 		return nil
 	}
 	t.lastScore = v
-	statemanager.StateUpdateInt64(t.stateIDs["lastScore"], v)
-	statemanager.StateUpdateInt64(t.stateIDs["jamScore"], t.score-t.lastScore)
+	t.sb.sm.StateUpdateInt64(t.stateIDs["lastScore"], v)
+	t.updateJamScore()
 	return nil
 }
 
+func (t *team) updateJamScore() {
+	if j := t.sb.scoringJam(); j != nil {
+		j.setTeamScore(t, t.score-j.teams[t.id-1].startScore)
+		t.updateStats()
+	}
+}
+
 func (t *team) setTimeouts(v int64) error {
 	t.timeouts = v
-	statemanager.StateUpdateInt64(t.stateIDs["timeouts"], v)
+	t.sb.sm.StateUpdateInt64(t.stateIDs["timeouts"], v)
 	return nil
 }
 
 func (t *team) setOfficialReviews(v int64) error {
 	t.officialReviews = v
-	statemanager.StateUpdateInt64(t.stateIDs["officialReviews"], v)
+	t.sb.sm.StateUpdateInt64(t.stateIDs["officialReviews"], v)
 	return nil
 }
 
 func (t *team) setOfficialReviewRetained(v bool) error {
 	t.officialReviewRetained = v
-	return statemanager.StateUpdateBool(t.stateIDs["officialReviewRetained"], v)
+	return t.sb.sm.StateUpdateBool(t.stateIDs["officialReviewRetained"], v)
 }
 
 func (t *team) setLead(v string) error {
 	t.lead = v
-	return statemanager.StateUpdateString(t.stateIDs["lead"], v)
+	if j := t.sb.activeJam; j != nil && j.started {
+		j.setTeamLead(t, v)
+		t.updateStats()
+	}
+	return t.sb.sm.StateUpdateString(t.stateIDs["lead"], v)
 }
 
 func (t *team) setStarPass(v bool) error {
 	t.starPass = v
-	return statemanager.StateUpdateBool(t.stateIDs["starPass"], v)
+	return t.sb.sm.StateUpdateBool(t.stateIDs["starPass"], v)
 }
 
 func (t *team) setJammer(v string) error {
@@ -262,26 +469,54 @@ func (t *team) setPivotInBox(v bool) error {
 	return s.setInBox(v)
 }
 
+// computePositionKeys keeps the Name, Number and InBox under base set to
+// those of the skater with the id in position
+func (t *team) computePositionKeys(base string, position *string) {
+	deps := func(k string) []string { return []string{base + ".ID", t.base + ".Skater(*)." + k} }
+	t.sb.sm.RegisterComputed(base+".Name", deps("Name"), func([]string) interface{} {
+		if s, ok := t.skaters[*position]; ok {
+			return s.name
+		}
+		return nil
+	})
+	t.sb.sm.RegisterComputed(base+".Number", deps("Number"), func([]string) interface{} {
+		if s, ok := t.skaters[*position]; ok {
+			return s.number
+		}
+		return nil
+	})
+	t.sb.sm.RegisterComputed(base+".InBox", deps("InBox"), func([]string) interface{} {
+		if s, ok := t.skaters[*position]; ok {
+			return s.inBox()
+		}
+		return nil
+	})
+}
+
+// skaterRoleKeys are the keys the skater descriptions are computed from
+func (t *team) skaterRoleKeys() []string {
+	return []string{
+		t.base + ".Skater(*).IsAlt",
+		t.base + ".Skater(*).IsCaptain",
+		t.base + ".Skater(*).IsAltCaptain",
+		t.base + ".Skater(*).IsBenchStaff",
+	}
+}
+
 func (t *team) updatePositions() {
 	t.jammer = ""
 	t.pivot = ""
-	statemanager.StateDelete(t.base + ".Jammer")
-	statemanager.StateDelete(t.base + ".Pivot")
+	t.sb.sm.StateDelete(t.base + ".Jammer")
+	t.sb.sm.StateDelete(t.base + ".Pivot")
 	t.sb.activeJam.clearTeamPositions(t)
 	for _, s := range t.skaters {
 		t.sb.activeJam.setTeamPosition(s)
 		if s.position == positionJammer {
 			t.jammer = s.id
-			statemanager.StateUpdateString(t.base+".Jammer.ID", s.id)
-			statemanager.StateUpdateString(t.base+".Jammer.Name", s.name)
-			statemanager.StateUpdateString(t.base+".Jammer.Number", s.number)
-			statemanager.StateUpdateBool(t.base+".Jammer.InBox", s.inBox())
+			t.sb.sm.StateUpdateString(t.base+".Jammer.ID", s.id)
 		} else if s.position == positionPivot {
 			t.pivot = s.id
-			statemanager.StateUpdateString(t.base+".Pivot.ID", s.id)
-			statemanager.StateUpdateString(t.base+".Pivot.Name", s.name)
-			statemanager.StateUpdateString(t.base+".Pivot.Number", s.number)
-			statemanager.StateUpdateBool(t.base+".Pivot.InBox", s.inBox())
+			t.sb.sm.StateUpdateString(t.base+".Pivot.ID", s.id)
 		}
 	}
 }
@@ -0,0 +1,60 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+// computedKey is a computed key defined in config/computed.json, for example
+//
+//	{
+//		"Key": "Custom.Team(*).Lead",
+//		"Depends": {"Name": "Scoreboard.Team(*).Name", "Score": "Scoreboard.Team(*).Score"},
+//		"Template": "{{.Name}}: {{.Score}}"
+//	}
+//
+// See statemanager.RegisterComputedTemplate for the template syntax.
+type computedKey struct {
+	Key         string
+	Description string
+	Depends     map[string]string
+	Template    string
+}
+
+// loadComputed registers the computed keys defined in name.json with sm
+func loadComputed(sm *statemanager.StateManager, name string) {
+	filename := filepath.Join(statemanager.BaseFilePath(), name+".json")
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Printf("server: Cannot read computed keys from %v: %v", filename, err)
+		return
+	}
+
+	var keys []computedKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		log.Printf("server: Cannot parse computed keys from %v: %v", filename, err)
+		return
+	}
+
+	sm.Lock()
+	defer sm.Unlock()
+	for _, k := range keys {
+		if err := sm.RegisterComputedTemplate(k.Key, k.Depends, k.Template); err != nil {
+			log.Printf("server: Cannot compute %v: %v", k.Key, err)
+			continue
+		}
+		sm.RegisterKey(k.Key, statemanager.TypeString, k.Description)
+	}
+}
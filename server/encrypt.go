@@ -0,0 +1,109 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rollerderby/crg/scoreboard"
+	"github.com/rollerderby/crg/statemanager"
+)
+
+// Public half of the recovery key pair created the first time files are
+// encrypted.  The private key decrypts the files if the passphrase or key
+// file is lost, so it is only ever written outside BaseFilePath.
+var recoveryPublicKey = filepath.Join("config", "recovery.pub")
+
+var errRecoveryKeyOut = errors.New("Recovery Key Must Be Written Outside The Scoreboard Folder")
+
+// HasRecoveryKey reports whether files have been encrypted before, so the
+// passphrase or key file in use must match the one used then
+func HasRecoveryKey() bool {
+	_, err := os.Stat(filepath.Join(statemanager.BaseFilePath(), recoveryPublicKey))
+	return err == nil
+}
+
+// encryptedFiles lists the files holding personal data: the leagues, the
+// paired devices, the archived games and the scoreboard of each track
+func encryptedFiles(tracks int) []string {
+	files := []string{"config/leagues", "config/auth", scoreboard.ArchivePath + "/"}
+	for id := 1; id <= tracks; id++ {
+		files = append(files, trackConfigPath(id, "scoreboard"))
+	}
+	return files
+}
+
+// SetupEncryption encrypts the files holding personal data with e, and
+// checks the files already saved can be decrypted.  The first time it is
+// called the recovery private key is written to recoveryOut, which must be
+// outside BaseFilePath.  It must be called before Start.
+func SetupEncryption(e *statemanager.Encryption, tracks int, recoveryOut string) error {
+	if tracks < 1 {
+		tracks = 1
+	}
+
+	public := filepath.Join(statemanager.BaseFilePath(), recoveryPublicKey)
+	if _, err := os.Stat(public); os.IsNotExist(err) {
+		if !outsideBasePath(recoveryOut) {
+			return errRecoveryKeyOut
+		}
+		if err := statemanager.CreateRecoveryKey(public, recoveryOut); err != nil {
+			return err
+		}
+		log.Print("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+		log.Printf("Created recovery key %v", recoveryOut)
+		log.Print("It can decrypt all saved data if the passphrase or key file is lost.")
+		log.Print("Keep it off this computer, somewhere safe.")
+		log.Print("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+	}
+	if err := e.SetRecoveryKey(public); err != nil {
+		return err
+	}
+
+	files := encryptedFiles(tracks)
+	statemanager.EncryptFiles(e, files...)
+	for _, name := range files {
+		if err := statemanager.CheckEncryption(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outsideBasePath returns true if filename is set and not within BaseFilePath
+func outsideBasePath(filename string) bool {
+	if filename == "" {
+		return false
+	}
+	base, err := filepath.Abs(statemanager.BaseFilePath())
+	if err != nil {
+		return false
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(base, abs)
+	return err == nil && (rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// MigrateEncryption encrypts the files set up by SetupEncryption in place,
+// including files saved before encryption was turned on
+func MigrateEncryption(tracks int) error {
+	if tracks < 1 {
+		tracks = 1
+	}
+	for _, name := range encryptedFiles(tracks) {
+		if err := statemanager.MigrateEncryption(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -15,7 +15,7 @@ import (
 	"github.com/rollerderby/crg/statemanager"
 )
 
-func addFileWatcher(mediaType, prefix, path string) (*fsnotify.Watcher, error) {
+func addFileWatcher(sm *statemanager.StateManager, mediaType, prefix, path string) (*fsnotify.Watcher, error) {
 	fullpath := filepath.Join(statemanager.BaseFilePath(), prefix, path)
 	os.MkdirAll(fullpath, 0775)
 
@@ -39,7 +39,7 @@ func addFileWatcher(mediaType, prefix, path string) (*fsnotify.Watcher, error) {
 		short := filepath.Base(name)
 		full := filepath.Join(path, short)
 
-		statemanager.StateUpdateString(fmt.Sprintf("Media.Type(%v).File(%v)", mediaType, full), short)
+		sm.StateUpdateString(fmt.Sprintf("Media.Type(%v).File(%v)", mediaType, full), short)
 	}
 
 	go func() {
@@ -50,11 +50,11 @@ func addFileWatcher(mediaType, prefix, path string) (*fsnotify.Watcher, error) {
 				full := filepath.Join(path, short)
 
 				if event.Op&fsnotify.Create == fsnotify.Create {
-					statemanager.StateUpdateString(fmt.Sprintf("Media.Type(%v).File(%v)", mediaType, full), short)
+					sm.StateUpdateString(fmt.Sprintf("Media.Type(%v).File(%v)", mediaType, full), short)
 				} else if event.Op&fsnotify.Rename == fsnotify.Rename {
-					statemanager.StateDelete(fmt.Sprintf("Media.Type(%v).File(%v)", mediaType, full))
+					sm.StateDelete(fmt.Sprintf("Media.Type(%v).File(%v)", mediaType, full))
 				} else if event.Op&fsnotify.Remove == fsnotify.Remove {
-					statemanager.StateDelete(fmt.Sprintf("Media.Type(%v).File(%v)", mediaType, full))
+					sm.StateDelete(fmt.Sprintf("Media.Type(%v).File(%v)", mediaType, full))
 				}
 			case err := <-watcher.Errors:
 				log.Println("error:", err)
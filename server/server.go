@@ -16,14 +16,17 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/rollerderby/crg/auth"
 	"github.com/rollerderby/crg/leagues"
-	"github.com/rollerderby/crg/scoreboard"
 	"github.com/rollerderby/crg/statemanager"
-	"github.com/rollerderby/crg/websocket"
 )
 
 var urls []string
 
+// journalCompactInterval is how often journals are compacted, the journal
+// before the last compaction is kept so at least this much history is on disk
+const journalCompactInterval = 30 * time.Minute
+
 func printStartup(port uint16) {
 	log.Print("")
 	log.Printf("CRG Scoreboard and Game System Version %v", version)
@@ -104,39 +107,42 @@ func openLog() *os.File {
 }
 
 // Start initalizes all scoreboard subsystems and starts up a webserver on port
-func Start(port uint16) {
+// hosting the number of tracks given
+func Start(port uint16, tracks int) {
 	l := openLog()
 	if l != nil {
 		defer l.Close()
 	}
 	mux := http.NewServeMux()
-	var savers []*statemanager.Saver
-
-	// Initialize statemanager and load Settings.*
-	statemanager.Initialize()
-	savers = append(savers, initSettings("config/settings"))
-
-	// Initialize leagues and load Leagues.*
-	leagues.Initialize()
-	savers = append(savers, statemanager.NewSaver("config/leagues", "Leagues", time.Duration(5)*time.Second, true, true))
-
-	// Initialize scoreboard and load Scoreboard.*
-	statemanager.Lock()
-	scoreboard.New()
-	statemanager.Unlock()
-	savers = append(savers, statemanager.NewSaver("config/scoreboard", "Scoreboard", time.Duration(5)*time.Second, true, true))
-
-	// Initialize websocket interface
-	websocket.Initialize(mux)
+	if tracks < 1 {
+		tracks = 1
+	}
 
-	addFileWatcher("TeamLogos", "html", "/images/teamlogo")
-	addFileWatcher("Sponsors", "html", "/images/sponsor_banner")
-	addFileWatcher("Image", "html", "/images/fullscreen")
-	addFileWatcher("Video", "html", "/videos")
-	addFileWatcher("CustomHtml", "html", "/customhtml")
+	// Initialize leagues and load Leagues.*, shared by all tracks
+	shared := statemanager.New()
+	leagues.Initialize(shared)
+	leaguesSaver := shared.NewSaver("config/leagues", "Leagues", time.Duration(5)*time.Second, true, true)
+	leaguesJournal := shared.NewJournal("config/leagues", "Leagues", journalCompactInterval)
+
+	// Initialize paired devices and load Auth.*, shared by all tracks
+	devices := statemanager.New()
+	auth.Initialize(devices)
+	devicesSaver := devices.NewSaver("config/auth", "Auth", time.Duration(5)*time.Second, true, true)
+
+	var ts []*track
+	for id := 1; id <= tracks; id++ {
+		t := newTrack(id, shared, devices)
+		ts = append(ts, t)
+		mux.Handle(t.prefix()+"/", http.StripPrefix(t.prefix(), t.mux))
+	}
 
 	printStartup(port)
-	mux.Handle("/", http.FileServer(http.Dir(filepath.Join(statemanager.BaseFilePath(), "html"))))
+	if pin, err := auth.BootstrapPIN(); err != nil {
+		log.Print("Cannot create admin pairing: ", err)
+	} else if pin != "" {
+		log.Printf("No admin device is paired, open /pair.html and enter PIN %v within %v to pair one", pin, auth.BootstrapTimeout)
+	}
+	mux.Handle("/", ts[0].mux)
 
 	c := make(chan os.Signal, 1)
 	go func() {
@@ -149,12 +155,17 @@ func Start(port uint16) {
 
 	mux.Handle("/version", http.HandlerFunc(versionHandler))
 	mux.Handle("/urls", http.HandlerFunc(urlsHandler))
+	mux.Handle("/leagues/duplicates", auth.Handler(leagues.DuplicatesHandler, auth.RoleAdmin))
+	mux.Handle("/tracks", tracksHandler(ts))
 
 	signal.Notify(c, os.Interrupt, os.Kill)
 	s := <-c
 	log.Printf("Server received signal: %v.  Shutting down", s)
 
-	for _, saver := range savers {
-		saver.Close()
+	for _, t := range ts {
+		t.close()
 	}
+	leaguesJournal.Close()
+	leaguesSaver.Close()
+	devicesSaver.Close()
 }
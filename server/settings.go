@@ -13,16 +13,18 @@ import (
 	"github.com/rollerderby/crg/statemanager"
 )
 
-func setSettings(k, v string) error {
-	v = strings.TrimSpace(v)
-	if v == "" {
-		return statemanager.StateDelete(k)
+func setSettings(sm *statemanager.StateManager) func(k, v string) error {
+	return func(k, v string) error {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return sm.StateDelete(k)
+		}
+		return sm.StateUpdateString(k, v)
 	}
-	return statemanager.StateUpdateString(k, v)
 }
 
 // setup default settings
-func initSettings(saveFile string) *statemanager.Saver {
+func initSettings(sm *statemanager.StateManager, saveFile string) *statemanager.Saver {
 	defaults := []struct{ name, value string }{
 		{"BackgroundStyle", "bg_blacktowhite"},
 		{"BoxStyle", "box_flat"},
@@ -34,13 +36,14 @@ func initSettings(saveFile string) *statemanager.Saver {
 		{"CustomHtml", "/customhtml/example"},
 	}
 	views := []string{"View", "Preview"}
-	statemanager.Lock()
+	sm.Lock()
 	for _, v := range views {
 		for _, d := range defaults {
-			statemanager.StateUpdateString(fmt.Sprintf("Settings.%v.%v", v, d.name), d.value)
+			sm.StateUpdateString(fmt.Sprintf("Settings.%v.%v", v, d.name), d.value)
 		}
 	}
-	statemanager.RegisterPatternUpdaterString("Settings", 0, setSettings)
-	statemanager.Unlock()
-	return statemanager.NewSaver(saveFile, "Settings", time.Duration(5)*time.Second, true, true)
+	sm.RegisterPatternUpdaterString("Settings", 0, setSettings(sm))
+	sm.RegisterKey("Settings.*", statemanager.TypeString, "Display settings, Settings.View.<Name> for the main view and Settings.Preview.<Name> for the preview.  Setting an empty value removes the setting").MaxLength(1024)
+	sm.Unlock()
+	return sm.NewSaver(saveFile, "Settings", time.Duration(5)*time.Second, true, true)
 }
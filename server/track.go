@@ -0,0 +1,167 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/rollerderby/crg/auth"
+	"github.com/rollerderby/crg/scoreboard"
+	"github.com/rollerderby/crg/statemanager"
+	"github.com/rollerderby/crg/stats"
+	"github.com/rollerderby/crg/websocket"
+)
+
+// track is one scoreboard hosted by the server, with its own state tree,
+// savers and websocket.  Track 1 is also served from the root of the server.
+type track struct {
+	id      int
+	name    string
+	sm      *statemanager.StateManager
+	sb      *scoreboard.Scoreboard
+	mux     *http.ServeMux
+	savers  []*statemanager.Saver
+	journal *statemanager.Journal
+}
+
+func newTrack(id int, shared, devices *statemanager.StateManager) *track {
+	t := &track{
+		id:   id,
+		name: fmt.Sprintf("Track %d", id),
+		sm:   statemanager.New(),
+		mux:  http.NewServeMux(),
+	}
+	log.Printf("server: Starting %v", t.name)
+
+	// Load Settings.* and make the shared Leagues.* and Auth.* available
+	t.savers = append(t.savers, initSettings(t.sm, t.configPath("settings")))
+	t.sm.Share("Leagues", shared)
+	t.sm.Share("Auth", devices)
+
+	// Initialize scoreboard and load Scoreboard.*
+	t.sm.Lock()
+	t.sb = scoreboard.New(t.sm)
+	t.sm.Unlock()
+	t.savers = append(t.savers, t.sm.NewSaver(t.configPath("scoreboard"), "Scoreboard", time.Duration(5)*time.Second, true, true))
+	t.journal = t.sm.NewJournal(t.configPath("scoreboard"), "Scoreboard", journalCompactInterval)
+
+	// Add the computed keys defined by view authors
+	loadComputed(t.sm, t.configPath("computed"))
+
+	// Initialize websocket interface
+	websocket.Initialize(t.mux, t.sm)
+
+	// Initialize season statistics from archived games
+	stats.Initialize(t.mux, t.sm)
+
+	addFileWatcher(t.sm, "TeamLogos", "html", "/images/teamlogo")
+	addFileWatcher(t.sm, "Sponsors", "html", "/images/sponsor_banner")
+	addFileWatcher(t.sm, "Image", "html", "/images/fullscreen")
+	addFileWatcher(t.sm, "Video", "html", "/videos")
+	addFileWatcher(t.sm, "CustomHtml", "html", "/customhtml")
+
+	t.mux.HandleFunc("/listeners", auth.Handler(listenersHandler(t.sm), auth.RoleAdmin))
+	t.mux.HandleFunc("/schema", auth.Handler(schemaHandler(t.sm), auth.StaffRoles...))
+	t.mux.HandleFunc("/commands", auth.Handler(commandsHandler(t.sm), auth.StaffRoles...))
+	t.mux.Handle("/", http.FileServer(http.Dir(filepath.Join(statemanager.BaseFilePath(), "html"))))
+	return t
+}
+
+// configPath returns the file name the track saves to, see trackConfigPath
+func (t *track) configPath(name string) string {
+	return trackConfigPath(t.id, name)
+}
+
+// trackConfigPath returns the file name track id saves to, track 1 keeps
+// the file names used before multiple tracks were supported
+func trackConfigPath(id int, name string) string {
+	if id == 1 {
+		return filepath.Join("config", name)
+	}
+	return filepath.Join("config", fmt.Sprintf("track%d", id), name)
+}
+
+// prefix is the path the track is served under
+func (t *track) prefix() string {
+	return fmt.Sprintf("/track/%d", t.id)
+}
+
+func (t *track) close() {
+	t.sb.Close()
+	t.journal.Close()
+	for _, saver := range t.savers {
+		saver.Close()
+	}
+}
+
+var tracksTemplate = template.Must(template.New("tracks").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>CRG Scoreboard Tracks</title></head>
+<body>
+<h1>Tracks</h1>
+<ul>
+{{range .}}<li><a href="{{.Prefix}}/">{{.Name}}</a> (websocket {{.Prefix}}/ws)</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+func tracksHandler(tracks []*track) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		var list []struct{ Name, Prefix string }
+		for _, t := range tracks {
+			list = append(list, struct{ Name, Prefix string }{t.name, t.prefix()})
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tracksTemplate.Execute(w, list); err != nil {
+			log.Print("server: Cannot write tracks page: ", err)
+		}
+	}
+}
+
+// listenersHandler reports how far behind each listener of sm is
+func listenersHandler(sm *statemanager.StateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(sm.ListenerStats()); err != nil {
+			log.Print("server: Cannot write listener stats: ", err)
+		}
+	}
+}
+
+// schemaHandler describes the keys registered with sm
+func schemaHandler(sm *statemanager.StateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		sm.Lock()
+		schema := sm.Schema()
+		sm.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(schema); err != nil {
+			log.Print("server: Cannot write schema: ", err)
+		}
+	}
+}
+
+// commandsHandler describes the commands registered with sm
+func commandsHandler(sm *statemanager.StateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		sm.Lock()
+		commands := sm.Commands()
+		sm.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(commands); err != nil {
+			log.Print("server: Cannot write commands: ", err)
+		}
+	}
+}
@@ -5,47 +5,279 @@
 
 package statemanager
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // CommandFunc is a callback function when a command is triggered.
 // Commands can be registered by RegisterCommand and unregistered
 // by UnregisterCommand
 type CommandFunc func([]string) error
 
+// CommandResultFunc is a command that returns a result to the caller,
+// registered by RegisterCommandResult
+type CommandResultFunc func([]string) (interface{}, error)
+
+// Arg describes an argument of a command.  The last argument may be
+// Variadic, taking all of the remaining values.
+type Arg struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Optional    bool   `json:"optional,omitempty"`
+	Variadic    bool   `json:"variadic,omitempty"`
+}
+
+// CommandInfo describes a command and its arguments, see DescribeCommand
+type CommandInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Args        []Arg  `json:"args"`
+}
+
+// ArgumentError is returned when the arguments of a command don't match
+// those it was described with
+type ArgumentError struct {
+	Command string
+	Arg     string
+	Reason  string
+}
+
+func (e *ArgumentError) Error() string {
+	if e.Arg == "" {
+		return fmt.Sprintf("Invalid Arguments: %v %v", e.Command, e.Reason)
+	}
+	return fmt.Sprintf("Invalid Arguments: %v %v %v", e.Command, e.Arg, e.Reason)
+}
+
+// Error codes returned by ErrorCode
+const (
+	CodeNotFound         = "NotFound"
+	CodeInvalidArguments = "InvalidArguments"
+	CodeInvalidValue     = "InvalidValue"
+	CodeConflict         = "Conflict"
+	CodePermissionDenied = "PermissionDenied"
+	CodeFailed           = "Failed"
+)
+
 var errCommandNotFound = errors.New("Command Not Found")
 var errCommandArguments = errors.New("Incorrect Argument Count")
-var commands = make(map[string]CommandFunc)
+
+var setInfo = CommandInfo{
+	Name:        "Set",
+	Description: "Sets a key through its updater",
+	Args: []Arg{
+		{Name: "Key", Type: TypeString},
+		{Name: "Value", Type: TypeString},
+	},
+}
+
+// ErrorCode classifies an error returned by a command for clients, it
+// returns "" for nil.  Errors with a Code() method give their own code.
+func ErrorCode(err error) string {
+	switch e := err.(type) {
+	case nil:
+		return ""
+	case interface{ Code() string }:
+		return e.Code()
+	case *ArgumentError:
+		return CodeInvalidArguments
+	case *ConstraintError:
+		return CodeInvalidValue
+	case *ConflictError:
+		return CodeConflict
+	}
+	switch {
+	case err == errCommandArguments:
+		return CodeInvalidArguments
+	case strings.HasSuffix(err.Error(), "Not Found"):
+		return CodeNotFound
+	}
+	return CodeFailed
+}
 
 // Command requests the command registered with name be called
 // and passed data as parameters.  Returns nil error on success,
 // errCommandNotFound, errCommandArguments, or an error from the
 // registered command function
-func Command(name string, data []string) error {
-	Lock()
-	defer Unlock()
+func (sm *StateManager) Command(name string, data []string) error {
+	_, err := sm.CommandResult(name, data)
+	return err
+}
+
+// CommandResult calls the command name like Command, also returning the
+// result of commands registered with RegisterCommandResult
+func (sm *StateManager) CommandResult(name string, data []string) (interface{}, error) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	result, err := sm.command(name, data)
+	for _, j := range sm.journals {
+		j.command(name, data, err)
+	}
+	return result, err
+}
 
+func (sm *StateManager) command(name string, data []string) (interface{}, error) {
 	if name == "Set" {
-		if len(data) != 2 {
-			return errCommandArguments
+		if err := checkArgs(setInfo, data); err != nil {
+			return nil, err
 		}
-		return StateSet(data[0], data[1])
+		return nil, sm.StateSet(data[0], data[1])
+	}
+
+	if s := sm.findShare(name); s != nil {
+		return s.from.CommandResult(name, data)
 	}
 
-	c, ok := commands[name]
+	c, ok := sm.commands[name]
 	if !ok {
-		return errCommandNotFound
+		return nil, errCommandNotFound
+	}
+	if info, ok := sm.commandInfo[schemaPattern(name)]; ok {
+		if err := checkArgs(*info, data); err != nil {
+			return nil, err
+		}
 	}
 	return c(data)
 }
 
+// checkArgs returns an *ArgumentError if data doesn't match the arguments
+// described by info
+func checkArgs(info CommandInfo, data []string) error {
+	fail := func(arg, format string, args ...interface{}) error {
+		return &ArgumentError{Command: info.Name, Arg: arg, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	required, variadic := 0, false
+	for _, a := range info.Args {
+		if !a.Optional {
+			required++
+		}
+		variadic = variadic || a.Variadic
+	}
+	if len(data) < required {
+		return fail("", "needs at least %v arguments, got %v", required, len(data))
+	}
+	if !variadic && len(data) > len(info.Args) {
+		return fail("", "takes at most %v arguments, got %v", len(info.Args), len(data))
+	}
+
+	for i, v := range data {
+		a := info.Args[len(info.Args)-1]
+		if i < len(info.Args) {
+			a = info.Args[i]
+		}
+		if err := checkArg(a.Type, v); err != nil {
+			return fail(a.Name, "must be a %v", a.Type)
+		}
+	}
+	return nil
+}
+
+// checkArg returns an error if v cannot be parsed as the type t
+func checkArg(t, v string) error {
+	var err error
+	switch t {
+	case TypeInt64:
+		_, err = strconv.ParseInt(v, 10, 64)
+	case TypeBool:
+		_, err = strconv.ParseBool(v)
+	case TypeTime:
+		_, err = time.Parse(time.RFC3339Nano, v)
+	case TypeFloat64:
+		_, err = strconv.ParseFloat(v, 64)
+	case TypeDuration:
+		_, err = parseDuration(v)
+	case TypeJSON:
+		if !json.Valid([]byte(v)) {
+			err = errInvalidJSON
+		}
+	}
+	return err
+}
+
 // RegisterCommand registers the CommandFunc with the command
 // subsystem with name
-func RegisterCommand(name string, c CommandFunc) {
-	commands[name] = c
+func (sm *StateManager) RegisterCommand(name string, c CommandFunc) {
+	sm.commands[name] = func(data []string) (interface{}, error) { return nil, c(data) }
+}
+
+// RegisterCommandResult registers the CommandResultFunc with the command
+// subsystem with name
+func (sm *StateManager) RegisterCommandResult(name string, c CommandResultFunc) {
+	sm.commands[name] = c
+}
+
+// DescribeCommand describes the command (or commands differing only by
+// their IDs) name and its arguments.  Calls are checked against args before
+// the command is called.
+func (sm *StateManager) DescribeCommand(name, description string, args ...Arg) {
+	sm.commandInfo[schemaPattern(name)] = &CommandInfo{Name: name, Description: description, Args: args}
 }
 
 // UnregisterCommand removes the CommandFunc registered with
 // name from the command subsystem
-func UnregisterCommand(name string) {
-	delete(commands, name)
+func (sm *StateManager) UnregisterCommand(name string) {
+	delete(sm.commands, name)
+}
+
+// Commands describes the registered commands, including those shared from
+// other StateManagers, sorted by name.  Commands that haven't been
+// described only have their name filled in.
+func (sm *StateManager) Commands() []CommandInfo {
+	commands := []CommandInfo{setInfo}
+	for name := range sm.commands {
+		info := CommandInfo{Name: name}
+		if i, ok := sm.commandInfo[schemaPattern(name)]; ok {
+			info = *i
+			info.Name = name
+		}
+		commands = append(commands, info)
+	}
+	for _, s := range sm.shares {
+		s.from.Lock()
+		for _, info := range s.from.Commands() {
+			if info.Name != "Set" && s.pm.Matches(info.Name) {
+				commands = append(commands, info)
+			}
+		}
+		s.from.Unlock()
+	}
+
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// Command calls the command name on the Default StateManager, see StateManager.Command
+func Command(name string, data []string) error { return defaultStateManager.Command(name, data) }
+
+// CommandResult calls the command name on the Default StateManager, see StateManager.CommandResult
+func CommandResult(name string, data []string) (interface{}, error) {
+	return defaultStateManager.CommandResult(name, data)
+}
+
+// RegisterCommand registers a command with the Default StateManager, see StateManager.RegisterCommand
+func RegisterCommand(name string, c CommandFunc) { defaultStateManager.RegisterCommand(name, c) }
+
+// RegisterCommandResult registers a command with the Default StateManager, see StateManager.RegisterCommandResult
+func RegisterCommandResult(name string, c CommandResultFunc) {
+	defaultStateManager.RegisterCommandResult(name, c)
 }
+
+// DescribeCommand describes a command of the Default StateManager, see StateManager.DescribeCommand
+func DescribeCommand(name, description string, args ...Arg) {
+	defaultStateManager.DescribeCommand(name, description, args...)
+}
+
+// UnregisterCommand removes a command from the Default StateManager, see StateManager.UnregisterCommand
+func UnregisterCommand(name string) { defaultStateManager.UnregisterCommand(name) }
+
+// Commands describes the commands of the Default StateManager, see StateManager.Commands
+func Commands() []CommandInfo { return defaultStateManager.Commands() }
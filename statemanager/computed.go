@@ -0,0 +1,199 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ComputeFunc returns the value of a computed key, ids are the IDs in the
+// key matched by the (*)s of its pattern.  Returning a value of one of the
+// state types (see StateUpdate*) sets the key, nil deletes it.
+type ComputeFunc func(ids []string) interface{}
+
+type computed struct {
+	pattern string
+	f       ComputeFunc
+}
+
+type dependency struct {
+	pattern string
+	c       *computed
+}
+
+// maxComputeRounds stops computed keys that depend on each other in a loop
+const maxComputeRounds = 10
+
+// RegisterComputed keeps the keys matching pattern set to the result of f.
+// They are recomputed whenever a key matching one of deps changes.  The IDs
+// matched by the (*)s of the dependency fill in the (*)s of pattern in
+// order, if they don't fill them all every existing key matching pattern
+// is recomputed.
+func (sm *StateManager) RegisterComputed(pattern string, deps []string, f ComputeFunc) {
+	c := &computed{pattern: pattern, f: f}
+	for _, d := range deps {
+		sm.computedIndex.add(d, &dependency{pattern: d, c: c})
+	}
+
+	// Compute from the current state
+	for k, s := range sm.states {
+		if !s.isEmpty {
+			sm.computePending = append(sm.computePending, k)
+		}
+	}
+	sm.recompute()
+}
+
+// recompute updates the computed keys depending on the keys changed since
+// the last recompute
+func (sm *StateManager) recompute() {
+	for round := 0; len(sm.computePending) > 0; round++ {
+		if round == maxComputeRounds {
+			log.Printf("statemanager: Computed keys still changing after %v rounds", round)
+			sm.computePending = nil
+			return
+		}
+
+		pending := sm.computePending
+		sm.computePending = nil
+		keys := make(map[string]*computed)
+		for _, k := range pending {
+			for _, m := range sm.computedIndex.lookup(k) {
+				d := m.(*dependency)
+				sm.computedKeys(d, k, keys)
+			}
+		}
+		for k, c := range keys {
+			sm.setComputed(k, c)
+		}
+	}
+}
+
+// computedKeys adds the keys of d.c to recompute when k changes to keys
+func (sm *StateManager) computedKeys(d *dependency, k string, keys map[string]*computed) {
+	key := fillPattern(d.c.pattern, wildcardIDs(d.pattern, k))
+	if !strings.Contains(key, "(*)") {
+		keys[key] = d.c
+		return
+	}
+
+	pm := newPatternMatcher(key)
+	for name, s := range sm.states {
+		if !s.isEmpty && pm.Matches(name) {
+			keys[name] = d.c
+		}
+	}
+}
+
+func (sm *StateManager) setComputed(k string, c *computed) {
+	switch v := c.f(wildcardIDs(c.pattern, k)).(type) {
+	case nil:
+		if s, ok := sm.states[k]; ok && !s.isEmpty {
+			sm.changed(s)
+			s.isEmpty = true
+		}
+	case string:
+		sm.StateUpdateString(k, v)
+	case int64:
+		sm.StateUpdateInt64(k, v)
+	case bool:
+		sm.StateUpdateBool(k, v)
+	case time.Time:
+		sm.StateUpdateTime(k, v)
+	case float64:
+		sm.StateUpdateFloat64(k, v)
+	case time.Duration:
+		sm.StateUpdateDuration(k, v)
+	case json.RawMessage:
+		sm.StateUpdateJSON(k, v)
+	default:
+		log.Printf("statemanager: Unknown type '%T' computed for %v", v, k)
+	}
+}
+
+// wildcardIDs returns the IDs in key matched by the (*)s in pattern
+func wildcardIDs(pattern, key string) []string {
+	var ids []string
+	psegs, ksegs := splitKey(pattern), splitKey(key)
+	for i := 0; i < len(psegs) && i < len(ksegs); i++ {
+		if _, ok := wildcardName(psegs[i]); !ok {
+			continue
+		}
+		if name, ok := idName(ksegs[i]); ok {
+			ids = append(ids, ksegs[i][len(name)+1:len(ksegs[i])-1])
+		}
+	}
+	return ids
+}
+
+// fillPattern replaces the (*)s in pattern with ids in order
+func fillPattern(pattern string, ids []string) string {
+	for _, id := range ids {
+		pattern = strings.Replace(pattern, "(*)", "("+id+")", 1)
+	}
+	return pattern
+}
+
+var computedFuncs = template.FuncMap{
+	"add": func(a, b string) int64 { return parseInt(a) + parseInt(b) },
+	"sub": func(a, b string) int64 { return parseInt(a) - parseInt(b) },
+}
+
+func parseInt(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+// RegisterComputedTemplate keeps the keys matching pattern set to the
+// result of executing the text/template text.  deps names the keys the
+// template uses, their (*)s are filled in with the IDs of the computed key,
+// so {"Score": "Scoreboard.Team(*).Score"} makes the score of the team
+// available as {{.Score}}.  The template functions add and sub do
+// arithmetic on the values.
+func (sm *StateManager) RegisterComputedTemplate(pattern string, deps map[string]string, text string) error {
+	tmpl, err := template.New(pattern).Funcs(computedFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return err
+	}
+
+	var depList []string
+	for _, d := range deps {
+		depList = append(depList, d)
+	}
+	sm.RegisterComputed(pattern, depList, func(ids []string) interface{} {
+		values := make(map[string]string)
+		for name, d := range deps {
+			if s, ok := sm.states[fillPattern(d, ids)]; ok {
+				if v, isEmpty := s.Value(); !isEmpty {
+					values[name] = v
+				}
+			}
+		}
+
+		var b strings.Builder
+		if err := tmpl.Execute(&b, values); err != nil {
+			log.Printf("statemanager: Cannot compute %v: %v", pattern, err)
+			return nil
+		}
+		return b.String()
+	})
+	return nil
+}
+
+// RegisterComputed adds a computed key to the Default StateManager, see StateManager.RegisterComputed
+func RegisterComputed(pattern string, deps []string, f ComputeFunc) {
+	defaultStateManager.RegisterComputed(pattern, deps, f)
+}
+
+// RegisterComputedTemplate adds a computed key to the Default StateManager, see StateManager.RegisterComputedTemplate
+func RegisterComputedTemplate(pattern string, deps map[string]string, text string) error {
+	return defaultStateManager.RegisterComputedTemplate(pattern, deps, text)
+}
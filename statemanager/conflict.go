@@ -0,0 +1,100 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import "fmt"
+
+// ConflictError is returned by CommandIf when a key has changed since the
+// stateNum the change was based on.  Value is the current value of Key (nil
+// if it has been deleted) as of StateNum.
+type ConflictError struct {
+	Key      string
+	Value    *string
+	StateNum uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("State Conflict: %v has changed", e.Key)
+}
+
+// checkConflict returns a *ConflictError if any state matching keys (see
+// PatternMatch for examples of matching) and visible (if it isn't nil) has
+// changed after stateNum
+func (sm *StateManager) checkConflict(keys []string, stateNum uint64, visible func(string) bool) error {
+	for _, k := range keys {
+		pm := newPatternMatcher(k)
+		for name, s := range sm.states {
+			if s.stateNum > stateNum && pm.Matches(name) && (visible == nil || visible(name)) {
+				e := &ConflictError{Key: name, StateNum: sm.stateNum}
+				if v, empty := s.Value(); !empty {
+					e.Value = &v
+				}
+				return e
+			}
+		}
+
+		// Deleted states may have been collected, look for them in the history
+		changes := sm.history.changes
+		for i := len(changes) - 1; i >= 0 && changes[i].stateNum > stateNum; i-- {
+			name := changes[i].name
+			if _, ok := sm.states[name]; !ok && pm.Matches(name) && (visible == nil || visible(name)) {
+				return &ConflictError{Key: name, StateNum: sm.stateNum}
+			}
+		}
+	}
+	return nil
+}
+
+// CommandIf calls the command name like Command, unless any of the states
+// matching keys have changed after stateNum, when a *ConflictError is
+// returned instead.  For Set the key being set is always checked.
+func (sm *StateManager) CommandIf(name string, data []string, stateNum uint64, keys []string) error {
+	_, err := sm.CommandIfResult(name, data, stateNum, keys)
+	return err
+}
+
+// CommandIfResult calls the command name like CommandIf, also returning the
+// result of commands registered with RegisterCommandResult
+func (sm *StateManager) CommandIfResult(name string, data []string, stateNum uint64, keys []string) (interface{}, error) {
+	return sm.CommandIfVisible(name, data, stateNum, keys, nil)
+}
+
+// CommandIfVisible calls the command name like CommandIfResult, only
+// checking the states for which visible returns true.  Changes to states
+// the caller cannot read are never reported as conflicts, so keys can't be
+// used to read them.  visible is called with the statemanager locked.
+func (sm *StateManager) CommandIfVisible(name string, data []string, stateNum uint64, keys []string, visible func(string) bool) (interface{}, error) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	if name == "Set" && len(data) > 0 {
+		keys = append(keys[:len(keys):len(keys)], data[0])
+	}
+	var result interface{}
+	err := sm.checkConflict(keys, stateNum, visible)
+	if err == nil {
+		result, err = sm.command(name, data)
+	}
+	for _, j := range sm.journals {
+		j.command(name, data, err)
+	}
+	return result, err
+}
+
+// CommandIf calls the command name on the Default StateManager, see StateManager.CommandIf
+func CommandIf(name string, data []string, stateNum uint64, keys []string) error {
+	return defaultStateManager.CommandIf(name, data, stateNum, keys)
+}
+
+// CommandIfResult calls the command name on the Default StateManager, see StateManager.CommandIfResult
+func CommandIfResult(name string, data []string, stateNum uint64, keys []string) (interface{}, error) {
+	return defaultStateManager.CommandIfResult(name, data, stateNum, keys)
+}
+
+// CommandIfVisible calls the command name on the Default StateManager, see StateManager.CommandIfVisible
+func CommandIfVisible(name string, data []string, stateNum uint64, keys []string, visible func(string) bool) (interface{}, error) {
+	return defaultStateManager.CommandIfVisible(name, data, stateNum, keys, visible)
+}
@@ -0,0 +1,519 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PassphraseIterations is the number of PBKDF2 iterations used to turn a
+// passphrase into a key
+var PassphraseIterations = 100000
+
+// encryptionFormat identifies files written by Encryption
+const encryptionFormat = "aes-256-gcm"
+
+// recoveryLabel is the OAEP label of content keys wrapped for recovery
+var recoveryLabel = []byte("crg-recovery")
+
+var errEncrypted = errors.New("File Is Encrypted")
+var errDecrypt = errors.New("Cannot Decrypt, Wrong Passphrase or Key")
+var errNotEncrypted = errors.New("File Is Not Set To Be Encrypted")
+var errKeyFile = errors.New("Invalid Key File")
+var errRecoveryKey = errors.New("Invalid Recovery Key")
+
+// encryptedFile is the envelope of an encrypted file.  The data is
+// encrypted with a random content key, which is stored wrapped with the
+// key from the passphrase (or key file) and, if a recovery key is set, with
+// the recovery public key.  Encrypted journals start with a line holding
+// only the keys, each following line only holds the ciphertext of an entry.
+type encryptedFile struct {
+	Encrypted   string `json:"encrypted,omitempty"`
+	Salt        []byte `json:"salt,omitempty"`
+	Iterations  int    `json:"iterations,omitempty"`
+	Key         []byte `json:"key,omitempty"`
+	RecoveryKey []byte `json:"recoveryKey,omitempty"`
+	Ciphertext  []byte `json:"ciphertext,omitempty"`
+}
+
+// Encryption encrypts the files of Savers and Journals at rest, with a key
+// derived from a passphrase or read from a key file.  Set it up for files
+// with EncryptFiles.
+type Encryption struct {
+	mu              sync.Mutex
+	secret          []byte
+	passphrase      bool
+	salt            []byte
+	derived         map[string][]byte
+	recovery        *rsa.PublicKey
+	recoveryPrivate *rsa.PrivateKey
+	header          *encryptedFile
+	contentKey      []byte
+}
+
+// NewPassphraseEncryption encrypts with a key derived from passphrase
+func NewPassphraseEncryption(passphrase string) (*Encryption, error) {
+	salt, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryption{secret: []byte(passphrase), passphrase: true, salt: salt, derived: make(map[string][]byte)}, nil
+}
+
+// NewKeyFileEncryption encrypts with the key in filename, which holds 32
+// bytes in hex.  A new key is written to filename if it doesn't exist.
+func NewKeyFileEncryption(filename string) (*Encryption, error) {
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		key, err := randomBytes(32)
+		if err != nil {
+			return nil, err
+		}
+		os.MkdirAll(filepath.Dir(filename), 0775)
+		if err := writeKeyFile(filename, []byte(hex.EncodeToString(key)+"\n")); err != nil {
+			return nil, err
+		}
+		log.Printf("statemanager: Created key file %v", filename)
+		return &Encryption{secret: key}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, errKeyFile
+	}
+	return &Encryption{secret: key}, nil
+}
+
+// CreateRecoveryKey writes a new recovery key pair, the public key to
+// publicFile and the private key to privateFile.  The private key can
+// decrypt every file encrypted while the public key is set (see
+// SetRecoveryKey) and should be kept away from the files it protects.
+func CreateRecoveryKey(publicFile, privateFile string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(privateFile), 0775)
+	priv := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := writeKeyFile(privateFile, priv); err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(publicFile), 0775)
+	return writeFileSync(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+}
+
+// writeKeyFile writes a new file, readable only by its owner from the
+// moment it is created.  An existing file is never overwritten.
+func writeKeyFile(filename string, b []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// SetRecoveryKey wraps the key of files written from now on with the
+// recovery public key in filename, see CreateRecoveryKey
+func (e *Encryption) SetRecoveryKey(filename string) error {
+	block, err := readPEM(filename)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return errRecoveryKey
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recovery = rsaPub
+	e.header = nil
+	return nil
+}
+
+// Recover lets files whose passphrase or key file has been lost be read
+// with the recovery private key in filename.  Use MigrateEncryption to
+// rewrite them with the new passphrase or key.
+func (e *Encryption) Recover(filename string) error {
+	block, err := readPEM(filename)
+	if err != nil {
+		return err
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recoveryPrivate = key
+	return nil
+}
+
+func readPEM(filename string) (*pem.Block, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errRecoveryKey
+	}
+	return block, nil
+}
+
+var encryptionLock sync.Mutex
+var encryptedFiles = make(map[string]*Encryption)
+
+// EncryptFiles encrypts the files written for names (relative to
+// BaseFilePath, without .json or .journal) by Savers and Journals with e.
+// It must be called before the Savers and Journals are created.  Files
+// that aren't encrypted yet are still loaded, and encrypted the next time
+// they are written, or straight away by MigrateEncryption.  A name ending in
+// "/" covers every file in that directory, such as those written by
+// SaveState.
+func EncryptFiles(e *Encryption, names ...string) {
+	encryptionLock.Lock()
+	defer encryptionLock.Unlock()
+	for _, name := range names {
+		encryptedFiles[name] = e
+	}
+}
+
+func encryptionFor(name string) *Encryption {
+	encryptionLock.Lock()
+	defer encryptionLock.Unlock()
+	if e, ok := encryptedFiles[name]; ok {
+		return e
+	}
+	return encryptedFiles[filepath.ToSlash(filepath.Dir(name))+"/"]
+}
+
+// dirFiles returns the names of the saved states in the directory name
+// (ending in "/"), or just name if it isn't a directory
+func dirFiles(name string) []string {
+	if !strings.HasSuffix(name, "/") {
+		return []string{name}
+	}
+	files, _ := filepath.Glob(filepath.Join(baseFilePath, name, "*.json"))
+	var names []string
+	for _, f := range files {
+		names = append(names, name+strings.TrimSuffix(filepath.Base(f), ".json"))
+	}
+	return names
+}
+
+// CheckEncryption returns an error if the saved state of name can't be
+// decrypted, so a wrong passphrase is caught before anything is loaded
+func CheckEncryption(name string) error {
+	for _, name := range dirFiles(name) {
+		filename := fmt.Sprintf("%s.json", filepath.Join(baseFilePath, name))
+		if _, err := readState(filename, encryptionFor(name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("%v: %v", filename, err)
+		}
+	}
+	return nil
+}
+
+// MigrateEncryption rewrites the saved state of name, its older versions
+// and its journals encrypted with the Encryption set by EncryptFiles.
+// Files that are not encrypted, or are encrypted with a recovered key, are
+// converted in place.  No Saver or Journal may have name open.
+func MigrateEncryption(name string) error {
+	if strings.HasSuffix(name, "/") {
+		for _, name := range dirFiles(name) {
+			if err := MigrateEncryption(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	e := encryptionFor(name)
+	if e == nil {
+		return errNotEncrypted
+	}
+
+	filename := fmt.Sprintf("%s.json", filepath.Join(baseFilePath, name))
+	for i := 0; i <= SaverVersions; i++ {
+		if err := migrateStateFile(versionName(filename, i), e); err != nil {
+			return err
+		}
+	}
+	journal := filepath.Join(baseFilePath, name) + ".journal"
+	for _, f := range []string{journal, journal + ".1"} {
+		if err := migrateJournalFile(f, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migrateStateFile(filename string, e *Encryption) error {
+	state, err := readState(filename, e)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%v: %v", filename, err)
+	}
+
+	values := make(map[string]*string)
+	for k, v := range state {
+		v := v
+		values[k] = &v
+	}
+	b, err := encodeState(values, e)
+	if err != nil {
+		return err
+	}
+	if err := replaceFile(filename, b, 0); err != nil {
+		return err
+	}
+	log.Printf("statemanager: Encrypted %v", filename)
+	return nil
+}
+
+func migrateJournalFile(filename string, e *Encryption) error {
+	entries, err := readJournalFile(filename, e)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%v: %v", filename, err)
+	}
+
+	f, err := os.OpenFile(filename+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return err
+	}
+	err = writeJournalHeader(f, e)
+	if err == nil {
+		err = writeJournalEntries(f, entries, e)
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("statemanager: Encrypted %v", filename)
+	return os.Rename(filename+".tmp", filename)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// pbkdf2 derives a key of keyLen bytes from password, see RFC 8018
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	var dk []byte
+	u := make([]byte, hashLen)
+	for block := uint32(1); len(dk) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+// gcmSeal encrypts plaintext with key, prefixing the random nonce
+func gcmSeal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errDecrypt
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return plaintext, nil
+}
+
+// kek returns the key that wraps content keys for files with salt and
+// iterations, the caller holds e.mu
+func (e *Encryption) kek(salt []byte, iterations int) ([]byte, error) {
+	if !e.passphrase {
+		if salt != nil {
+			return nil, errDecrypt
+		}
+		return e.secret, nil
+	}
+	if salt == nil || iterations <= 0 {
+		return nil, errDecrypt
+	}
+
+	id := fmt.Sprintf("%x/%d", salt, iterations)
+	if key, ok := e.derived[id]; ok {
+		return key, nil
+	}
+	key := pbkdf2(e.secret, salt, iterations, 32)
+	e.derived[id] = key
+	return key, nil
+}
+
+// keys returns the content key new files are encrypted with, and the
+// header holding it wrapped
+func (e *Encryption) keys() ([]byte, *encryptedFile, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.header != nil {
+		return e.contentKey, e.header, nil
+	}
+	if e.contentKey == nil {
+		key, err := randomBytes(32)
+		if err != nil {
+			return nil, nil, err
+		}
+		e.contentKey = key
+	}
+
+	h := &encryptedFile{Encrypted: encryptionFormat}
+	if e.passphrase {
+		h.Salt, h.Iterations = e.salt, PassphraseIterations
+	}
+	kek, err := e.kek(h.Salt, h.Iterations)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.Key, err = gcmSeal(kek, e.contentKey); err != nil {
+		return nil, nil, err
+	}
+	if e.recovery != nil {
+		if h.RecoveryKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, e.recovery, e.contentKey, recoveryLabel); err != nil {
+			return nil, nil, err
+		}
+	}
+	e.header = h
+	return e.contentKey, h, nil
+}
+
+// openKey unwraps the content key in the header h, with the recovery
+// key if the passphrase or key file can't
+func (e *Encryption) openKey(h *encryptedFile) ([]byte, error) {
+	if h.Encrypted != encryptionFormat {
+		return nil, errDecrypt
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	kek, err := e.kek(h.Salt, h.Iterations)
+	if err == nil {
+		var key []byte
+		if key, err = gcmOpen(kek, h.Key); err == nil {
+			return key, nil
+		}
+	}
+	if e.recoveryPrivate != nil && h.RecoveryKey != nil {
+		if key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, e.recoveryPrivate, h.RecoveryKey, recoveryLabel); err == nil {
+			return key, nil
+		}
+	}
+	return nil, errDecrypt
+}
+
+// seal returns the envelope of plaintext
+func (e *Encryption) seal(plaintext []byte) ([]byte, error) {
+	key, h, err := e.keys()
+	if err != nil {
+		return nil, err
+	}
+	f := *h
+	if f.Ciphertext, err = gcmSeal(key, plaintext); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(f, "", "\t")
+}
+
+// decrypt returns the plaintext of b if it is an envelope written by seal,
+// or b unchanged if it isn't encrypted
+func decrypt(b []byte, e *Encryption) ([]byte, error) {
+	var f encryptedFile
+	if json.Unmarshal(b, &f) != nil || f.Encrypted == "" {
+		return b, nil
+	}
+	if e == nil {
+		return nil, errEncrypted
+	}
+	key, err := e.openKey(&f)
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(key, f.Ciphertext)
+}
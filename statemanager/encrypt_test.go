@@ -0,0 +1,111 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetBaseFilePath(baseFilePath)
+	SetBaseFilePath(dir)
+	defer func(n int) { PassphraseIterations = n }(PassphraseIterations)
+	PassphraseIterations = 10
+	defer EncryptFiles(nil, "leagues", "games/")
+
+	secret := "Jane Doe"
+	if err := writeState("leagues", map[string]*string{"Leagues.Person(p).LegalName": &secret}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plain files are encrypted in place, with a recovery key
+	e, _ := NewPassphraseEncryption("correct horse")
+	if err := CreateRecoveryKey(filepath.Join(dir, "recovery.pub"), filepath.Join(dir, "recovery.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "recovery.pem")); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("Recovery key is readable by others: %v", fi.Mode())
+	}
+	if err := CreateRecoveryKey(filepath.Join(dir, "recovery.pub"), filepath.Join(dir, "recovery.pem")); err == nil {
+		t.Errorf("Recovery key was overwritten")
+	}
+	if err := e.SetRecoveryKey(filepath.Join(dir, "recovery.pub")); err != nil {
+		t.Fatal(err)
+	}
+	EncryptFiles(e, "leagues")
+	if err := MigrateEncryption("leagues"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"leagues.json", "leagues.json.1"} {
+		if b, _ := ioutil.ReadFile(filepath.Join(dir, f)); bytes.Contains(b, []byte(secret)) {
+			t.Errorf("%v is not encrypted", f)
+		}
+	}
+	if s := loadState("leagues"); s["Leagues.Person(p).LegalName"] != secret {
+		t.Errorf("loadState: got %v", s)
+	}
+
+	// Journal entries are encrypted too
+	sm := New()
+	sm.RegisterPatternUpdaterString("Leagues", 0, sm.StateUpdateString)
+	j := sm.NewJournal("leagues", "Leagues", 0)
+	sm.Command("Set", []string{"Leagues.Person(p).LegalName", "John Doe"})
+	j.Close()
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "leagues.journal")); bytes.Contains(b, []byte("John Doe")) {
+		t.Errorf("Journal is not encrypted")
+	}
+	entries, err := ReadJournal("leagues")
+	if v := replayJournal(entries)["Leagues.Person(p).LegalName"]; err != nil || v == nil || *v != "John Doe" {
+		t.Errorf("ReadJournal: got %v, %v", entries, err)
+	}
+
+	// Files saved in a directory set up for encryption are encrypted
+	EncryptFiles(e, "games/")
+	sm.Lock()
+	err = sm.SaveState("games/game1", "Leagues")
+	sm.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "games", "game1.json")); bytes.Contains(b, []byte("John Doe")) {
+		t.Errorf("Saved game is not encrypted")
+	}
+	if s, err := ReadState("games/game1"); err != nil || s["Leagues.Person(p).LegalName"] != "John Doe" {
+		t.Errorf("ReadState: got %v, %v", s, err)
+	}
+	if err := CheckEncryption("games/"); err != nil {
+		t.Errorf("CheckEncryption: %v", err)
+	}
+
+	// A wrong passphrase is caught, the recovery key still decrypts
+	wrong, _ := NewPassphraseEncryption("wrong")
+	EncryptFiles(wrong, "leagues")
+	if err := CheckEncryption("leagues"); err == nil {
+		t.Errorf("Wrong passphrase decrypted the file")
+	}
+	EncryptFiles(nil, "leagues")
+	if err := CheckEncryption("leagues"); err == nil {
+		t.Errorf("Encrypted file loaded without a key")
+	}
+	if err := wrong.Recover(filepath.Join(dir, "recovery.pem")); err != nil {
+		t.Fatal(err)
+	}
+	EncryptFiles(wrong, "leagues")
+	if err := CheckEncryption("leagues"); err != nil {
+		t.Errorf("Recovery: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import "strings"
+
+// patternIndex finds the values registered with patterns matching a key
+// without testing every pattern.  Patterns are stored in a trie of their
+// dotted segments, with (*) segments indexed by the name before the paren.
+// Patterns the trie can't represent are matched one by one.
+type patternIndex struct {
+	root  *indexNode
+	other []indexEntry
+}
+
+type indexNode struct {
+	exact    map[string]*indexNode
+	wildcard map[string]*indexNode
+	values   []interface{} // match keys at or below this node
+	deeper   []interface{} // match keys below this node (patterns ending in .*)
+}
+
+type indexEntry struct {
+	pm    patternMatcher
+	value interface{}
+}
+
+func newIndexNode() *indexNode {
+	return &indexNode{exact: make(map[string]*indexNode), wildcard: make(map[string]*indexNode)}
+}
+
+func newPatternIndex() *patternIndex {
+	return &patternIndex{root: newIndexNode()}
+}
+
+// splitKey splits k on the dots outside of parens, so IDs such as file
+// names may contain dots
+func splitKey(k string) []string {
+	if k == "" {
+		return nil
+	}
+	var segs []string
+	depth, start := 0, 0
+	for i := 0; i < len(k); i++ {
+		switch k[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case '.':
+			if depth == 0 {
+				segs = append(segs, k[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(segs, k[start:])
+}
+
+// wildcardName returns the name before (*) if seg is a wildcard pattern segment
+func wildcardName(seg string) (string, bool) {
+	if !strings.HasSuffix(seg, "(*)") {
+		return "", false
+	}
+	name := seg[:len(seg)-3]
+	return name, !strings.ContainsAny(name, "()*")
+}
+
+// idName returns the name before the ID if seg is a key segment with an ID
+func idName(seg string) (string, bool) {
+	lparen := strings.IndexByte(seg, '(')
+	if lparen == -1 || !strings.HasSuffix(seg, ")") || strings.IndexByte(seg[lparen:], ')') != len(seg)-lparen-1 {
+		return "", false
+	}
+	return seg[:lparen], true
+}
+
+// path returns the node for pattern, creating it if create is set, and if
+// the pattern ends in .*.  ok is false if the trie can't represent pattern.
+func (pi *patternIndex) path(pattern string, create bool) (n *indexNode, deeper, ok bool) {
+	segs := splitKey(pattern)
+	if len(segs) > 1 && segs[len(segs)-1] == "*" {
+		segs = segs[:len(segs)-1]
+		deeper = true
+	}
+
+	n = pi.root
+	for _, seg := range segs {
+		children := n.exact
+		if name, wild := wildcardName(seg); wild {
+			children, seg = n.wildcard, name
+		} else if seg == "" || strings.Contains(seg, "*") {
+			return nil, false, false
+		}
+		next, found := children[seg]
+		if !found {
+			if !create {
+				return nil, deeper, true
+			}
+			next = newIndexNode()
+			children[seg] = next
+		}
+		n = next
+	}
+	return n, deeper, true
+}
+
+// add registers value for pattern (see PatternMatch for examples of matching)
+func (pi *patternIndex) add(pattern string, value interface{}) {
+	n, deeper, ok := pi.path(pattern, true)
+	switch {
+	case !ok:
+		pi.other = append(pi.other, indexEntry{pm: newPatternMatcher(pattern), value: value})
+	case deeper:
+		n.deeper = append(n.deeper, value)
+	default:
+		n.values = append(n.values, value)
+	}
+}
+
+// remove unregisters value for pattern
+func (pi *patternIndex) remove(pattern string, value interface{}) {
+	n, deeper, ok := pi.path(pattern, false)
+	switch {
+	case !ok:
+		for i, e := range pi.other {
+			if e.value == value && e.pm.Pattern() == pattern {
+				pi.other = append(pi.other[:i], pi.other[i+1:]...)
+				return
+			}
+		}
+	case n == nil:
+	case deeper:
+		n.deeper = removeValue(n.deeper, value)
+	default:
+		n.values = removeValue(n.values, value)
+	}
+}
+
+func removeValue(values []interface{}, value interface{}) []interface{} {
+	for i, v := range values {
+		if v == value {
+			return append(values[:i], values[i+1:]...)
+		}
+	}
+	return values
+}
+
+// lookup returns the values registered with patterns matching key, those
+// with longer patterns later in the result
+func (pi *patternIndex) lookup(key string) []interface{} {
+	var ret []interface{}
+	for _, e := range pi.other {
+		if e.pm.Matches(key) {
+			ret = append(ret, e.value)
+		}
+	}
+
+	segs := splitKey(key)
+	nodes := []*indexNode{pi.root}
+	for depth := 0; len(nodes) > 0; depth++ {
+		var next []*indexNode
+		for _, n := range nodes {
+			ret = append(ret, n.values...)
+			if depth == len(segs) {
+				continue
+			}
+			ret = append(ret, n.deeper...)
+
+			seg := segs[depth]
+			if c, ok := n.exact[seg]; ok {
+				next = append(next, c)
+			}
+			if name, ok := idName(seg); ok {
+				if c, ok := n.wildcard[name]; ok {
+					next = append(next, c)
+				}
+			}
+		}
+		nodes = next
+	}
+	return ret
+}
@@ -0,0 +1,371 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// JournalEntry is one line of a Journal.  An entry holds either the states
+// changed (nil for deleted) in stateNum, a snapshot of all states written
+// when the journal is compacted, or a command and the error it returned.
+type JournalEntry struct {
+	Time     time.Time          `json:"time"`
+	StateNum uint64             `json:"stateNum"`
+	Snapshot bool               `json:"snapshot,omitempty"`
+	State    map[string]*string `json:"state,omitempty"`
+	Command  string             `json:"command,omitempty"`
+	Data     []string           `json:"data,omitempty"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// Journal records every change to part of the state, and every command
+// for it, to an append-only file for crash recovery and auditing.  Entries
+// are written and synced before the statemanager lock is released, so no
+// listener sees a change that isn't in the journal.
+type Journal struct {
+	sm       *StateManager
+	name     string
+	base     string
+	pm       patternMatcher
+	interval time.Duration
+	file     *os.File
+	enc      *Encryption
+	quit     chan bool
+	closed   chan bool
+}
+
+// NewJournal opens the journal name (relative to BaseFilePath, with .journal
+// appended) for the states matching base (see PatternMatch for examples of
+// matching).  Any entries already in the journal are replayed on top of the
+// current state, which is normally loaded by a Saver first, then the journal
+// is compacted.  Compaction repeats every compactInterval (zero to disable),
+// the previous journal is kept with .1 appended.
+func (sm *StateManager) NewJournal(name, base string, compactInterval time.Duration) *Journal {
+	log.Printf("Journal(%v): Opening", name)
+
+	j := &Journal{
+		sm:       sm,
+		name:     name,
+		base:     base,
+		pm:       newPatternMatcher(base),
+		interval: compactInterval,
+		quit:     make(chan bool),
+		closed:   make(chan bool),
+		enc:      encryptionFor(name),
+	}
+
+	entries, err := ReadJournal(name)
+	if os.IsNotExist(err) {
+		// Compaction may have been interrupted before the new journal was in place
+		entries, err = readJournalFile(j.filename()+".1", j.enc)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Journal(%v): Cannot read all entries: %v", name, err)
+	}
+	if state := replayJournal(entries); len(state) > 0 {
+		log.Printf("Journal(%v): Replaying %v entries", name, len(entries))
+		sm.Lock()
+		sm.replay(state)
+		sm.Unlock()
+	}
+
+	sm.Lock()
+	sm.journals = append(sm.journals, j)
+	j.compact()
+	sm.Unlock()
+
+	go j.compactLoop()
+	return j
+}
+
+// NewJournal opens a journal on the Default StateManager, see StateManager.NewJournal
+func NewJournal(name, base string, compactInterval time.Duration) *Journal {
+	return defaultStateManager.NewJournal(name, base, compactInterval)
+}
+
+// ReadJournal returns the entries in the journal name.  If the journal ends
+// with a partial entry (as left by a crash) the entries before it are
+// returned along with the error.  Journals set up by EncryptFiles are
+// decrypted.
+func ReadJournal(name string) ([]JournalEntry, error) {
+	return readJournalFile(filepath.Join(baseFilePath, name)+".journal", encryptionFor(name))
+}
+
+// readJournalFile reads the entries in filename.  An encrypted journal
+// starts with a header holding the content key (see encryptedFile), which
+// is opened with enc.
+func readJournalFile(filename string, enc *Encryption) ([]JournalEntry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	var key []byte
+	dec := json.NewDecoder(f)
+	for {
+		var line json.RawMessage
+		if err := dec.Decode(&line); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+
+		var sealed encryptedFile
+		json.Unmarshal(line, &sealed)
+		if sealed.Encrypted != "" {
+			if enc == nil {
+				return entries, errEncrypted
+			}
+			if key, err = enc.openKey(&sealed); err != nil {
+				return entries, err
+			}
+			continue
+		}
+		if sealed.Ciphertext != nil {
+			if key == nil {
+				return entries, errDecrypt
+			}
+			if line, err = gcmOpen(key, sealed.Ciphertext); err != nil {
+				return entries, err
+			}
+		}
+
+		var e JournalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+// replayJournal returns the states changed after applying entries in
+// order, nil for deleted states, starting over at each snapshot
+func replayJournal(entries []JournalEntry) map[string]*string {
+	state := make(map[string]*string)
+	for _, e := range entries {
+		if e.Snapshot {
+			state = make(map[string]*string)
+		}
+		for k, v := range e.State {
+			state[k] = v
+		}
+	}
+	return state
+}
+
+// replay applies the states from replayJournal.  Deleted states are
+// removed (see RegisterRemove) before the rest are set.  Like loading a
+// Saver's file, states that can't be set are logged and skipped.  The
+// statemanager lock must be held by the caller.
+func (sm *StateManager) replay(state map[string]*string) {
+	values := make(map[string]string)
+	var deleted []string
+	for k, v := range state {
+		if v != nil {
+			values[k] = *v
+		} else if s, ok := sm.states[k]; ok && !s.isEmpty {
+			deleted = append(deleted, k)
+		}
+	}
+
+	sort.Strings(deleted)
+	for _, k := range deleted {
+		s := sm.states[k]
+		sm.changed(s)
+		s.isEmpty = true
+	}
+	for _, k := range deleted {
+		sm.removed(k)
+	}
+	sm.stateSetGroup(values, false)
+}
+
+// Close stops recording to the journal, writing out any changes not yet flushed
+func (j *Journal) Close() {
+	log.Printf("Journal(%v): Closing", j.name)
+	close(j.quit)
+	<-j.closed
+
+	j.sm.Lock()
+	defer j.sm.Unlock()
+	if j.sm.stateUpdated {
+		j.flush()
+	}
+	for i, j2 := range j.sm.journals {
+		if j == j2 {
+			j.sm.journals = append(j.sm.journals[:i], j.sm.journals[i+1:]...)
+			break
+		}
+	}
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+}
+
+func (j *Journal) filename() string {
+	return filepath.Join(baseFilePath, j.name) + ".journal"
+}
+
+// add writes e to the journal and syncs it.  The statemanager lock must be
+// held by the caller.
+func (j *Journal) add(e JournalEntry) {
+	if err := writeJournalEntries(j.file, []JournalEntry{e}, j.enc); err != nil {
+		log.Printf("Journal(%v): Cannot write entries: %v", j.name, err)
+	}
+}
+
+// flush writes the states changed in the current stateNum.  The statemanager
+// lock must be held by the caller.
+func (j *Journal) flush() {
+	var u map[string]*string
+	for _, s := range j.sm.dirty {
+		if !j.pm.Matches(s.name) {
+			continue
+		}
+		if u == nil {
+			u = make(map[string]*string)
+		}
+		if v, e := s.Value(); !e {
+			u[s.name] = &v
+		} else {
+			u[s.name] = nil
+		}
+	}
+	if u != nil {
+		j.add(JournalEntry{Time: time.Now(), StateNum: j.sm.stateNum, State: u})
+	}
+}
+
+// command records the command name if it, or the key it sets, matches the
+// journal's base.  The statemanager lock must be held by the caller.
+func (j *Journal) command(name string, data []string, err error) {
+	if !j.pm.Matches(name) && !(name == "Set" && len(data) > 0 && j.pm.Matches(data[0])) {
+		return
+	}
+	e := JournalEntry{Time: time.Now(), StateNum: j.sm.stateNum, Command: name, Data: data}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	j.add(e)
+}
+
+func (j *Journal) compactLoop() {
+	if j.interval <= 0 {
+		<-j.quit
+		close(j.closed)
+		return
+	}
+
+	t := time.NewTicker(j.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			j.sm.Lock()
+			j.compact()
+			j.sm.Unlock()
+		case <-j.quit:
+			close(j.closed)
+			return
+		}
+	}
+}
+
+// writeJournalHeader starts a journal encrypted with enc, if it isn't nil
+func writeJournalHeader(f *os.File, enc *Encryption) error {
+	if enc == nil {
+		return nil
+	}
+	_, h, err := enc.keys()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(f).Encode(h)
+}
+
+// writeJournalEntries appends entries to f, each one encrypted with enc if
+// it isn't nil
+func writeJournalEntries(f *os.File, entries []JournalEntry, enc *Encryption) error {
+	if f == nil || len(entries) == 0 {
+		return nil
+	}
+	var key []byte
+	if enc != nil {
+		var err error
+		if key, _, err = enc.keys(); err != nil {
+			return err
+		}
+	}
+	w := json.NewEncoder(f)
+	for _, e := range entries {
+		if key == nil {
+			if err := w.Encode(e); err != nil {
+				return err
+			}
+			continue
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		sealed := encryptedFile{}
+		if sealed.Ciphertext, err = gcmSeal(key, b); err != nil {
+			return err
+		}
+		if err := w.Encode(sealed); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+// compact starts a new journal with a snapshot of the current state, the
+// previous journal is kept with .1 appended.  The statemanager lock must be
+// held by the caller, so no entries are written while the files change.
+func (j *Journal) compact() {
+	snapshot := JournalEntry{Time: time.Now(), StateNum: j.sm.stateNum, Snapshot: true, State: make(map[string]*string)}
+	for k, v := range j.sm.StateQuery(j.base) {
+		v := v
+		snapshot.State[k] = &v
+	}
+
+	filename := j.filename()
+	os.MkdirAll(filepath.Dir(filename), 0775)
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err == nil {
+		err = writeJournalHeader(f, j.enc)
+		if err == nil {
+			err = writeJournalEntries(f, []JournalEntry{snapshot}, j.enc)
+		}
+		f.Close()
+	}
+	if err == nil {
+		os.Rename(filename, filename+".1")
+		err = os.Rename(tmp, filename)
+	}
+	if err == nil {
+		j.file, err = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0664)
+	}
+	if err != nil {
+		log.Printf("Journal(%v): Cannot compact: %v", j.name, err)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestJournalReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetBaseFilePath(baseFilePath)
+	SetBaseFilePath(dir)
+
+	register := func(sm *StateManager) {
+		sm.RegisterPatternUpdaterString("Scoreboard", 0, sm.StateUpdateString)
+		sm.RegisterCommand("Scoreboard.Clear", func([]string) error { return sm.StateDelete("Scoreboard.Team(1).Name") })
+	}
+
+	sm := New()
+	register(sm)
+	j := sm.NewJournal("scoreboard", "Scoreboard", 0)
+	sm.Command("Set", []string{"Scoreboard.Team(1).Name", "Home"})
+	sm.Command("Set", []string{"Scoreboard.Team(2).Name", "Away"})
+	sm.Command("Scoreboard.Clear", nil)
+	sm.Command("Set", []string{"Scoreboard.Team(2).Name", "Visitors"})
+
+	// Entries are on disk as soon as the lock is released
+	entries, err := ReadJournal("scoreboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := 0
+	for _, e := range entries {
+		if e.Command != "" {
+			commands++
+		}
+	}
+	if commands != 4 {
+		t.Errorf("Expected 4 commands in journal, got %v", commands)
+	}
+	j.Close()
+
+	// The Saver's file may still hold states deleted since it was written
+	sm2 := New()
+	register(sm2)
+	sm2.Lock()
+	sm2.StateSet("Scoreboard.Team(1).Name", "Home")
+	sm2.Unlock()
+	j2 := sm2.NewJournal("scoreboard", "Scoreboard", 0)
+	defer j2.Close()
+	sm2.Lock()
+	q := sm2.StateQuery("Scoreboard")
+	sm2.Unlock()
+	if len(q) != 1 || q["Scoreboard.Team(2).Name"] != "Visitors" {
+		t.Errorf("Replayed state: got %v", q)
+	}
+}
@@ -9,87 +9,234 @@ import (
 	"fmt"
 	"log"
 	"sort"
+	"sync"
+	"time"
 )
 
+// ListenerMaxLag is how long an update may wait for a listener before the
+// listener is disconnected for falling too far behind
+var ListenerMaxLag = 30 * time.Second
+
 // Listener allows functions to listen for changes in the state of the scoreboard
 type Listener struct {
+	sm       *StateManager
 	name     string
 	callback func(map[string]*string)
 	stateNum uint64
-	ch       chan map[string]*string
-	matchers []patternMatcher
 	paths    []string
+
+	// updates waiting for the callback, coalesced so the latest value per key wins
+	mu           sync.Mutex
+	pending      map[string]*string
+	pendingSince time.Time
+	pendingNum   uint64
+	signal       chan bool
+	closed       bool
+	dropped      bool
+	delivered    uint64
+	coalesced    uint64
+	lastLag      time.Duration
 }
 
-var listeners []*Listener
+// ListenerStats describes how far behind a listener is
+type ListenerStats struct {
+	Name      string        `json:"name"`
+	Pending   int           `json:"pending"`
+	Lag       time.Duration `json:"lag"`
+	LastLag   time.Duration `json:"lastLag"`
+	Delivered uint64        `json:"delivered"`
+	Coalesced uint64        `json:"coalesced"`
+}
 
 // NewListener creates a listener with name describing the listener (for log messages)
 // and cb is a callback function which gets called on changes to the state filtered
-// by Listener.RegisterPaths
-func NewListener(name string, cb func(map[string]*string)) *Listener {
-	lock.Lock()
-	defer lock.Unlock()
+// by Listener.RegisterPaths.  Updates are never blocked by a slow callback, they are
+// combined until the callback is ready.  If an update waits longer than ListenerMaxLag
+// the listener is disconnected and cb is called a final time with nil.
+func (sm *StateManager) NewListener(name string, cb func(map[string]*string)) *Listener {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
 
 	l := &Listener{
+		sm:       sm,
 		name:     name,
 		stateNum: 0,
 		callback: cb,
-		ch:       make(chan map[string]*string, 10),
-		matchers: nil,
+		signal:   make(chan bool, 1),
 		paths:    nil,
 	}
 
-	listeners = append(listeners, l)
+	sm.listeners = append(sm.listeners, l)
 	go l.processUpdates()
 	return l
 }
 
+// NewListener creates a listener on the Default StateManager, see StateManager.NewListener
+func NewListener(name string, cb func(map[string]*string)) *Listener {
+	return defaultStateManager.NewListener(name, cb)
+}
+
 // Close closes the listener.  After this call it the callback will never be called for
 // this listener again.
 func (l *Listener) Close() {
-	lock.Lock()
-	defer lock.Unlock()
+	l.sm.lock.Lock()
+	defer l.sm.lock.Unlock()
+
+	l.sm.removeListener(l)
+	l.mu.Lock()
+	l.closed = true
+	l.pending = nil
+	l.mu.Unlock()
+	l.wake()
+}
+
+func (sm *StateManager) removeListener(l *Listener) {
+	for _, p := range l.paths {
+		sm.listenerIndex.remove(p, l)
+	}
 
-	lLen := len(listeners)
-	for i, l2 := range listeners {
+	lLen := len(sm.listeners)
+	for i, l2 := range sm.listeners {
 		if l == l2 {
-			listeners[i], listeners[lLen-1] = listeners[lLen-1], nil
-			listeners = listeners[:lLen-1]
+			sm.listeners[i], sm.listeners[lLen-1] = sm.listeners[lLen-1], nil
+			sm.listeners = sm.listeners[:lLen-1]
 			return
 		}
 	}
 }
 
+func (l *Listener) wake() {
+	select {
+	case l.signal <- true:
+	default:
+	}
+}
+
+// deliver adds updates to those waiting for the callback, it never blocks.
+// Returns false if the listener has fallen too far behind.
+func (l *Listener) deliver(updates map[string]*string, stateNum uint64) bool {
+	l.mu.Lock()
+	defer l.wake()
+	defer l.mu.Unlock()
+
+	// flushPaths delivers with an older stateNum than updates already pending
+	if stateNum > l.pendingNum {
+		l.pendingNum = stateNum
+	}
+	now := time.Now()
+	if l.pending == nil {
+		l.pending = updates
+		l.pendingSince = now
+	} else {
+		for k, v := range updates {
+			if _, ok := l.pending[k]; ok {
+				l.coalesced++
+			}
+			l.pending[k] = v
+		}
+	}
+	return now.Sub(l.pendingSince) <= ListenerMaxLag
+}
+
+// drop disconnects the listener for falling too far behind.  The statemanager
+// lock must be held by the caller.
+func (l *Listener) drop() {
+	log.Printf("Listener(%v): Disconnecting, updates have waited more than %v", l.name, ListenerMaxLag)
+	l.sm.removeListener(l)
+	l.mu.Lock()
+	l.dropped = true
+	l.pending = nil
+	l.mu.Unlock()
+	l.wake()
+}
+
 func (l *Listener) processUpdates() {
-	for {
-		updates := <-l.ch
+	for range l.signal {
+		l.mu.Lock()
+		updates := l.pending
+		l.pending = nil
+		if updates != nil {
+			l.lastLag = time.Since(l.pendingSince)
+			l.stateNum = l.pendingNum
+			l.delivered++
+		}
+		closed, dropped := l.closed, l.dropped
+		l.mu.Unlock()
+
+		if closed {
+			return
+		}
+		if dropped {
+			l.callback(nil)
+			return
+		}
+		if updates == nil {
+			continue
+		}
+
 		if debugFlag {
 			var values []string
+			l.sm.lock.Lock()
 			for k, v := range updates {
 				if v != nil {
-					values = append(values, fmt.Sprintf("%v=\"%v\"", k, *v))
+					values = append(values, fmt.Sprintf("%v=\"%v\"", k, l.sm.Redact(k, *v)))
 				} else {
 					values = append(values, fmt.Sprintf("%v=nil", k))
 				}
 			}
+			l.sm.lock.Unlock()
 			sort.StringSlice(values).Sort()
 			log.Printf("Processing %v updates for %v  %+v", len(updates), l.name, values)
 		}
 
 		l.callback(updates)
-		if updates == nil {
-			return
-		}
 	}
 }
 
-func (l *Listener) findPatternMatcher(path string) (int, patternMatcher) {
-	for idx, pm := range l.matchers {
-		if pm.Pattern() == path {
-			return idx, pm
+// StateNum returns the stateNum of the updates last passed to the callback,
+// all values the callback has been given are at least that new
+func (l *Listener) StateNum() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stateNum
+}
+
+func (l *Listener) stats() ListenerStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ls := ListenerStats{
+		Name:      l.name,
+		Pending:   len(l.pending),
+		LastLag:   l.lastLag,
+		Delivered: l.delivered,
+		Coalesced: l.coalesced,
+	}
+	if l.pending != nil {
+		ls.Lag = time.Since(l.pendingSince)
+	}
+	return ls
+}
+
+// ListenerStats returns how far behind each listener is
+func (sm *StateManager) ListenerStats() []ListenerStats {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	var ret []ListenerStats
+	for _, l := range sm.listeners {
+		ret = append(ret, l.stats())
+	}
+	return ret
+}
+
+func (l *Listener) findPath(path string) int {
+	for idx, p := range l.paths {
+		if p == path {
+			return idx
 		}
 	}
-	return -1, nil
+	return -1
 }
 
 // RegisterPaths adds the paths to the listener to get updates.  See PatternMatch for examples
@@ -100,19 +247,26 @@ func (l *Listener) RegisterPaths(paths []string) {
 		return
 	}
 
-	lock.Lock()
-	defer lock.Unlock()
+	l.sm.lock.Lock()
+	defer l.sm.lock.Unlock()
 	for _, p := range paths {
 		if debugFlag {
 			log.Printf("RegisterListenerPaths: %v", p)
 		}
-		idx, _ := l.findPatternMatcher(p)
-		if idx == -1 {
+		if l.findPath(p) == -1 {
 			l.paths = append(l.paths, p)
-			l.matchers = append(l.matchers, newPatternMatcher(p))
+			l.sm.listenerIndex.add(p, l)
 		}
 	}
-	l.flush(paths)
+	l.flushPaths(paths)
+}
+
+// Resend passes the current state of every registered path to the callback
+// again, for when the callback changes which of them it sends on
+func (l *Listener) Resend() {
+	l.sm.lock.Lock()
+	defer l.sm.lock.Unlock()
+	l.flushPaths(l.paths)
 }
 
 // UnregisterPaths removes the paths from the listener.
@@ -121,77 +275,122 @@ func (l *Listener) UnregisterPaths(paths []string) {
 		return
 	}
 
-	lock.Lock()
-	defer lock.Unlock()
+	l.sm.lock.Lock()
+	defer l.sm.lock.Unlock()
 
 	for _, p := range paths {
 		if debugFlag {
 			log.Printf("UnregisterListenerPaths: %v", p)
 		}
-		idx, _ := l.findPatternMatcher(p)
-		if idx != -1 {
-			// TODO: DELETE ENTRY FROM SLICE!
-			l.paths[idx] = ""
-			l.matchers[idx] = nil
+		if idx := l.findPath(p); idx != -1 {
+			l.sm.listenerIndex.remove(p, l)
+			l.paths = append(l.paths[:idx], l.paths[idx+1:]...)
 		}
 	}
 }
 
-func (l *Listener) flush(paths []string) {
-	var u map[string]*string
-	if l.stateNum < stateNum || paths != nil {
-		for _, s := range states {
-			needed := l.stateNum < s.stateNum
-			matched := false
-
-			for idx, pm := range l.matchers {
-				p := l.paths[idx]
-				if pm.Matches(s.name) {
-					matched = true
-
-					// Matched, now look for just registered
-					for _, p2 := range paths {
-						if p == p2 {
-							needed = true
-							break
-						}
-					}
-					break
-				}
-			}
+// flushPaths sends the listener the current value of all states matching paths
+func (l *Listener) flushPaths(paths []string) {
+	var pms []patternMatcher
+	for _, p := range paths {
+		pms = append(pms, newPatternMatcher(p))
+	}
 
-			if matched && needed {
+	var u map[string]*string
+	for _, s := range l.sm.states {
+		if s.isEmpty {
+			continue
+		}
+		for _, pm := range pms {
+			if pm.Matches(s.name) {
 				if u == nil {
 					u = make(map[string]*string)
 				}
-				if v, e := s.Value(); !e {
-					u[s.name] = &v
-				} else {
-					u[s.name] = nil
-				}
-			}
-		}
-		if u != nil {
-			if paths == nil {
-				l.stateNum = stateNum
+				v, _ := s.Value()
+				u[s.name] = &v
+				break
 			}
-			l.ch <- u
 		}
 	}
+	if u != nil {
+		// Changes may have been made since the last flush, so claim the values
+		// are only as new as that
+		l.deliver(u, l.sm.stateNum-1)
+	}
 }
 
-func flushListeners() {
-	lock.Lock()
-	defer lock.Unlock()
+func (sm *StateManager) flushListeners() {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
 
 	for {
-		cond.Wait()
-		if stateUpdated {
-			for _, l := range listeners {
-				l.flush(nil)
+		sm.cond.Wait()
+		sm.flush()
+	}
+}
+
+// flush sends the changes in the current stateNum to the journals and
+// listeners, collects the deleted states and starts the next stateNum
+func (sm *StateManager) flush() {
+	for sm.stateUpdated {
+		sm.recompute()
+		for _, j := range sm.journals {
+			j.flush()
+		}
+		sm.flushChanged()
+		collected := sm.collectTombstones()
+		sm.dirty = make(map[string]*state)
+		sm.stateNum = sm.stateNum + 1
+		sm.stateUpdated = false
+
+		// Updating the stats starts another round, which won't collect anything
+		if collected > 0 {
+			sm.tombstonesCollected += collected
+			sm.StateUpdateInt64("StateManager.Tombstones.Collected", sm.tombstonesCollected)
+			sm.StateUpdateInt64("StateManager.States", int64(len(sm.states)))
+		}
+	}
+}
+
+// collectTombstones removes the states deleted in the current stateNum from
+// the state tree, every listener has been sent the deletion by now
+func (sm *StateManager) collectTombstones() int64 {
+	var collected int64
+	for k, s := range sm.dirty {
+		if s.isEmpty && sm.states[k] == s {
+			delete(sm.states, k)
+			collected++
+		}
+	}
+	return collected
+}
+
+// flushChanged sends each listener the states in the dirty set (those changed
+// in the current stateNum) matching its paths
+func (sm *StateManager) flushChanged() {
+	updates := make(map[*Listener]map[string]*string)
+	for _, s := range sm.dirty {
+		matches := sm.listenerIndex.lookup(s.name)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var value *string
+		if v, e := s.Value(); !e {
+			value = &v
+		}
+		for _, m := range matches {
+			l := m.(*Listener)
+			if updates[l] == nil {
+				updates[l] = make(map[string]*string)
 			}
-			stateNum = stateNum + 1
-			stateUpdated = false
+			updates[l][s.name] = value
+		}
+	}
+
+	for l, u := range updates {
+		if !l.deliver(u, sm.stateNum) {
+			l.drop()
 		}
 	}
 }
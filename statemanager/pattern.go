@@ -42,6 +42,12 @@ func newPatternMatcher(pattern string) patternMatcher {
 	return &complexMatcher{pattern: pattern}
 }
 
+// PatternMatch returns true if value matches pattern, see newPatternMatcher
+// for examples of matching
+func PatternMatch(pattern, value string) bool {
+	return newPatternMatcher(pattern).Matches(value)
+}
+
 func (bm *blankMatcher) Matches(string) bool { return true }
 func (bm *blankMatcher) Pattern() string     { return bm.pattern }
 
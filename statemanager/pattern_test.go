@@ -59,3 +59,42 @@ func BenchmarkComplexPatternMatcher(b *testing.B) {
 		pm.Matches("Scoreboard.Team(1).Color(operator).Name.Key(blue).Color")
 	}
 }
+
+func TestPatternIndex(t *testing.T) {
+	values := []string{
+		"Scoreboard.Team(1)",
+		"Scoreboard.Team(2).Name",
+		"Scoreboard.Team(1).Color(operator).Name.Key(blue).Color",
+		"Scoreboard.Team(1).Color(overlay)",
+		"Scoreboard.State",
+		"Media.Type(Image).File(/images/fullscreen/Flag.jpg)",
+	}
+	patterns := []string{"Media.Type(*).File(*)", "Media.Type(Image)", "Scoreboard.Team(*).Col*"}
+	for _, c := range cases {
+		values = append(values, c.value)
+		patterns = append(patterns, c.pattern)
+	}
+
+	pi := newPatternIndex()
+	for _, p := range patterns {
+		pi.add(p, p)
+	}
+	for _, v := range values {
+		found := make(map[string]bool)
+		for _, m := range pi.lookup(v) {
+			found[m.(string)] = true
+		}
+		for _, p := range patterns {
+			if expected := newPatternMatcher(p).Matches(v); found[p] != expected {
+				t.Errorf("lookup('%v') for pattern '%v' expected %v got %v", v, p, expected, found[p])
+			}
+		}
+	}
+
+	pi.remove("Media.Type(*).File(*)", "Media.Type(*).File(*)")
+	for _, m := range pi.lookup("Media.Type(Image).File(/images/fullscreen/Flag.jpg)") {
+		if m == "Media.Type(*).File(*)" {
+			t.Errorf("lookup found removed pattern")
+		}
+	}
+}
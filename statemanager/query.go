@@ -0,0 +1,189 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// DefaultHistoryLimit is the number of changes a StateManager keeps for
+// StateQueryAt unless changed by SetHistoryLimit
+const DefaultHistoryLimit = 10000
+
+// ErrHistoryUnavailable is returned when the state asked for is older than
+// the change history kept
+var ErrHistoryUnavailable = errors.New("State History Unavailable")
+
+// change records the value a state had before it was changed at stateNum
+type change struct {
+	stateNum uint64
+	name     string
+	value    string
+	isEmpty  bool
+}
+
+// history is a bounded log of changes to the state, oldest first.  floor
+// is the highest stateNum of any change dropped from the log.
+type history struct {
+	changes []change
+	limit   int
+	floor   uint64
+}
+
+func (sm *StateManager) recordChange(s *state) {
+	h := &sm.history
+	v, e := s.Value()
+	h.changes = append(h.changes, change{stateNum: sm.stateNum, name: s.name, value: v, isEmpty: e})
+
+	// Trim in batches so the log isn't copied on every change
+	if len(h.changes) > h.limit+h.limit/4 {
+		drop := len(h.changes) - h.limit
+		h.floor = h.changes[drop-1].stateNum
+		h.changes = append([]change(nil), h.changes[drop:]...)
+	}
+}
+
+// SetHistoryLimit sets the number of changes kept for StateQueryAt
+func (sm *StateManager) SetHistoryLimit(n int) {
+	sm.history.limit = n
+}
+
+// StateNum returns the number of the current state.  Changes made now will
+// be sent to listeners as part of this state.
+func (sm *StateManager) StateNum() uint64 {
+	return sm.stateNum
+}
+
+// StateGet returns the value of k as its native type (string, int64,
+// bool, time.Time, float64, time.Duration or json.RawMessage).  ErrNotFound is returned if k is not in the state.
+func (sm *StateManager) StateGet(k string) (interface{}, error) {
+	s, ok := sm.states[k]
+	if !ok || s.isEmpty {
+		return nil, ErrNotFound
+	}
+	switch s.t {
+	case "string":
+		return s.valueString, nil
+	case "int64":
+		return s.valueInt64, nil
+	case "bool":
+		return s.valueBool, nil
+	case "time":
+		return s.valueTime, nil
+	case "float64":
+		return s.valueFloat64, nil
+	case "duration":
+		return s.valueDuration, nil
+	case "json":
+		return json.RawMessage(s.valueJSON), nil
+	}
+	return nil, ErrUnknownType
+}
+
+// StateGetString returns the value of k formatted as it is sent to listeners
+func (sm *StateManager) StateGetString(k string) (string, error) {
+	s, ok := sm.states[k]
+	if !ok || s.isEmpty {
+		return "", ErrNotFound
+	}
+	v, _ := s.Value()
+	return v, nil
+}
+
+// StateGetInt64 returns the value of k as an int64.  States of other types
+// are parsed from their string value.
+func (sm *StateManager) StateGetInt64(k string) (int64, error) {
+	s, ok := sm.states[k]
+	if !ok || s.isEmpty {
+		return 0, ErrNotFound
+	}
+	if s.t == "int64" {
+		return s.valueInt64, nil
+	}
+	v, _ := s.Value()
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// StateQuery returns the values of all states matching pattern (see
+// PatternMatch for examples of matching)
+func (sm *StateManager) StateQuery(pattern string) map[string]string {
+	pm := newPatternMatcher(pattern)
+	ret := make(map[string]string)
+	for k, s := range sm.states {
+		if s.isEmpty || !pm.Matches(k) {
+			continue
+		}
+		ret[k], _ = s.Value()
+	}
+	return ret
+}
+
+// StateQueryAt returns the values of all states matching pattern as they
+// were once stateNum was sent to listeners.  ErrHistoryUnavailable is
+// returned if changes since stateNum have been dropped from the history.
+func (sm *StateManager) StateQueryAt(pattern string, stateNum uint64) (map[string]string, error) {
+	if stateNum < sm.history.floor {
+		return nil, ErrHistoryUnavailable
+	}
+
+	pm := newPatternMatcher(pattern)
+	values := make(map[string]*string)
+	for k, s := range sm.states {
+		if !pm.Matches(k) {
+			continue
+		}
+		if v, e := s.Value(); !e {
+			values[k] = &v
+		} else {
+			values[k] = nil
+		}
+	}
+
+	// Undo the changes made after stateNum, newest first
+	changes := sm.history.changes
+	for i := len(changes) - 1; i >= 0 && changes[i].stateNum > stateNum; i-- {
+		c := changes[i]
+		if !pm.Matches(c.name) {
+			continue
+		}
+		if c.isEmpty {
+			values[c.name] = nil
+		} else {
+			v := c.value
+			values[c.name] = &v
+		}
+	}
+
+	ret := make(map[string]string)
+	for k, v := range values {
+		if v != nil {
+			ret[k] = *v
+		}
+	}
+	return ret, nil
+}
+
+// StateNum returns the number of the current state of the Default StateManager, see StateManager.StateNum
+func StateNum() uint64 { return defaultStateManager.StateNum() }
+
+// StateGet returns k from the Default StateManager, see StateManager.StateGet
+func StateGet(k string) (interface{}, error) { return defaultStateManager.StateGet(k) }
+
+// StateGetString returns k from the Default StateManager, see StateManager.StateGetString
+func StateGetString(k string) (string, error) { return defaultStateManager.StateGetString(k) }
+
+// StateGetInt64 returns k from the Default StateManager, see StateManager.StateGetInt64
+func StateGetInt64(k string) (int64, error) { return defaultStateManager.StateGetInt64(k) }
+
+// StateQuery queries the Default StateManager, see StateManager.StateQuery
+func StateQuery(pattern string) map[string]string { return defaultStateManager.StateQuery(pattern) }
+
+// StateQueryAt queries the history of the Default StateManager, see StateManager.StateQueryAt
+func StateQueryAt(pattern string, stateNum uint64) (map[string]string, error) {
+	return defaultStateManager.StateQueryAt(pattern, stateNum)
+}
@@ -7,136 +7,420 @@ package statemanager
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
 
+// SaverVersions is the number of older versions of the file kept by a
+// Saver created with version set
+var SaverVersions = 5
+
+// SaverDebounce is how long the state must go without changes before a
+// Saver writes it out, unless the Saver's interval is reached first
+var SaverDebounce = 500 * time.Millisecond
+
+var errChecksum = errors.New("Checksum Mismatch")
+
 // Saver handles saving part (or all) of the state to a file
 type Saver struct {
 	sync.Mutex
+	sm          *StateManager
 	state       map[string]*string
 	name        string
 	interval    time.Duration
 	version     bool
 	listener    *Listener
 	saveTrigger chan bool
+	quit        chan bool
+	done        chan bool
 	lastSaved   time.Time
+	firstChange time.Time
+	lastChange  time.Time
+	pending     int64
+	stateIDs    map[string]string
 }
 
 // NewSaver creates a new saver.
 // name: name of the file
 // base: pattern to match (see PatternMatch for examples of matching)
-// interval: time between saves.  Zero if you want/need a save on every change, will only
+// interval: longest time a change waits to be saved.  Changes are saved once the state
+//           has been quiet for SaverDebounce, or interval after the first unsaved change
+//           if it keeps changing.  Zero if you want/need a save on every change, will only
 //           save if something has actually changed
-// version: save older versions of the file (move file to file.1, file.1 to file.2, etc) NOT IMPLEMENTED!
-func NewSaver(name, base string, interval time.Duration, version, setFromFile bool) *Saver {
+// version: save SaverVersions older versions of the file (move file to file.1, file.1 to file.2, etc)
+func (sm *StateManager) NewSaver(name, base string, interval time.Duration, version, setFromFile bool) *Saver {
 	log.Printf("Saver(%v): Opening", name)
 
 	if setFromFile {
-		StateSetGroup(loadState(name))
+		sm.setFromFile(name)
 	}
 
 	s := &Saver{
+		sm:          sm,
 		state:       make(map[string]*string),
 		name:        name,
 		interval:    interval,
 		version:     version,
-		saveTrigger: make(chan bool),
+		saveTrigger: make(chan bool, 1),
+		quit:        make(chan bool),
+		done:        make(chan bool),
+		stateIDs:    make(map[string]string),
 	}
+	s.stateIDs["lastSaved"] = fmt.Sprintf("Saver(%s).LastSaved", name)
+	s.stateIDs["pending"] = fmt.Sprintf("Saver(%s).Pending", name)
 
-	s.listener = NewListener(fmt.Sprintf("Saver(%s)", name), s.processUpdates)
+	s.listener = sm.NewListener(fmt.Sprintf("Saver(%s)", name), s.processUpdates)
 	s.listener.RegisterPaths([]string{base})
 	go s.saveLoop()
 
 	return s
 }
 
+// NewSaver creates a new saver on the Default StateManager, see StateManager.NewSaver
+func NewSaver(name, base string, interval time.Duration, version, setFromFile bool) *Saver {
+	return defaultStateManager.NewSaver(name, base, interval, version, setFromFile)
+}
+
 // Close unregisters the Saver from the statemanager and stops the saving go routine (issuing one last save
 // in case there were changes since last save)
 func (s *Saver) Close() {
 	log.Printf("Saver(%v): Closing", s.name)
 	s.listener.Close()
-	s.saveState()
-	s.listener = nil
-	s.saveTrigger <- true
+	close(s.quit)
+	<-s.done
 }
 
 func (s *Saver) processUpdates(updates map[string]*string) {
 	s.Lock()
-	defer s.Unlock()
-
+	changes := s.pending
 	for key, value := range updates {
+		if strings.HasPrefix(key, "Saver(") {
+			// Skip the Saver's own keys, saving them would trigger another save
+			continue
+		}
 		if value == nil {
 			delete(s.state, key)
 		} else {
 			s.state[key] = value
 		}
+		s.pending++
+	}
+	if s.pending == changes {
+		s.Unlock()
+		return
 	}
 
 	now := time.Now()
-	if s.interval == 0 || now.Sub(s.lastSaved) >= s.interval {
-		s.lastSaved = now
-		s.saveTrigger <- true
+	if changes == 0 {
+		s.firstChange = now
+	}
+	s.lastChange = now
+	s.Unlock()
+
+	// Never block the listener, a save is already pending if the trigger is full
+	select {
+	case s.saveTrigger <- true:
+	default:
 	}
 }
 
-func loadState(name string) map[string]string {
-	state := make(map[string]string)
+// saveAt returns when the pending changes should be saved
+func (s *Saver) saveAt() time.Time {
+	s.Lock()
+	defer s.Unlock()
 
-	filename := filepath.Join(baseFilePath, name)
-	os.MkdirAll(filepath.Dir(filename), 0775)
-	b, err := ioutil.ReadFile(fmt.Sprintf("%s.json", filename))
-	if err != nil {
-		return nil
+	if s.interval == 0 {
+		return s.lastChange
 	}
-
-	err = json.Unmarshal(b, &state)
-	if err != nil {
-		fmt.Println("error:", err)
-		return nil
+	debounce := SaverDebounce
+	if debounce > s.interval {
+		debounce = s.interval
 	}
-
-	return state
+	at := s.lastChange.Add(debounce)
+	if latest := s.firstChange.Add(s.interval); latest.Before(at) {
+		at = latest
+	}
+	return at
 }
 
-// TODO: implement s.interval in a better way.  only issues save if the last save was more than interval away,
-// should possibly issue a save if nothing has changed in that time too.
 func (s *Saver) saveLoop() {
+	timer := time.NewTimer(0)
+	<-timer.C
+	defer timer.Stop()
+
+	armed := false
 	for {
 		select {
 		case <-s.saveTrigger:
-			if s.listener == nil {
-				// listener is nil, saver close was requsted, saveState already called from Close()
-				return
+			timer.Stop()
+			select {
+			case <-timer.C:
+			default:
 			}
+			timer.Reset(s.saveAt().Sub(time.Now()))
+			if !armed {
+				armed = true
+				s.publishPending()
+			}
+		case <-timer.C:
+			armed = false
+			s.saveState()
+		case <-s.quit:
 			s.saveState()
+			close(s.done)
+			return
 		}
 	}
 }
 
+// publishPending updates Saver(name).Pending once per armed save rather
+// than on every change, to keep the statemanager lock out of the listener
+func (s *Saver) publishPending() {
+	s.Lock()
+	pending := s.pending
+	s.Unlock()
+
+	s.sm.Lock()
+	s.sm.StateUpdateInt64(s.stateIDs["pending"], pending)
+	s.sm.Unlock()
+}
+
 func (s *Saver) saveState() {
-	filename := filepath.Join(baseFilePath, s.name)
-	os.MkdirAll(filepath.Dir(filename), 0775)
-	w, err := os.Create(fmt.Sprintf("%s.json", filename))
-	if err != nil {
+	s.Lock()
+	if s.pending == 0 {
+		s.Unlock()
+		return
+	}
+	versions := 0
+	if s.version {
+		versions = SaverVersions
+	}
+	if err := writeState(s.name, s.state, versions); err != nil {
+		s.Unlock()
 		log.Print("Cannot save state to disk.", err)
+		return
 	}
-	defer w.Close()
+	s.lastSaved = time.Now()
+	s.pending = 0
+	lastSaved := s.lastSaved
+	s.Unlock()
 
-	s.Lock()
-	defer s.Unlock()
-	b, err := json.Marshal(s.state)
+	s.sm.Lock()
+	s.sm.StateUpdateTime(s.stateIDs["lastSaved"], lastSaved)
+	s.sm.StateUpdateInt64(s.stateIDs["pending"], 0)
+	s.sm.Unlock()
+}
+
+// loadState loads the file name, falling back to the newest older version
+// that passes its checksum if the file is missing or damaged
+func loadState(name string) map[string]string {
+	filename := fmt.Sprintf("%s.json", filepath.Join(baseFilePath, name))
+	os.MkdirAll(filepath.Dir(filename), 0775)
+
+	e := encryptionFor(name)
+	for i := 0; i <= SaverVersions; i++ {
+		f := versionName(filename, i)
+		state, err := readState(f, e)
+		if err == nil {
+			if i > 0 {
+				log.Printf("Saver(%v): WARNING: %v is missing or damaged, loaded %v instead", name, filename, f)
+			}
+			return state
+		}
+		if !os.IsNotExist(err) {
+			log.Printf("Saver(%v): Cannot load %v: %v", name, f, err)
+		}
+	}
+	return nil
+}
+
+// setFromFile sets the state from the file name, see loadState.  Keys that
+// can't be set, such as values saved before a constraint was added, are
+// logged and skipped so the rest of the file is still loaded.
+func (sm *StateManager) setFromFile(name string) {
+	state := loadState(name)
+	sm.Lock()
+	defer sm.Unlock()
+	sm.stateSetGroup(state, false)
+}
+
+// ReadState returns the state saved in the file name by SaveState or a
+// Saver, decrypting it if it was set up by EncryptFiles
+func ReadState(name string) (map[string]string, error) {
+	return readState(fmt.Sprintf("%s.json", filepath.Join(baseFilePath, name)), encryptionFor(name))
+}
+
+// readState reads the state from filename, checking it against its checksum
+// file.  Files saved before checksums were written are accepted as is.
+// Encrypted files are decrypted with e.
+func readState(filename string, e *Encryption) (map[string]string, error) {
+	b, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Println("error:", err)
+		return nil, err
+	}
+	if sum, err := ioutil.ReadFile(checksumName(filename)); err == nil {
+		if strings.TrimSpace(string(sum)) != checksum(b) {
+			return nil, errChecksum
+		}
 	}
+	if b, err = decrypt(b, e); err != nil {
+		return nil, err
+	}
+
+	state := make(map[string]string)
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func versionName(filename string, version int) string {
+	if version == 0 {
+		return filename
+	}
+	return fmt.Sprintf("%s.%d", filename, version)
+}
+
+func checksumName(filename string) string {
+	return filename + ".sha256"
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// SaveState writes the current state matching base (see PatternMatch for
+// examples of matching) to the file name, in the same format used by Saver.
+// The statemanager lock must be held by the caller.
+func (sm *StateManager) SaveState(name, base string) error {
+	return sm.saveState(name, base, false)
+}
 
+// SaveStateRedacted writes the state like SaveState, leaving out the keys
+// marked as personal data (see KeySchema.Personal)
+func (sm *StateManager) SaveStateRedacted(name, base string) error {
+	return sm.saveState(name, base, true)
+}
+
+func (sm *StateManager) saveState(name, base string, redact bool) error {
+	pm := newPatternMatcher(base)
+	state := make(map[string]*string)
+	for k, s := range sm.states {
+		if !pm.Matches(k) || (redact && sm.IsPII(k)) {
+			continue
+		}
+		if v, e := s.Value(); !e {
+			state[k] = &v
+		}
+	}
+	return writeState(name, state, 0)
+}
+
+// SaveState saves part of the Default StateManager, see StateManager.SaveState
+func SaveState(name, base string) error { return defaultStateManager.SaveState(name, base) }
+
+// SaveStateRedacted saves part of the Default StateManager, see StateManager.SaveStateRedacted
+func SaveStateRedacted(name, base string) error {
+	return defaultStateManager.SaveStateRedacted(name, base)
+}
+
+// encodeState returns the contents of a file holding state, encrypted
+// with e if it isn't nil
+func encodeState(state map[string]*string, e *Encryption) ([]byte, error) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
 	var out bytes.Buffer
 	json.Indent(&out, b, "", "\t")
-	out.WriteTo(w)
+	if e == nil {
+		return out.Bytes(), nil
+	}
+	return e.seal(out.Bytes())
+}
+
+// writeState writes state to the file name (with .json appended) along with
+// its checksum.  The file is written to a temporary file first, synced and
+// then renamed over the old one so a crash never leaves a partial file.
+// Files set up by EncryptFiles are encrypted.
+// Up to versions older files are kept as name.json.1, name.json.2, etc.
+func writeState(name string, state map[string]*string, versions int) error {
+	filename := fmt.Sprintf("%s.json", filepath.Join(baseFilePath, name))
+	os.MkdirAll(filepath.Dir(filename), 0775)
+
+	b, err := encodeState(state, encryptionFor(name))
+	if err != nil {
+		return err
+	}
+	return replaceFile(filename, b, versions)
+}
+
+// rename is os.Rename, replaced by tests to simulate a crash
+var rename = os.Rename
+
+// replaceFile replaces filename with b, and its checksum file, keeping up
+// to versions older files.  The old checksum is removed before the new file
+// is renamed into place, and the new checksum is renamed after it, so a
+// crash at any point leaves a file that either matches its checksum or has
+// none (see readState).
+func replaceFile(filename string, b []byte, versions int) error {
+	if err := writeFileSync(filename+".tmp", b); err != nil {
+		return err
+	}
+	if err := writeFileSync(checksumName(filename)+".tmp", []byte(checksum(b)+"\n")); err != nil {
+		return err
+	}
+
+	for i := versions; i > 0; i-- {
+		from, to := versionName(filename, i-1), versionName(filename, i)
+		rename(from, to)
+		rename(checksumName(from), checksumName(to))
+	}
+	if err := os.Remove(checksumName(filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	syncDir(filepath.Dir(filename))
+
+	if err := rename(filename+".tmp", filename); err != nil {
+		return err
+	}
+	if err := rename(checksumName(filename)+".tmp", checksumName(filename)); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(filename))
+	return nil
+}
+
+func writeFileSync(filename string, b []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// syncDir syncs the directory dir so renames within it are durable
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
 }
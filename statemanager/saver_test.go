@@ -0,0 +1,153 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaverFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "saver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetBaseFilePath(baseFilePath)
+	SetBaseFilePath(dir)
+
+	for _, v := range []string{"1", "2", "3"} {
+		v := v
+		if err := writeState("settings", map[string]*string{"Settings.Value": &v}, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "settings.json.3")); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 older versions to be kept")
+	}
+	if s := loadState("settings"); s["Settings.Value"] != "3" {
+		t.Errorf("loadState: expected 3 got %v", s)
+	}
+
+	// A partial write no longer matches the checksum, the newest older version is loaded
+	ioutil.WriteFile(filepath.Join(dir, "settings.json"), []byte("{\"Settings.Va"), 0664)
+	if s := loadState("settings"); s["Settings.Value"] != "2" {
+		t.Errorf("loadState after damage: expected 2 got %v", s)
+	}
+}
+
+func TestSaverTrailingSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "saver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetBaseFilePath(baseFilePath)
+	SetBaseFilePath(dir)
+	defer func(d time.Duration) { SaverDebounce = d }(SaverDebounce)
+	SaverDebounce = 10 * time.Millisecond
+
+	sm := New()
+	s := sm.NewSaver("settings", "Settings", time.Hour, false, false)
+	defer s.Close()
+
+	sm.Lock()
+	sm.StateUpdateString("Settings.Value", "1")
+	sm.Unlock()
+
+	// The change is saved once things go quiet, without waiting for the interval
+	for i := 0; i < 100; i++ {
+		sm.Lock()
+		_, err := sm.StateGet("Saver(settings).LastSaved")
+		sm.Unlock()
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := loadState("settings"); st["Settings.Value"] != "1" {
+		t.Errorf("Expected trailing save, got %v", st)
+	}
+	sm.Lock()
+	pending, err := sm.StateGetInt64("Saver(settings).Pending")
+	sm.Unlock()
+	if err != nil || pending != 0 {
+		t.Errorf("Expected nothing pending after the save, got %v, %v", pending, err)
+	}
+}
+
+func TestSaverLoadInvalidKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "saver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetBaseFilePath(baseFilePath)
+	SetBaseFilePath(dir)
+
+	name, color := "Home", "Dark Blue"
+	if err := writeState("scoreboard", map[string]*string{"Scoreboard.Team(1).Name": &name, "Scoreboard.Team(1).Color": &color}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The color was saved before the constraint was added, only it is skipped
+	sm := New()
+	sm.RegisterPatternUpdaterString("Scoreboard", 0, sm.StateUpdateString)
+	sm.RegisterKey("Scoreboard.Team(*).Color", TypeString, "Color").Match(`#[0-9A-Fa-f]{6}|[A-Za-z]+`)
+	s := sm.NewSaver("scoreboard", "Scoreboard", 0, false, true)
+	s.Close()
+
+	sm.Lock()
+	q := sm.StateQuery("Scoreboard")
+	sm.Unlock()
+	if len(q) != 1 || q["Scoreboard.Team(1).Name"] != "Home" {
+		t.Errorf("Expected only the valid key to be loaded, got %v", q)
+	}
+}
+
+func TestSaverCrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "saver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetBaseFilePath(baseFilePath)
+	SetBaseFilePath(dir)
+	defer func() { rename = os.Rename }()
+
+	errCrash := errors.New("Crash")
+	for _, versions := range []int{0, 2} {
+		for crashAt := 0; ; crashAt++ {
+			os.RemoveAll(dir)
+			old, updated := "old", "new"
+			rename = os.Rename
+			if err := writeState("settings", map[string]*string{"Settings.Value": &old}, versions); err != nil {
+				t.Fatal(err)
+			}
+
+			// Stop renaming files after crashAt renames
+			renames := 0
+			rename = func(from, to string) error {
+				if renames == crashAt {
+					return errCrash
+				}
+				renames++
+				return os.Rename(from, to)
+			}
+			err := writeState("settings", map[string]*string{"Settings.Value": &updated}, versions)
+			if s := loadState("settings"); s["Settings.Value"] != old && s["Settings.Value"] != updated {
+				t.Errorf("Crash after %v renames with %v versions: loaded %v", crashAt, versions, s)
+			}
+			if err != errCrash {
+				break
+			}
+		}
+	}
+}
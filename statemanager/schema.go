@@ -0,0 +1,240 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Types of values described by a KeySchema
+const (
+	TypeString   = "string"
+	TypeInt64    = "int64"
+	TypeBool     = "bool"
+	TypeTime     = "time"
+	TypeFloat64  = "float64"
+	TypeDuration = "duration"
+	TypeJSON     = "json"
+)
+
+// KeySchema describes the keys matching Pattern.  Writable is set when
+// the keys can be changed with StateSet, it is filled in by Schema.
+// Values passed to StateSet must meet the constraints (Min, Max, Values,
+// Regexp and MaxLen) that are set.  PII keys hold personal data, which is
+// masked in logs and left out of redacted copies of the state.
+type KeySchema struct {
+	Pattern     string   `json:"pattern"`
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Min         *int64   `json:"min,omitempty"`
+	Max         *int64   `json:"max,omitempty"`
+	Values      []string `json:"values,omitempty"`
+	Regexp      string   `json:"regexp,omitempty"`
+	MaxLen      int      `json:"maxLength,omitempty"`
+	PII         bool     `json:"pii,omitempty"`
+	Writable    bool     `json:"writable"`
+
+	key string
+	re  *regexp.Regexp
+}
+
+// ConstraintError is returned by StateSet when a value does not meet the
+// constraints of its key
+type ConstraintError struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("Invalid Value: %v %v", e.Key, e.Reason)
+}
+
+// schemaPattern replaces the IDs in key with (*), so the keys of every
+// Team, Skater, etc share a schema
+func schemaPattern(key string) string {
+	segs := splitKey(key)
+	for i, seg := range segs {
+		if name, ok := idName(seg); ok {
+			segs[i] = name + "(*)"
+		}
+	}
+	return strings.Join(segs, ".")
+}
+
+// RegisterKey describes the key (or pattern) k.  Keys that only differ by
+// their IDs share a schema, so it is safe to register the keys of each
+// object as it is created.
+func (sm *StateManager) RegisterKey(k, t, description string) *KeySchema {
+	pattern := schemaPattern(k)
+	ks := &KeySchema{Pattern: pattern, Type: t, Description: description, key: k}
+	sm.schema[pattern] = ks
+	return ks
+}
+
+// Range sets the minimum and maximum values of an int64 key
+func (ks *KeySchema) Range(min, max int64) *KeySchema {
+	ks.Min = &min
+	ks.Max = &max
+	return ks
+}
+
+// AtLeast sets the minimum value of an int64 key
+func (ks *KeySchema) AtLeast(min int64) *KeySchema {
+	ks.Min = &min
+	return ks
+}
+
+// Enum limits the key to one of values
+func (ks *KeySchema) Enum(values ...string) *KeySchema {
+	ks.Values = values
+	return ks
+}
+
+// Match limits the key to values matching the regular expression expr
+// in full.  Panics if expr cannot be compiled.
+func (ks *KeySchema) Match(expr string) *KeySchema {
+	ks.Regexp = expr
+	ks.re = regexp.MustCompile("^(?:" + expr + ")$")
+	return ks
+}
+
+// MaxLength limits the key to values of at most n characters
+func (ks *KeySchema) MaxLength(n int) *KeySchema {
+	ks.MaxLen = n
+	return ks
+}
+
+// Personal marks the key as holding personal data
+func (ks *KeySchema) Personal() *KeySchema {
+	ks.PII = true
+	return ks
+}
+
+// validate checks value against the constraints of ks
+func (ks *KeySchema) validate(k, value string) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ConstraintError{Key: k, Value: value, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if ks.Min != nil || ks.Max != nil {
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fail("must be a whole number")
+		}
+		if ks.Min != nil && v < *ks.Min {
+			return fail("must be at least %v", *ks.Min)
+		}
+		if ks.Max != nil && v > *ks.Max {
+			return fail("must be at most %v", *ks.Max)
+		}
+	}
+	if len(ks.Values) > 0 {
+		found := false
+		for _, v := range ks.Values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("must be one of %v", strings.Join(ks.Values, ", "))
+		}
+	}
+	if ks.re != nil && !ks.re.MatchString(value) {
+		return fail("must match %v", ks.Regexp)
+	}
+	if ks.MaxLen > 0 && utf8.RuneCountInString(value) > ks.MaxLen {
+		return fail("must be at most %v characters", ks.MaxLen)
+	}
+	return nil
+}
+
+// findSchema returns the schema registered for k, either by its IDs or
+// by a pattern ending in .* above it
+func (sm *StateManager) findSchema(k string) *KeySchema {
+	pattern := schemaPattern(k)
+	if ks, ok := sm.schema[pattern]; ok {
+		return ks
+	}
+	segs := splitKey(pattern)
+	for i := len(segs) - 1; i > 0; i-- {
+		if ks, ok := sm.schema[strings.Join(segs[:i], ".")+".*"]; ok {
+			return ks
+		}
+	}
+	return nil
+}
+
+// validate checks value against the constraints registered for k
+func (sm *StateManager) validate(k, value string) error {
+	if ks := sm.findSchema(k); ks != nil {
+		return ks.validate(k, value)
+	}
+	return nil
+}
+
+// redactedValue replaces the values of PII keys in logs
+const redactedValue = "[redacted]"
+
+// IsPII returns true if k, or a key shared from another StateManager, has
+// been marked as personal data
+func (sm *StateManager) IsPII(k string) bool {
+	if s := sm.findShare(k); s != nil {
+		s.from.Lock()
+		defer s.from.Unlock()
+		return s.from.IsPII(k)
+	}
+	ks := sm.findSchema(k)
+	return ks != nil && ks.PII
+}
+
+// Redact returns v, or a placeholder if k holds personal data, for logging
+func (sm *StateManager) Redact(k, v string) string {
+	if sm.IsPII(k) {
+		return redactedValue
+	}
+	return v
+}
+
+// Schema returns the registered keys, including those shared from other
+// StateManagers, sorted by pattern
+func (sm *StateManager) Schema() []KeySchema {
+	var schema []KeySchema
+	for _, ks := range sm.schema {
+		k := *ks
+		k.Writable = sm.findStateUpdater(ks.key) != nil
+		schema = append(schema, k)
+	}
+	for _, s := range sm.shares {
+		s.from.Lock()
+		for _, ks := range s.from.Schema() {
+			if s.pm.Matches(ks.Pattern) {
+				schema = append(schema, ks)
+			}
+		}
+		s.from.Unlock()
+	}
+
+	sort.Slice(schema, func(i, j int) bool { return schema[i].Pattern < schema[j].Pattern })
+	return schema
+}
+
+// RegisterKey describes a key of the Default StateManager, see StateManager.RegisterKey
+func RegisterKey(k, t, description string) *KeySchema {
+	return defaultStateManager.RegisterKey(k, t, description)
+}
+
+// IsPII checks a key of the Default StateManager, see StateManager.IsPII
+func IsPII(k string) bool { return defaultStateManager.IsPII(k) }
+
+// Schema returns the keys registered with the Default StateManager, see StateManager.Schema
+func Schema() []KeySchema { return defaultStateManager.Schema() }
@@ -0,0 +1,55 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import "fmt"
+
+// share makes part of another StateManager's state available
+type share struct {
+	pm       patternMatcher
+	from     *StateManager
+	listener *Listener
+}
+
+// Share makes the state matching base (see PatternMatch for examples of
+// matching) in from available in sm.  Changes in from are copied into sm
+// and sets and commands in sm matching base are passed on to from.  When
+// both are locked, sm must be locked before from.
+func (sm *StateManager) Share(base string, from *StateManager) {
+	s := &share{pm: newPatternMatcher(base), from: from}
+	s.listener = from.NewListener(fmt.Sprintf("Share(%v)", base), func(updates map[string]*string) {
+		sm.Lock()
+		defer sm.Unlock()
+		for k, v := range updates {
+			if v == nil {
+				sm.StateDelete(k)
+			} else {
+				sm.StateUpdateString(k, *v)
+			}
+		}
+	})
+
+	sm.lock.Lock()
+	sm.shares = append(sm.shares, s)
+	sm.lock.Unlock()
+
+	s.listener.RegisterPaths([]string{base})
+}
+
+func (sm *StateManager) findShare(name string) *share {
+	for _, s := range sm.shares {
+		if s.pm.Matches(name) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *share) stateSetGroup(values map[string]string, stopOnError bool) error {
+	s.from.Lock()
+	defer s.from.Unlock()
+	return s.from.stateSetGroup(values, stopOnError)
+}
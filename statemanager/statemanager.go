@@ -9,6 +9,8 @@
 package statemanager
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"log"
 	"path/filepath"
@@ -19,23 +21,50 @@ import (
 )
 
 type state struct {
-	stateNum    uint64
-	name        string
-	isEmpty     bool
-	valueString string
-	valueInt64  int64
-	valueBool   bool
-	valueTime   time.Time
-	t           string
-}
-
-var states map[string]*state
-var lock sync.Mutex
-var cond *sync.Cond
-var debugFlag = false
+	stateNum      uint64
+	name          string
+	isEmpty       bool
+	valueString   string
+	valueInt64    int64
+	valueBool     bool
+	valueTime     time.Time
+	valueFloat64  float64
+	valueDuration time.Duration
+	valueJSON     string
+	t             string
+}
 
-var stateNum = uint64(1)
-var stateUpdated = false
+// StateManager holds a state tree along with the listeners, updaters and
+// commands bound to it.  Each StateManager is independent of the others,
+// the package level functions operate on the Default StateManager.
+type StateManager struct {
+	lock           sync.Mutex
+	cond           *sync.Cond
+	states         map[string]*state
+	listeners      []*Listener
+	listenerIndex  *patternIndex
+	updaters       map[string]*stateUpdater
+	updaterIndex   *patternIndex
+	commands       map[string]CommandResultFunc
+	commandInfo    map[string]*CommandInfo
+	schema         map[string]*KeySchema
+	shares         []*share
+	computedIndex  *patternIndex
+	computePending []string
+	journals       []*Journal
+	stateNum       uint64
+	stateUpdated   bool
+	dirty          map[string]*state
+	history        history
+	undo           []undo
+	txMarks        []int
+	removeHooks    []removeHook
+
+	tombstonesCollected int64
+}
+
+var defaultStateManager = newStateManager()
+var debugFlag = false
 
 // ErrNotFound is returned when the key name is not in the current state
 var ErrNotFound = errors.New("State Not Found")
@@ -44,7 +73,8 @@ var ErrNotFound = errors.New("State Not Found")
 var ErrUpdaterNotFound = errors.New("Updater Not Found")
 
 // ErrUnknownType is returned when the type passed to the statemanager is not
-// one of the supported types (currently string, int64, bool)
+// one of the supported types (string, int64, bool, time.Time, float64,
+// time.Duration and JSON)
 var ErrUnknownType = errors.New("Unknown State Type")
 
 var baseFilePath = ""
@@ -62,25 +92,53 @@ func SetBaseFilePath(p ...string) {
 	baseFilePath = path
 }
 
-// Initialize starts up the statemanager
-func Initialize() {
-	cond = sync.NewCond(&lock)
-	states = make(map[string]*state)
+func newStateManager() *StateManager {
+	sm := &StateManager{
+		states:        make(map[string]*state),
+		updaters:      make(map[string]*stateUpdater),
+		updaterIndex:  newPatternIndex(),
+		listenerIndex: newPatternIndex(),
+		computedIndex: newPatternIndex(),
+		commands:      make(map[string]CommandResultFunc),
+		commandInfo:   make(map[string]*CommandInfo),
+		schema:        make(map[string]*KeySchema),
+		stateNum:      1,
+		dirty:         make(map[string]*state),
+		history:       history{limit: DefaultHistoryLimit},
+	}
+	sm.cond = sync.NewCond(&sm.lock)
+	return sm
+}
 
-	go flushListeners()
+// New creates a StateManager with an empty state tree and starts
+// sending updates to its listeners
+func New() *StateManager {
+	sm := newStateManager()
+	go sm.flushListeners()
+	return sm
+}
+
+// Default returns the StateManager used by the package level functions
+func Default() *StateManager { return defaultStateManager }
+
+// Initialize starts up the default statemanager
+func Initialize() {
+	go defaultStateManager.flushListeners()
 }
 
 // Lock places the statemanager in a locked state, should be called before
 // any updates to the state are made, but only once.
-func Lock() {
-	lock.Lock()
+func (sm *StateManager) Lock() {
+	sm.lock.Lock()
 }
 
-// Unlock removes the lock from the statemanager and starts the processing
-// of any updates to listeners waiting for changes
-func Unlock() {
-	cond.Signal()
-	lock.Unlock()
+// Unlock removes the lock from the statemanager, recomputing any computed
+// keys and starting the processing of any updates to listeners waiting
+// for changes
+func (sm *StateManager) Unlock() {
+	sm.recompute()
+	sm.cond.Signal()
+	sm.lock.Unlock()
 }
 
 func (s *state) Value() (string, bool) {
@@ -95,28 +153,44 @@ func (s *state) Value() (string, bool) {
 	case "bool":
 		return strconv.FormatBool(s.valueBool), false
 	case "time":
-		return s.valueTime.UTC().Format(time.RFC3339), false
+		return s.valueTime.UTC().Format(time.RFC3339Nano), false
+	case "float64":
+		return strconv.FormatFloat(s.valueFloat64, 'f', -1, 64), false
+	case "duration":
+		return strconv.FormatInt(int64(s.valueDuration/time.Millisecond), 10), false
+	case "json":
+		return s.valueJSON, false
 	}
 
 	// Unknown type, return empty
 	return "", true
 }
 
+// changed records the current value of s in the history and marks s as
+// changed in the current stateNum.  Must be called before s is modified.
+func (sm *StateManager) changed(s *state) {
+	sm.recordUndo(s)
+	sm.recordChange(s)
+	s.stateNum = sm.stateNum
+	sm.dirty[s.name] = s
+	sm.stateUpdated = true
+	sm.computePending = append(sm.computePending, s.name)
+}
+
 // StateUpdate sets the state for keyName to value.
 // Passing nil as value will mark the state to nil
 // and all states that starts with keyName + "."
-func stateUpdate(k string, v interface{}) error {
+func (sm *StateManager) stateUpdate(k string, v interface{}) error {
 	log.Printf("StateUpdate(%v): Using Old Interface", k)
 	if v == nil {
 		if debugFlag {
 			log.Printf("StateUpdate: DELETING SUBTREE %s", k)
 		}
-		for key := range states {
+		for key := range sm.states {
 			if key == k || strings.Index(key, k+".") == 0 {
-				s := states[key]
-				s.stateNum = stateNum
+				s := sm.states[key]
+				sm.changed(s)
 				s.isEmpty = true
-				stateUpdated = true
 			}
 		}
 		return nil
@@ -124,98 +198,205 @@ func stateUpdate(k string, v interface{}) error {
 
 	switch v := v.(type) {
 	case string:
-		return StateUpdateString(k, v)
+		return sm.StateUpdateString(k, v)
 	case int64:
-		return StateUpdateInt64(k, v)
+		return sm.StateUpdateInt64(k, v)
 	case bool:
-		return StateUpdateBool(k, v)
+		return sm.StateUpdateBool(k, v)
 	case time.Time:
-		return StateUpdateTime(k, v)
+		return sm.StateUpdateTime(k, v)
+	case float64:
+		return sm.StateUpdateFloat64(k, v)
+	case time.Duration:
+		return sm.StateUpdateDuration(k, v)
+	case json.RawMessage:
+		return sm.StateUpdateJSON(k, v)
 	default:
 		log.Printf("StateUpdate: Unknown type '%T' for %v", v, k)
 		return ErrUnknownType
 	}
 }
 
-func StateDelete(k string) error {
+// StateDelete marks the state for k, and all states that start with k + ".", as deleted
+func (sm *StateManager) StateDelete(k string) error {
 	if debugFlag {
 		log.Printf("StateDelete: %s", k)
 	}
-	for key := range states {
+	for key := range sm.states {
 		if key == k || strings.Index(key, k+".") == 0 {
-			s := states[key]
-			s.stateNum = stateNum
+			s := sm.states[key]
+			sm.changed(s)
 			s.isEmpty = true
-			stateUpdated = true
 		}
 	}
 	return nil
 }
 
-func StateUpdateString(k string, v string) error {
-	s, ok := states[k]
+// StateUpdateString sets the state for k to the string v
+func (sm *StateManager) StateUpdateString(k string, v string) error {
+	s, ok := sm.states[k]
 	if !ok {
 		s = &state{name: k, isEmpty: true}
-		states[k] = s
+		sm.states[k] = s
 	}
 	if s.isEmpty || s.t != "string" || s.valueString != v {
+		sm.changed(s)
 		s.t = "string"
 		s.valueString = v
 		s.isEmpty = false
-		s.stateNum = stateNum
-		stateUpdated = true
 	}
 	return nil
 }
 
-func StateUpdateInt64(k string, v int64) error {
-	s, ok := states[k]
+// StateUpdateInt64 sets the state for k to the int64 v
+func (sm *StateManager) StateUpdateInt64(k string, v int64) error {
+	s, ok := sm.states[k]
 	if !ok {
 		s = &state{name: k, isEmpty: true}
-		states[k] = s
+		sm.states[k] = s
 	}
 	if s.isEmpty || s.t != "int64" || s.valueInt64 != v {
+		sm.changed(s)
 		s.t = "int64"
 		s.valueInt64 = v
 		s.isEmpty = false
-		s.stateNum = stateNum
-		stateUpdated = true
 	}
 	return nil
 }
 
-func StateUpdateBool(k string, v bool) error {
-	s, ok := states[k]
+// StateUpdateBool sets the state for k to the bool v
+func (sm *StateManager) StateUpdateBool(k string, v bool) error {
+	s, ok := sm.states[k]
 	if !ok {
 		s = &state{name: k, isEmpty: true}
-		states[k] = s
+		sm.states[k] = s
 	}
 	if s.isEmpty || s.t != "bool" || s.valueBool != v {
+		sm.changed(s)
 		s.t = "bool"
 		s.valueBool = v
 		s.isEmpty = false
-		s.stateNum = stateNum
-		stateUpdated = true
 	}
 	return nil
 }
 
-func StateUpdateTime(k string, v time.Time) error {
-	s, ok := states[k]
+// StateUpdateTime sets the state for k to the time.Time v
+func (sm *StateManager) StateUpdateTime(k string, v time.Time) error {
+	s, ok := sm.states[k]
 	if !ok {
 		s = &state{name: k, isEmpty: true}
-		states[k] = s
+		sm.states[k] = s
 	}
 	if s.isEmpty || s.t != "time" || s.valueTime != v {
+		sm.changed(s)
 		s.t = "time"
 		s.valueTime = v
 		s.isEmpty = false
-		s.stateNum = stateNum
-		stateUpdated = true
 	}
 	return nil
 }
 
+// StateUpdateFloat64 sets the state for k to the float64 v
+func (sm *StateManager) StateUpdateFloat64(k string, v float64) error {
+	s, ok := sm.states[k]
+	if !ok {
+		s = &state{name: k, isEmpty: true}
+		sm.states[k] = s
+	}
+	if s.isEmpty || s.t != "float64" || s.valueFloat64 != v {
+		sm.changed(s)
+		s.t = "float64"
+		s.valueFloat64 = v
+		s.isEmpty = false
+	}
+	return nil
+}
+
+// StateUpdateDuration sets the state for k to the time.Duration v, which
+// is sent to listeners in milliseconds
+func (sm *StateManager) StateUpdateDuration(k string, v time.Duration) error {
+	s, ok := sm.states[k]
+	if !ok {
+		s = &state{name: k, isEmpty: true}
+		sm.states[k] = s
+	}
+	if s.isEmpty || s.t != "duration" || s.valueDuration != v {
+		sm.changed(s)
+		s.t = "duration"
+		s.valueDuration = v
+		s.isEmpty = false
+	}
+	return nil
+}
+
+// StateUpdateJSON sets the state for k to v encoded as JSON.  A
+// json.RawMessage or []byte v is stored as is, once checked and compacted.
+func (sm *StateManager) StateUpdateJSON(k string, v interface{}) error {
+	var b []byte
+	switch raw := v.(type) {
+	case json.RawMessage:
+		b = raw
+	case []byte:
+		b = raw
+	default:
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return err
+	}
+
+	s, ok := sm.states[k]
+	if !ok {
+		s = &state{name: k, isEmpty: true}
+		sm.states[k] = s
+	}
+	if s.isEmpty || s.t != "json" || s.valueJSON != buf.String() {
+		sm.changed(s)
+		s.t = "json"
+		s.valueJSON = buf.String()
+		s.isEmpty = false
+	}
+	return nil
+}
+
+// Lock locks the Default StateManager, see StateManager.Lock
+func Lock() { defaultStateManager.Lock() }
+
+// Unlock unlocks the Default StateManager, see StateManager.Unlock
+func Unlock() { defaultStateManager.Unlock() }
+
+// StateDelete deletes k from the Default StateManager, see StateManager.StateDelete
+func StateDelete(k string) error { return defaultStateManager.StateDelete(k) }
+
+// StateUpdateString updates k in the Default StateManager, see StateManager.StateUpdateString
+func StateUpdateString(k string, v string) error { return defaultStateManager.StateUpdateString(k, v) }
+
+// StateUpdateInt64 updates k in the Default StateManager, see StateManager.StateUpdateInt64
+func StateUpdateInt64(k string, v int64) error { return defaultStateManager.StateUpdateInt64(k, v) }
+
+// StateUpdateBool updates k in the Default StateManager, see StateManager.StateUpdateBool
+func StateUpdateBool(k string, v bool) error { return defaultStateManager.StateUpdateBool(k, v) }
+
+// StateUpdateTime updates k in the Default StateManager, see StateManager.StateUpdateTime
+func StateUpdateTime(k string, v time.Time) error { return defaultStateManager.StateUpdateTime(k, v) }
+
+// StateUpdateFloat64 updates k in the Default StateManager, see StateManager.StateUpdateFloat64
+func StateUpdateFloat64(k string, v float64) error {
+	return defaultStateManager.StateUpdateFloat64(k, v)
+}
+
+// StateUpdateDuration updates k in the Default StateManager, see StateManager.StateUpdateDuration
+func StateUpdateDuration(k string, v time.Duration) error {
+	return defaultStateManager.StateUpdateDuration(k, v)
+}
+
+// StateUpdateJSON updates k in the Default StateManager, see StateManager.StateUpdateJSON
+func StateUpdateJSON(k string, v interface{}) error { return defaultStateManager.StateUpdateJSON(k, v) }
+
 // ParseIDs returns all values within () in the input string.
 // Example
 // Scoreboard.Team(1).Skater(abc123).Name returns ["1", "abc123"]
@@ -0,0 +1,464 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestIndependentStateManagers(t *testing.T) {
+	a := newStateManager()
+	b := newStateManager()
+
+	a.RegisterUpdaterString("Test.Name", 0, func(v string) error { return a.StateUpdateString("Test.Name", v) })
+
+	a.Lock()
+	if err := a.StateSet("Test.Name", "a"); err != nil {
+		t.Errorf("StateSet on a: %v", err)
+	}
+	a.Unlock()
+
+	b.Lock()
+	if err := b.StateSet("Test.Name", "b"); err != ErrUpdaterNotFound {
+		t.Errorf("StateSet on b: expected %v got %v", ErrUpdaterNotFound, err)
+	}
+	b.Unlock()
+
+	if s, ok := a.states["Test.Name"]; !ok || s.valueString != "a" {
+		t.Errorf("a: Test.Name not set")
+	}
+	if _, ok := b.states["Test.Name"]; ok {
+		t.Errorf("b: Test.Name should not be set")
+	}
+}
+
+func TestShare(t *testing.T) {
+	shared := newStateManager()
+	sm := newStateManager()
+
+	var name string
+	shared.RegisterUpdaterString("Leagues.Name", 0, func(v string) error { name = v; return nil })
+	shared.RegisterCommand("Leagues.Reset", func([]string) error { name = ""; return nil })
+	sm.Share("Leagues", shared)
+
+	if err := sm.Command("Set", []string{"Leagues.Name", "shared"}); err != nil || name != "shared" {
+		t.Errorf("Set through share: got %q, %v", name, err)
+	}
+	if err := sm.Command("Leagues.Reset", nil); err != nil || name != "" {
+		t.Errorf("Command through share: got %q, %v", name, err)
+	}
+	if err := sm.Command("Set", []string{"Scoreboard.Name", "local"}); err != ErrUpdaterNotFound {
+		t.Errorf("Set outside share: expected %v got %v", ErrUpdaterNotFound, err)
+	}
+}
+
+func TestStateQueryAt(t *testing.T) {
+	sm := newStateManager()
+
+	sm.StateUpdateInt64("Scoreboard.Team(1).Score", 4)
+	sm.StateUpdateString("Scoreboard.Team(1).Name", "Home")
+	first := sm.StateNum()
+	sm.stateNum++
+
+	sm.StateUpdateInt64("Scoreboard.Team(1).Score", 8)
+	sm.StateDelete("Scoreboard.Team(1).Name")
+	sm.stateNum++
+
+	if v, err := sm.StateGetInt64("Scoreboard.Team(1).Score"); err != nil || v != 8 {
+		t.Errorf("StateGetInt64: expected 8 got %v, %v", v, err)
+	}
+	if _, err := sm.StateGetString("Scoreboard.Team(1).Name"); err != ErrNotFound {
+		t.Errorf("StateGetString: expected %v got %v", ErrNotFound, err)
+	}
+	if q := sm.StateQuery("Scoreboard.Team(*)"); len(q) != 1 || q["Scoreboard.Team(1).Score"] != "8" {
+		t.Errorf("StateQuery: got %v", q)
+	}
+
+	q, err := sm.StateQueryAt("Scoreboard", first)
+	if err != nil || len(q) != 2 || q["Scoreboard.Team(1).Score"] != "4" || q["Scoreboard.Team(1).Name"] != "Home" {
+		t.Errorf("StateQueryAt(%v): got %v, %v", first, q, err)
+	}
+
+	sm.SetHistoryLimit(0)
+	sm.StateUpdateInt64("Scoreboard.Team(1).Score", 12)
+	if _, err := sm.StateQueryAt("Scoreboard", first); err != ErrHistoryUnavailable {
+		t.Errorf("StateQueryAt after trim: expected %v got %v", ErrHistoryUnavailable, err)
+	}
+}
+
+func TestFlushChanged(t *testing.T) {
+	sm := newStateManager()
+	sm.StateUpdateString("Scoreboard.Team(1).Name", "Home")
+	sm.StateUpdateString("Scoreboard.Team(2).Name", "Away")
+	sm.flushChanged()
+	sm.dirty = make(map[string]*state)
+	sm.stateNum++
+
+	ch := make(chan map[string]*string, 2)
+	l := sm.NewListener("test", func(u map[string]*string) { ch <- u })
+	l.RegisterPaths([]string{"Scoreboard.Team(*)"})
+	if u := <-ch; len(u) != 2 {
+		t.Errorf("Expected all states for new paths, got %v", u)
+	}
+
+	sm.StateUpdateString("Scoreboard.Team(2).Name", "Visitors")
+	sm.StateUpdateString("Settings.View", "scoreboard")
+	sm.flushChanged()
+	if u := <-ch; len(u) != 1 || *u["Scoreboard.Team(2).Name"] != "Visitors" {
+		t.Errorf("Expected only the changed state, got %v", u)
+	}
+}
+
+func TestCollectTombstones(t *testing.T) {
+	sm := newStateManager()
+	sm.StateUpdateString("Scoreboard.Team(1).Skater(a).Name", "A")
+	sm.StateUpdateString("Scoreboard.Team(1).Skater(a).Number", "1")
+	sm.StateUpdateString("Scoreboard.Team(1).Name", "Home")
+	sm.flush()
+
+	sm.StateDelete("Scoreboard.Team(1).Skater(a)")
+	sm.flush()
+
+	if _, ok := sm.states["Scoreboard.Team(1).Skater(a).Name"]; ok {
+		t.Errorf("Deleted state was not collected")
+	}
+	if v, err := sm.StateGetInt64("StateManager.Tombstones.Collected"); err != nil || v != 2 {
+		t.Errorf("Tombstones.Collected: expected 2 got %v, %v", v, err)
+	}
+	if len(sm.dirty) != 0 || sm.stateUpdated {
+		t.Errorf("Stats update was not flushed")
+	}
+}
+
+func TestListenerCoalescing(t *testing.T) {
+	sm := newStateManager()
+	block := make(chan bool)
+	ch := make(chan map[string]*string, 10)
+	l := sm.NewListener("slow", func(u map[string]*string) {
+		ch <- u
+		if u != nil {
+			<-block
+		}
+	})
+	l.RegisterPaths([]string{"Scoreboard"})
+
+	// While the callback is busy later updates are combined, latest value wins
+	for _, v := range []string{"1", "2", "3"} {
+		sm.StateUpdateString("Scoreboard.Clock", v)
+		sm.flush()
+		if v == "1" {
+			<-ch
+		}
+	}
+	block <- true
+	if u := <-ch; len(u) != 1 || *u["Scoreboard.Clock"] != "3" {
+		t.Errorf("Expected coalesced update, got %v", u)
+	}
+	block <- true
+
+	defer func(d time.Duration) { ListenerMaxLag = d }(ListenerMaxLag)
+	ListenerMaxLag = 0
+	sm.StateUpdateString("Scoreboard.Clock", "4")
+	sm.flush()
+	<-ch
+	sm.StateUpdateString("Scoreboard.Clock", "5")
+	sm.flush()
+	sm.StateUpdateString("Scoreboard.Clock", "6")
+	sm.flush()
+	block <- true
+	if u := <-ch; u != nil {
+		t.Errorf("Expected slow listener to be disconnected, got %v", u)
+	}
+	if len(sm.listeners) != 0 {
+		t.Errorf("Disconnected listener still registered")
+	}
+}
+
+func TestListenerStateNum(t *testing.T) {
+	sm := newStateManager()
+	block := make(chan bool)
+	ch := make(chan map[string]*string, 10)
+	l := sm.NewListener("busy", func(u map[string]*string) {
+		ch <- u
+		if u != nil {
+			<-block
+		}
+	})
+
+	// Updates sent with an older stateNum don't make the pending ones older
+	l.deliver(map[string]*string{}, 5)
+	<-ch
+	l.deliver(map[string]*string{}, 7)
+	l.deliver(map[string]*string{}, 6)
+	block <- true
+	<-ch
+	if n := l.StateNum(); n != 7 {
+		t.Errorf("Expected stateNum 7, got %v", n)
+	}
+	block <- true
+}
+
+func TestListenerResend(t *testing.T) {
+	sm := newStateManager()
+	ch := make(chan map[string]*string, 10)
+	l := sm.NewListener("resend", func(u map[string]*string) { ch <- u })
+	sm.StateUpdateString("Scoreboard.Team(1).Name", "Home")
+	sm.flush()
+	l.RegisterPaths([]string{"Scoreboard"})
+	<-ch
+
+	l.Resend()
+	if u := <-ch; len(u) != 1 || *u["Scoreboard.Team(1).Name"] != "Home" {
+		t.Errorf("Expected the registered paths again, got %v", u)
+	}
+}
+
+func TestCommandIf(t *testing.T) {
+	sm := newStateManager()
+	sm.RegisterPatternUpdaterString("Scoreboard.Team(*).Name", 0, sm.StateUpdateString)
+	sm.StateUpdateString("Scoreboard.Team(1).Name", "Home")
+	sm.flush()
+	seen := sm.StateNum() - 1
+
+	if err := sm.CommandIf("Set", []string{"Scoreboard.Team(1).Name", "Operator 1"}, seen, nil); err != nil {
+		t.Errorf("CommandIf: %v", err)
+	}
+	err := sm.CommandIf("Set", []string{"Scoreboard.Team(1).Name", "Operator 2"}, seen, nil)
+	if e, ok := err.(*ConflictError); !ok || e.Key != "Scoreboard.Team(1).Name" || *e.Value != "Operator 1" {
+		t.Errorf("CommandIf: expected conflict got %v", err)
+	}
+	if v, _ := sm.StateGetString("Scoreboard.Team(1).Name"); v != "Operator 1" {
+		t.Errorf("Conflicting set was applied: %v", v)
+	}
+	// Changes to keys the caller can't read are not conflicts, and aren't sent back
+	sm.StateUpdateString("Auth.Device(d).Role", "Admin")
+	sm.flush()
+	seen = sm.StateNum() - 1
+	visible := func(k string) bool { return !PatternMatch("Auth", k) }
+	if _, err := sm.CommandIfVisible("Set", []string{"Scoreboard.Team(1).Name", "Operator 3"}, seen, []string{""}, visible); err != nil {
+		t.Errorf("CommandIfVisible: hidden key conflicted: %v", err)
+	}
+	if _, err := sm.CommandIfResult("Set", []string{"Scoreboard.Team(1).Name", "Operator 4"}, seen, []string{"Auth"}); err == nil {
+		t.Errorf("CommandIfResult: expected conflict on Auth")
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	sm := newStateManager()
+	for _, id := range []string{"1", "2"} {
+		sm.RegisterCommandResult("Scoreboard.Team("+id+").AddPenalty", func(data []string) (interface{}, error) {
+			return data[0] + "." + data[1], nil
+		})
+		sm.DescribeCommand("Scoreboard.Team("+id+").AddPenalty", "Adds a penalty",
+			Arg{Name: "SkaterID", Type: TypeString},
+			Arg{Name: "Idx", Type: TypeInt64},
+			Arg{Name: "Codes", Type: TypeString, Optional: true, Variadic: true})
+	}
+
+	result, err := sm.CommandResult("Scoreboard.Team(2).AddPenalty", []string{"s1", "3", "X", "B"})
+	if err != nil || result != "s1.3" {
+		t.Errorf("CommandResult: got %v, %v", result, err)
+	}
+	for _, data := range [][]string{{"s1"}, {"s1", "three"}} {
+		err := sm.Command("Scoreboard.Team(1).AddPenalty", data)
+		if _, ok := err.(*ArgumentError); !ok || ErrorCode(err) != CodeInvalidArguments {
+			t.Errorf("Command %v: expected argument error got %v", data, err)
+		}
+	}
+	if err := sm.Command("Scoreboard.Missing", nil); ErrorCode(err) != CodeNotFound {
+		t.Errorf("Expected %v got %v", CodeNotFound, ErrorCode(err))
+	}
+
+	commands := sm.Commands()
+	if len(commands) != 3 || commands[0].Name != "Scoreboard.Team(1).AddPenalty" || len(commands[0].Args) != 3 || commands[2].Name != "Set" {
+		t.Errorf("Unexpected commands: %+v", commands)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	leagues := newStateManager()
+	leagues.RegisterKey("Leagues.Person(*).Name", TypeString, "Name of the person")
+
+	sm := newStateManager()
+	sm.Share("Leagues", leagues)
+	sm.RegisterPatternUpdaterString("Settings", 0, sm.StateUpdateString)
+	sm.RegisterKey("Settings.*", TypeString, "Settings")
+	for _, id := range []string{"1", "2"} {
+		key := "Scoreboard.Team(" + id + ").Score"
+		sm.RegisterUpdaterInt64(key, 0, func(int64) error { return nil })
+		sm.RegisterKey(key, TypeInt64, "Score of the team").Range(0, 999)
+		sm.RegisterKey("Scoreboard.Team("+id+").JamScore", TypeInt64, "Points scored in the jam")
+	}
+
+	schema := sm.Schema()
+	expected := []struct {
+		pattern  string
+		writable bool
+	}{
+		{"Leagues.Person(*).Name", false},
+		{"Scoreboard.Team(*).JamScore", false},
+		{"Scoreboard.Team(*).Score", true},
+		{"Settings.*", true},
+	}
+	if len(schema) != len(expected) {
+		t.Fatalf("Schema: expected %v keys got %+v", len(expected), schema)
+	}
+	for i, e := range expected {
+		if schema[i].Pattern != e.pattern || schema[i].Writable != e.writable {
+			t.Errorf("Schema[%v]: expected %v (writable %v) got %+v", i, e.pattern, e.writable, schema[i])
+		}
+	}
+	if ks := schema[2]; ks.Min == nil || *ks.Min != 0 || ks.Max == nil || *ks.Max != 999 {
+		t.Errorf("Schema: range not set on %+v", ks)
+	}
+}
+
+func TestPII(t *testing.T) {
+	leagues := newStateManager()
+	leagues.RegisterKey("Leagues.Person(*).LegalName", TypeString, "Legal name of the person").Personal()
+
+	sm := newStateManager()
+	sm.Share("Leagues", leagues)
+	sm.RegisterKey("Scoreboard.Team(*).Skater(*).Name", TypeString, "Name of the skater")
+
+	if !sm.IsPII("Leagues.Person(p1).LegalName") || sm.IsPII("Scoreboard.Team(1).Skater(s1).Name") {
+		t.Error("IsPII did not follow the schema")
+	}
+	if v := sm.Redact("Leagues.Person(p1).LegalName", "Jane Doe"); v != redactedValue {
+		t.Errorf("Redact: got %v", v)
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	sm := newStateManager()
+	sm.RegisterPatternUpdaterString("Settings", 0, sm.StateUpdateString)
+	sm.RegisterKey("Settings.*", TypeString, "Settings").MaxLength(5)
+	sm.RegisterPatternUpdaterString("Scoreboard.Team(*).Lead", 0, sm.StateUpdateString)
+	sm.RegisterKey("Scoreboard.Team(1).Lead", TypeString, "Lead").Enum("Lead", "No", "Lost")
+	sm.RegisterPatternUpdaterString("Scoreboard.Team(*).Color", 0, sm.StateUpdateString)
+	sm.RegisterKey("Scoreboard.Team(1).Color", TypeString, "Color").Match(`#[0-9A-Fa-f]{6}|[A-Za-z]+`)
+	sm.RegisterPatternUpdaterInt64("Scoreboard.Team(*).Score", 0, sm.StateUpdateInt64)
+	sm.RegisterKey("Scoreboard.Team(1).Score", TypeInt64, "Score").AtLeast(0)
+
+	tests := []struct {
+		key, value string
+		valid      bool
+	}{
+		{"Settings.View.Image", "a.jpg", true},
+		{"Settings.View.Image", "ab.jpg", false},
+		{"Scoreboard.Team(2).Lead", "Lost", true},
+		{"Scoreboard.Team(2).Lead", "Maybe", false},
+		{"Scoreboard.Team(2).Color", "#00ff00", true},
+		{"Scoreboard.Team(2).Color", "Dark Blue", false},
+		{"Scoreboard.Team(2).Score", "0", true},
+		{"Scoreboard.Team(2).Score", "-1", false},
+		{"Scoreboard.Team(2).Score", "one", false},
+	}
+	for _, test := range tests {
+		err := sm.StateSet(test.key, test.value)
+		if _, ok := err.(*ConstraintError); ok == test.valid || (test.valid && err != nil) {
+			t.Errorf("StateSet(%v, %v): expected valid %v got %v", test.key, test.value, test.valid, err)
+		}
+	}
+
+	err := sm.stateSetGroup(map[string]string{"Scoreboard.Team(1).Lead": "Lead", "Scoreboard.Team(1).Score": "-5"}, false)
+	if _, ok := err.(*ConstraintError); !ok {
+		t.Errorf("StateSetGroup: expected constraint error got %v", err)
+	}
+	if v, _ := sm.StateGetString("Scoreboard.Team(1).Lead"); v != "Lead" {
+		t.Errorf("StateSetGroup: valid key not set, got %v", v)
+	}
+	if _, err := sm.StateGetString("Scoreboard.Team(1).Score"); err != ErrNotFound {
+		t.Errorf("StateSetGroup: invalid key was set")
+	}
+}
+
+func TestComputed(t *testing.T) {
+	sm := newStateManager()
+	sm.StateUpdateInt64("Scoreboard.Team(1).Score", 5)
+
+	sm.RegisterComputed("Scoreboard.Team(*).JamScore", []string{"Scoreboard.Team(*).Score", "Scoreboard.Team(*).LastScore"}, func(ids []string) interface{} {
+		score, _ := sm.StateGetInt64("Scoreboard.Team(" + ids[0] + ").Score")
+		last, _ := sm.StateGetInt64("Scoreboard.Team(" + ids[0] + ").LastScore")
+		return score - last
+	})
+	err := sm.RegisterComputedTemplate("Custom.Team(*).Label", map[string]string{
+		"Name":     "Scoreboard.Team(*).Name",
+		"JamScore": "Scoreboard.Team(*).JamScore",
+	}, "{{.Name}} +{{.JamScore}}")
+	if err != nil {
+		t.Fatalf("RegisterComputedTemplate: %v", err)
+	}
+	if v, _ := sm.StateGetInt64("Scoreboard.Team(1).JamScore"); v != 5 {
+		t.Errorf("Computed from existing state: expected 5 got %v", v)
+	}
+
+	sm.StateUpdateString("Scoreboard.Team(2).Name", "Away")
+	sm.StateUpdateInt64("Scoreboard.Team(2).Score", 7)
+	sm.StateUpdateInt64("Scoreboard.Team(2).LastScore", 3)
+	sm.recompute()
+	if v, _ := sm.StateGetInt64("Scoreboard.Team(2).JamScore"); v != 4 {
+		t.Errorf("Computed: expected 4 got %v", v)
+	}
+	if v, _ := sm.StateGetString("Custom.Team(2).Label"); v != "Away +4" {
+		t.Errorf("Computed from computed key: expected 'Away +4' got '%v'", v)
+	}
+}
+
+func TestValueTypes(t *testing.T) {
+	sm := newStateManager()
+	sm.RegisterPatternUpdaterFloat64("Test.Float", 0, sm.StateUpdateFloat64)
+	sm.RegisterPatternUpdaterDuration("Test.Duration", 0, sm.StateUpdateDuration)
+	sm.RegisterPatternUpdaterTime("Test.Time", 0, sm.StateUpdateTime)
+	sm.RegisterPatternUpdaterJSON("Test.JSON", 0, func(k string, v json.RawMessage) error { return sm.StateUpdateJSON(k, v) })
+
+	tests := []struct{ key, value, expected string }{
+		{"Test.Float", "2.50", "2.5"},
+		{"Test.Duration", "1500", "1500"},
+		{"Test.Duration", "1m0.25s", "60250"},
+		{"Test.Time", "2016-03-05T10:00:00.123456789Z", "2016-03-05T10:00:00.123456789Z"},
+		{"Test.JSON", `{"colors": ["#000000", "#ffffff"]}`, `{"colors":["#000000","#ffffff"]}`},
+	}
+	for _, test := range tests {
+		if err := sm.StateSet(test.key, test.value); err != nil {
+			t.Errorf("StateSet(%v, %v): %v", test.key, test.value, err)
+			continue
+		}
+		if v, _ := sm.StateGetString(test.key); v != test.expected {
+			t.Errorf("StateSet(%v, %v): expected %v got %v", test.key, test.value, test.expected, v)
+		}
+	}
+
+	if err := sm.StateSet("Test.JSON", "{"); err == nil {
+		t.Errorf("StateSet: invalid JSON accepted")
+	}
+	if v, _ := sm.StateGet("Test.Duration"); v != 60250*time.Millisecond {
+		t.Errorf("StateGet: expected 1m0.25s got %v", v)
+	}
+}
+
+func TestStateSetGroupOrder(t *testing.T) {
+	sm := newStateManager()
+	var order []string
+	sm.RegisterPatternUpdaterString("Test.List(*).Value", 0, func(k, v string) error {
+		order = append(order, v)
+		return nil
+	})
+
+	values := make(map[string]string)
+	for _, i := range []string{"0", "2", "10", "1", "11", "9"} {
+		values["Test.List("+i+").Value"] = i
+	}
+	sm.StateSetGroup(values)
+	expected := []string{"0", "1", "2", "9", "10", "11"}
+	for i := range expected {
+		if i >= len(order) || order[i] != expected[i] {
+			t.Fatalf("StateSetGroup order: expected %v got %v", expected, order)
+		}
+	}
+}
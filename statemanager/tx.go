@@ -0,0 +1,202 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"log"
+	"sort"
+)
+
+// Tx is a transaction on a StateManager, see StateManager.Begin
+type Tx struct {
+	sm   *StateManager
+	mark int
+	err  error
+}
+
+// undo holds a state as it was before it was changed in a transaction
+type undo struct {
+	name string
+	prev state
+}
+
+// RemoveFunc is called with a key removed from the state tree behind its
+// updater's back, see RegisterRemove
+type RemoveFunc func(k string)
+
+type removeHook struct {
+	pm patternMatcher
+	f  RemoveFunc
+}
+
+// RegisterRemove calls f with each key matching pattern that is removed
+// from the state tree by rolling back the transaction that created it, or
+// by replaying a journal, so the object its updater created is removed too
+func (sm *StateManager) RegisterRemove(pattern string, f RemoveFunc) {
+	sm.removeHooks = append(sm.removeHooks, removeHook{pm: newPatternMatcher(pattern), f: f})
+}
+
+// removed calls the functions set with RegisterRemove for k
+func (sm *StateManager) removed(k string) {
+	for _, h := range sm.removeHooks {
+		if h.pm.Matches(k) {
+			h.f(k)
+		}
+	}
+}
+
+// Begin starts a transaction.  Keys set and commands run through the
+// transaction are kept if Commit succeeds.  If any of them fail, the state
+// tree is restored to its values before Begin.  Objects created for keys
+// that no longer exist are removed by the functions set with
+// RegisterRemove, then the updaters of the restored keys are run again so
+// the objects they built match the state.  The statemanager lock must be held
+// from Begin until Commit or Rollback, so listeners never see the state
+// part way through a transaction.  Keys shared from another StateManager
+// are not undone.  Transactions may be nested.
+func (sm *StateManager) Begin() *Tx {
+	tx := &Tx{sm: sm, mark: len(sm.undo)}
+	sm.txMarks = append(sm.txMarks, tx.mark)
+	return tx
+}
+
+// Begin starts a transaction on the Default StateManager, see StateManager.Begin
+func Begin() *Tx { return defaultStateManager.Begin() }
+
+// recordUndo saves s for rollback if a transaction is in progress.  Must be
+// called before s is modified.
+func (sm *StateManager) recordUndo(s *state) {
+	if len(sm.txMarks) > 0 {
+		sm.undo = append(sm.undo, undo{name: s.name, prev: *s})
+	}
+}
+
+func (tx *Tx) fail(err error) error {
+	if err != nil && tx.err == nil {
+		tx.err = err
+	}
+	return err
+}
+
+// Set sets keyName to value, see StateManager.StateSet
+func (tx *Tx) Set(keyName, value string) error {
+	if tx.err != nil {
+		return tx.err
+	}
+	return tx.fail(tx.sm.StateSet(keyName, value))
+}
+
+// SetGroup sets values in groupPriority order, see StateManager.StateSetGroup.
+// Stops at the first error.
+func (tx *Tx) SetGroup(values map[string]string) error {
+	if tx.err != nil {
+		return tx.err
+	}
+	return tx.fail(tx.sm.stateSetGroup(values, true))
+}
+
+// Command runs the command name, see StateManager.Command
+func (tx *Tx) Command(name string, data []string) error {
+	if tx.err != nil {
+		return tx.err
+	}
+	_, err := tx.sm.command(name, data)
+	for _, j := range tx.sm.journals {
+		j.command(name, data, err)
+	}
+	return tx.fail(err)
+}
+
+// Commit ends the transaction.  If anything in the transaction failed it
+// is rolled back and the first error is returned.
+func (tx *Tx) Commit() error {
+	if tx.err != nil {
+		tx.Rollback()
+		return tx.err
+	}
+	tx.end()
+	return nil
+}
+
+// Rollback ends the transaction, restoring the states changed since Begin
+func (tx *Tx) Rollback() {
+	sm := tx.sm
+	restored := make(map[string]*state)
+	for i := len(sm.undo) - 1; i >= tx.mark; i-- {
+		u := sm.undo[i]
+		s, ok := sm.states[u.name]
+		if !ok {
+			s = &state{name: u.name}
+			sm.states[u.name] = s
+		}
+		sm.changed(s)
+		*s = u.prev
+		s.stateNum = sm.stateNum
+		restored[u.name] = s
+	}
+	tx.end()
+
+	values := make(map[string]string)
+	var removed []string
+	for k, s := range restored {
+		if v, e := s.Value(); !e {
+			values[k] = v
+		} else {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(removed)
+	for _, k := range removed {
+		sm.removed(k)
+	}
+	sm.reapply(values)
+}
+
+// reapply runs the updaters of values restored by a rollback, without
+// checking constraints as they held before the transaction.  Keys that
+// fail, such as a position still held by a skater restored later, are
+// retried while others succeed.
+func (sm *StateManager) reapply(values map[string]string) {
+	var keys []string
+	for k := range values {
+		if sm.findStateUpdater(k) != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := sm.findStateUpdater(keys[i]).groupPriority, sm.findStateUpdater(keys[j]).groupPriority
+		if pi != pj {
+			return pi < pj
+		}
+		return keys[i] < keys[j]
+	})
+
+	for len(keys) > 0 {
+		var failed []string
+		var lastErr error
+		for _, k := range keys {
+			if err := sm.findStateUpdater(k).update(k, values[k]); err != nil {
+				failed = append(failed, k)
+				lastErr = err
+			}
+		}
+		if len(failed) == len(keys) {
+			log.Printf("Rollback: Cannot restore %v: %v", failed, lastErr)
+			return
+		}
+		keys = failed
+	}
+}
+
+func (tx *Tx) end() {
+	sm := tx.sm
+	sm.txMarks = sm.txMarks[:len(sm.txMarks)-1]
+	if len(sm.txMarks) == 0 {
+		sm.undo = nil
+	} else {
+		sm.undo = sm.undo[:tx.mark]
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package statemanager
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTxRollback(t *testing.T) {
+	sm := newStateManager()
+	errInvalid := errors.New("Invalid")
+	sm.RegisterPatternUpdaterString("Scoreboard.Team(*).Name", 0, sm.StateUpdateString)
+	sm.RegisterPatternUpdaterInt64("Scoreboard.Team(*).Score", 1, func(k string, v int64) error {
+		if v < 0 {
+			return errInvalid
+		}
+		return sm.StateUpdateInt64(k, v)
+	})
+	sm.StateUpdateString("Scoreboard.Team(1).Name", "Home")
+	sm.flush()
+
+	ch := make(chan map[string]*string, 10)
+	l := sm.NewListener("test", func(u map[string]*string) { ch <- u })
+	l.RegisterPaths([]string{"Scoreboard"})
+	<-ch
+
+	tx := sm.Begin()
+	tx.Set("Scoreboard.Team(1).Name", "Changed")
+	tx.SetGroup(map[string]string{"Scoreboard.Team(2).Name": "Away", "Scoreboard.Team(2).Score": "-1"})
+	if err := tx.Commit(); err != errInvalid {
+		t.Errorf("Commit: expected %v got %v", errInvalid, err)
+	}
+	sm.flush()
+
+	if q := sm.StateQuery("Scoreboard"); len(q) != 1 || q["Scoreboard.Team(1).Name"] != "Home" {
+		t.Errorf("State after rollback: got %v", q)
+	}
+	for k, v := range <-ch {
+		if v != nil && *v != "Home" {
+			t.Errorf("Listener saw intermediate state %v=%v", k, *v)
+		}
+	}
+
+	tx = sm.Begin()
+	tx.Set("Scoreboard.Team(1).Score", "4")
+	if err := tx.Commit(); err != nil {
+		t.Errorf("Commit: %v", err)
+	}
+	if v, _ := sm.StateGetInt64("Scoreboard.Team(1).Score"); v != 4 {
+		t.Errorf("Committed state: expected 4 got %v", v)
+	}
+}
@@ -6,9 +6,12 @@
 package statemanager
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,6 +27,15 @@ type UpdaterBoolFunc func(bool) error
 // UpdaterTimeFunc is a callback type for time updates from the state engine
 type UpdaterTimeFunc func(time.Time) error
 
+// UpdaterFloat64Func is a callback type for float64 updates from the state engine
+type UpdaterFloat64Func func(float64) error
+
+// UpdaterDurationFunc is a callback type for time.Duration updates from the state engine
+type UpdaterDurationFunc func(time.Duration) error
+
+// UpdaterJSONFunc is a callback type for JSON updates from the state engine
+type UpdaterJSONFunc func(json.RawMessage) error
+
 // UpdaterPatternStringFunc is a callback type for string updates from the state engine
 type UpdaterPatternStringFunc func(string, string) error
 
@@ -36,29 +48,29 @@ type UpdaterPatternBoolFunc func(string, bool) error
 // UpdaterPatternTimeFunc is a callback type for time updates from the state engine
 type UpdaterPatternTimeFunc func(string, time.Time) error
 
+// UpdaterPatternFloat64Func is a callback type for float64 updates from the state engine
+type UpdaterPatternFloat64Func func(string, float64) error
+
+// UpdaterPatternDurationFunc is a callback type for time.Duration updates from the state engine
+type UpdaterPatternDurationFunc func(string, time.Duration) error
+
+// UpdaterPatternJSONFunc is a callback type for JSON updates from the state engine
+type UpdaterPatternJSONFunc func(string, json.RawMessage) error
+
 type stateUpdater struct {
 	updater       interface{}
 	name          string
-	pm            patternMatcher
 	groupPriority uint8
 	isPattern     bool
 }
 type stateUpdaterArray []*stateUpdater
 
-var updaters = make(map[string]*stateUpdater)
-
-func findStateUpdater(keyName string) *stateUpdater {
-	updater, ok := updaters[keyName]
+func (sm *StateManager) findStateUpdater(keyName string) *stateUpdater {
+	updater, ok := sm.updaters[keyName]
 	if !ok {
-		// not found, look for pattern match
-		for _, u := range updaters {
-			if u.isPattern {
-				result := u.pm.Matches(keyName)
-				if result {
-					updater = u
-					break
-				}
-			}
+		// not found, use the longest matching pattern
+		if matches := sm.updaterIndex.lookup(keyName); len(matches) > 0 {
+			updater = matches[len(matches)-1].(*stateUpdater)
 		}
 	}
 
@@ -102,30 +114,84 @@ func (su *stateUpdater) update(keyName, value string) error {
 		}
 		return cb(keyName, v)
 	case UpdaterTimeFunc:
-		v, err := time.Parse(time.RFC3339, value)
+		v, err := time.Parse(time.RFC3339Nano, value)
 		if err != nil {
 			return err
 		}
 		return cb(v)
 	case UpdaterPatternTimeFunc:
-		v, err := time.Parse(time.RFC3339, value)
+		v, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return err
+		}
+		return cb(keyName, v)
+	case UpdaterFloat64Func:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		return cb(v)
+	case UpdaterPatternFloat64Func:
+		v, err := strconv.ParseFloat(value, 64)
 		if err != nil {
 			return err
 		}
 		return cb(keyName, v)
+	case UpdaterDurationFunc:
+		v, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		return cb(v)
+	case UpdaterPatternDurationFunc:
+		v, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		return cb(keyName, v)
+	case UpdaterJSONFunc:
+		if !json.Valid([]byte(value)) {
+			return errInvalidJSON
+		}
+		return cb(json.RawMessage(value))
+	case UpdaterPatternJSONFunc:
+		if !json.Valid([]byte(value)) {
+			return errInvalidJSON
+		}
+		return cb(keyName, json.RawMessage(value))
 	}
 	log.Printf("StateSet: Unknown type '%T' for %v", su.updater, keyName)
 	return ErrUnknownType
 }
 
+var errInvalidJSON = errors.New("Invalid JSON")
+
+// parseDuration parses a number of milliseconds, as durations are sent to
+// listeners, or a duration such as "1m30.5s" (see time.ParseDuration)
+func parseDuration(value string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	return time.ParseDuration(value)
+}
+
 // StateSet attempts to update the state to value
 // using keyName to lookup a handler.  It returns an
 // error on failure.
-func StateSet(keyName string, value string) error {
-	su := findStateUpdater(keyName)
+func (sm *StateManager) StateSet(keyName string, value string) error {
+	if s := sm.findShare(keyName); s != nil {
+		s.from.Lock()
+		defer s.from.Unlock()
+		return s.from.StateSet(keyName, value)
+	}
+
+	su := sm.findStateUpdater(keyName)
 	if su == nil {
 		return ErrUpdaterNotFound
 	}
+	if err := sm.validate(keyName, value); err != nil {
+		return err
+	}
 
 	return su.update(keyName, value)
 }
@@ -136,74 +202,235 @@ func StateSet(keyName string, value string) error {
 // of the registered updater to call lower numbers (higher
 // priority) first.  Allows setting things like min/max values
 // before the actual number.
-func StateSetGroup(values map[string]string) {
+func (sm *StateManager) StateSetGroup(values map[string]string) {
+	sm.stateSetGroup(values, false)
+}
+
+// stateSetGroup is StateSetGroup, returning the first error.  If stopOnError
+// is set no more keys are set after an error, otherwise errors are logged.
+func (sm *StateManager) stateSetGroup(values map[string]string, stopOnError bool) error {
 	var u []*stateUpdater
 	um := make(map[*stateUpdater][]string)
+	shared := make(map[*share]map[string]string)
 
-	for keyName := range values {
-		su := findStateUpdater(keyName)
+	for keyName, value := range values {
+		if s := sm.findShare(keyName); s != nil {
+			if shared[s] == nil {
+				shared[s] = make(map[string]string)
+			}
+			shared[s][keyName] = value
+			continue
+		}
+		su := sm.findStateUpdater(keyName)
 		if su != nil {
-			u = append(u, su)
+			if _, ok := um[su]; !ok {
+				u = append(u, su)
+			}
 			um[su] = append(um[su], keyName)
 		}
 	}
 
+	var firstErr error
+	for s, v := range shared {
+		if err := s.stateSetGroup(v, stopOnError); err != nil {
+			if stopOnError {
+				return err
+			}
+			firstErr = err
+		}
+	}
+
 	sort.Sort(stateUpdaterArray(u))
 	for _, su := range u {
-		for _, keyName := range um[su] {
-			err := su.update(keyName, values[keyName])
-			if err != nil {
-				log.Print("StateSetGroup: Cannot set state: ", err)
+		keys := um[su]
+		sort.Slice(keys, func(i, j int) bool { return lessKey(keys[i], keys[j]) })
+		for _, keyName := range keys {
+			err := sm.validate(keyName, values[keyName])
+			if err == nil {
+				err = su.update(keyName, values[keyName])
+			}
+			if err == nil {
+				continue
+			}
+			if stopOnError {
+				return err
+			}
+			log.Print("StateSetGroup: Cannot set state: ", err)
+			if firstErr == nil {
+				firstErr = err
 			}
 		}
 	}
+	return firstErr
 }
 
 // RegisterUpdaterString adds a string updater to the statemanager.
-func RegisterUpdaterString(name string, groupPriority uint8, u UpdaterStringFunc) {
-	updaters[name] = &stateUpdater{updater: u, name: name, groupPriority: groupPriority, pm: newPatternMatcher(name)}
+func (sm *StateManager) RegisterUpdaterString(name string, groupPriority uint8, u UpdaterStringFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority})
 }
 
 // RegisterUpdaterInt64 adds an int64 updater to the statemanager.
-func RegisterUpdaterInt64(name string, groupPriority uint8, u UpdaterInt64Func) {
-	updaters[name] = &stateUpdater{updater: u, name: name, groupPriority: groupPriority, pm: newPatternMatcher(name)}
+func (sm *StateManager) RegisterUpdaterInt64(name string, groupPriority uint8, u UpdaterInt64Func) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority})
 }
 
 // RegisterUpdaterBool adds a bool updater to the statemanager.
-func RegisterUpdaterBool(name string, groupPriority uint8, u UpdaterBoolFunc) {
-	updaters[name] = &stateUpdater{updater: u, name: name, groupPriority: groupPriority, pm: newPatternMatcher(name)}
+func (sm *StateManager) RegisterUpdaterBool(name string, groupPriority uint8, u UpdaterBoolFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority})
 }
 
 // RegisterUpdaterTime adds a time updater to the statemanager.
-func RegisterUpdaterTime(name string, groupPriority uint8, u UpdaterTimeFunc) {
-	updaters[name] = &stateUpdater{updater: u, name: name, groupPriority: groupPriority, pm: newPatternMatcher(name)}
+func (sm *StateManager) RegisterUpdaterTime(name string, groupPriority uint8, u UpdaterTimeFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority})
 }
 
 // RegisterPatternUpdaterString adds a string updater to the statemanager.
-func RegisterPatternUpdaterString(name string, groupPriority uint8, u UpdaterPatternStringFunc) {
-	updaters[name] = &stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true, pm: newPatternMatcher(name)}
+func (sm *StateManager) RegisterPatternUpdaterString(name string, groupPriority uint8, u UpdaterPatternStringFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true})
 }
 
 // RegisterPatternUpdaterInt64 adds an int64 updater to the statemanager.
-func RegisterPatternUpdaterInt64(name string, groupPriority uint8, u UpdaterPatternInt64Func) {
-	updaters[name] = &stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true, pm: newPatternMatcher(name)}
+func (sm *StateManager) RegisterPatternUpdaterInt64(name string, groupPriority uint8, u UpdaterPatternInt64Func) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true})
 }
 
 // RegisterPatternUpdaterBool adds a bool updater to the statemanager.
-func RegisterPatternUpdaterBool(name string, groupPriority uint8, u UpdaterPatternBoolFunc) {
-	updaters[name] = &stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true, pm: newPatternMatcher(name)}
+func (sm *StateManager) RegisterPatternUpdaterBool(name string, groupPriority uint8, u UpdaterPatternBoolFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true})
 }
 
 // RegisterPatternUpdaterTime adds a time updater to the statemanager.
-func RegisterPatternUpdaterTime(name string, groupPriority uint8, u UpdaterPatternTimeFunc) {
-	updaters[name] = &stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true, pm: newPatternMatcher(name)}
+func (sm *StateManager) RegisterPatternUpdaterTime(name string, groupPriority uint8, u UpdaterPatternTimeFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true})
+}
+
+// RegisterUpdaterFloat64 adds a float64 updater to the statemanager.
+func (sm *StateManager) RegisterUpdaterFloat64(name string, groupPriority uint8, u UpdaterFloat64Func) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority})
+}
+
+// RegisterUpdaterDuration adds a time.Duration updater to the statemanager.
+func (sm *StateManager) RegisterUpdaterDuration(name string, groupPriority uint8, u UpdaterDurationFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority})
+}
+
+// RegisterUpdaterJSON adds a JSON updater to the statemanager.
+func (sm *StateManager) RegisterUpdaterJSON(name string, groupPriority uint8, u UpdaterJSONFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority})
+}
+
+// RegisterPatternUpdaterFloat64 adds a float64 updater to the statemanager.
+func (sm *StateManager) RegisterPatternUpdaterFloat64(name string, groupPriority uint8, u UpdaterPatternFloat64Func) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true})
+}
+
+// RegisterPatternUpdaterDuration adds a time.Duration updater to the statemanager.
+func (sm *StateManager) RegisterPatternUpdaterDuration(name string, groupPriority uint8, u UpdaterPatternDurationFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true})
+}
+
+// RegisterPatternUpdaterJSON adds a JSON updater to the statemanager.
+func (sm *StateManager) RegisterPatternUpdaterJSON(name string, groupPriority uint8, u UpdaterPatternJSONFunc) {
+	sm.addUpdater(&stateUpdater{updater: u, name: name, groupPriority: groupPriority, isPattern: true})
 }
 
 // UnregisterUpdater removes an updater from the statemanager.
-func UnregisterUpdater(name string) {
-	delete(updaters, name)
+func (sm *StateManager) UnregisterUpdater(name string) {
+	if su, ok := sm.updaters[name]; ok && su.isPattern {
+		sm.updaterIndex.remove(name, su)
+	}
+	delete(sm.updaters, name)
+}
+
+func (sm *StateManager) addUpdater(su *stateUpdater) {
+	sm.UnregisterUpdater(su.name)
+	sm.updaters[su.name] = su
+	if su.isPattern {
+		sm.updaterIndex.add(su.name, su)
+	}
+}
+
+// StateSet sets keyName on the Default StateManager, see StateManager.StateSet
+func StateSet(keyName string, value string) error {
+	return defaultStateManager.StateSet(keyName, value)
+}
+
+// StateSetGroup sets values on the Default StateManager, see StateManager.StateSetGroup
+func StateSetGroup(values map[string]string) { defaultStateManager.StateSetGroup(values) }
+
+// RegisterUpdaterString adds an updater to the Default StateManager, see StateManager.RegisterUpdaterString
+func RegisterUpdaterString(name string, groupPriority uint8, u UpdaterStringFunc) {
+	defaultStateManager.RegisterUpdaterString(name, groupPriority, u)
+}
+
+// RegisterUpdaterInt64 adds an updater to the Default StateManager, see StateManager.RegisterUpdaterInt64
+func RegisterUpdaterInt64(name string, groupPriority uint8, u UpdaterInt64Func) {
+	defaultStateManager.RegisterUpdaterInt64(name, groupPriority, u)
+}
+
+// RegisterUpdaterBool adds an updater to the Default StateManager, see StateManager.RegisterUpdaterBool
+func RegisterUpdaterBool(name string, groupPriority uint8, u UpdaterBoolFunc) {
+	defaultStateManager.RegisterUpdaterBool(name, groupPriority, u)
+}
+
+// RegisterUpdaterTime adds an updater to the Default StateManager, see StateManager.RegisterUpdaterTime
+func RegisterUpdaterTime(name string, groupPriority uint8, u UpdaterTimeFunc) {
+	defaultStateManager.RegisterUpdaterTime(name, groupPriority, u)
+}
+
+// RegisterPatternUpdaterString adds an updater to the Default StateManager, see StateManager.RegisterPatternUpdaterString
+func RegisterPatternUpdaterString(name string, groupPriority uint8, u UpdaterPatternStringFunc) {
+	defaultStateManager.RegisterPatternUpdaterString(name, groupPriority, u)
+}
+
+// RegisterPatternUpdaterInt64 adds an updater to the Default StateManager, see StateManager.RegisterPatternUpdaterInt64
+func RegisterPatternUpdaterInt64(name string, groupPriority uint8, u UpdaterPatternInt64Func) {
+	defaultStateManager.RegisterPatternUpdaterInt64(name, groupPriority, u)
+}
+
+// RegisterPatternUpdaterBool adds an updater to the Default StateManager, see StateManager.RegisterPatternUpdaterBool
+func RegisterPatternUpdaterBool(name string, groupPriority uint8, u UpdaterPatternBoolFunc) {
+	defaultStateManager.RegisterPatternUpdaterBool(name, groupPriority, u)
+}
+
+// RegisterPatternUpdaterTime adds an updater to the Default StateManager, see StateManager.RegisterPatternUpdaterTime
+func RegisterPatternUpdaterTime(name string, groupPriority uint8, u UpdaterPatternTimeFunc) {
+	defaultStateManager.RegisterPatternUpdaterTime(name, groupPriority, u)
+}
+
+// RegisterUpdaterFloat64 adds an updater to the Default StateManager, see StateManager.RegisterUpdaterFloat64
+func RegisterUpdaterFloat64(name string, groupPriority uint8, u UpdaterFloat64Func) {
+	defaultStateManager.RegisterUpdaterFloat64(name, groupPriority, u)
+}
+
+// RegisterUpdaterDuration adds an updater to the Default StateManager, see StateManager.RegisterUpdaterDuration
+func RegisterUpdaterDuration(name string, groupPriority uint8, u UpdaterDurationFunc) {
+	defaultStateManager.RegisterUpdaterDuration(name, groupPriority, u)
+}
+
+// RegisterUpdaterJSON adds an updater to the Default StateManager, see StateManager.RegisterUpdaterJSON
+func RegisterUpdaterJSON(name string, groupPriority uint8, u UpdaterJSONFunc) {
+	defaultStateManager.RegisterUpdaterJSON(name, groupPriority, u)
+}
+
+// RegisterPatternUpdaterFloat64 adds an updater to the Default StateManager, see StateManager.RegisterPatternUpdaterFloat64
+func RegisterPatternUpdaterFloat64(name string, groupPriority uint8, u UpdaterPatternFloat64Func) {
+	defaultStateManager.RegisterPatternUpdaterFloat64(name, groupPriority, u)
 }
 
+// RegisterPatternUpdaterDuration adds an updater to the Default StateManager, see StateManager.RegisterPatternUpdaterDuration
+func RegisterPatternUpdaterDuration(name string, groupPriority uint8, u UpdaterPatternDurationFunc) {
+	defaultStateManager.RegisterPatternUpdaterDuration(name, groupPriority, u)
+}
+
+// RegisterPatternUpdaterJSON adds an updater to the Default StateManager, see StateManager.RegisterPatternUpdaterJSON
+func RegisterPatternUpdaterJSON(name string, groupPriority uint8, u UpdaterPatternJSONFunc) {
+	defaultStateManager.RegisterPatternUpdaterJSON(name, groupPriority, u)
+}
+
+// UnregisterUpdater removes an updater from the Default StateManager, see StateManager.UnregisterUpdater
+func UnregisterUpdater(name string) { defaultStateManager.UnregisterUpdater(name) }
+
 func (a stateUpdaterArray) Len() int      { return len(a) }
 func (a stateUpdaterArray) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a stateUpdaterArray) Less(i, j int) bool {
@@ -214,3 +441,36 @@ func (a stateUpdaterArray) Less(i, j int) bool {
 	}
 	return a[i].name < a[j].name
 }
+
+// lessKey orders keys comparing runs of digits as numbers, so the
+// elements of a list are set in order (Jam(2) before Jam(10))
+func lessKey(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			na, nb := strings.TrimLeft(a[si:i], "0"), strings.TrimLeft(b[sj:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if a[i] != b[j] {
+			return a[i] < b[j]
+		}
+		i++
+		j++
+	}
+	return len(a)-i < len(b)-j
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
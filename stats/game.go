@@ -0,0 +1,281 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package stats
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rollerderby/crg/statemanager"
+)
+
+const (
+	leadLead = "Lead"
+	leadLost = "Lost"
+)
+
+// game is an archived game, parsed from the Scoreboard.* state
+type game struct {
+	name      string
+	startTime time.Time
+	teams     [2]*gameTeam
+	jams      map[int64]*gameJam
+	snapshots map[string]string
+	officials map[string]*gameOfficial
+}
+
+type gameOfficial struct {
+	name string
+	role string
+}
+
+type gameTeam struct {
+	name         string
+	leagueID     string
+	leagueTeamID string
+	score        int64
+	skaters      map[string]*gameSkater
+}
+
+type gameSkater struct {
+	id        string
+	name      string
+	number    string
+	boxTrips  map[string]int64
+	penalties map[string]string
+}
+
+type gameJam struct {
+	period  int64
+	jam     int64
+	started bool
+	teams   [2]gameJamTeam
+}
+
+type gameJamTeam struct {
+	jammer   string
+	pivot    string
+	blockers map[string]string
+	score    int64
+	lead     string
+}
+
+func loadGame(name string) (*game, error) {
+	state, err := statemanager.ReadState(name)
+	if err != nil {
+		return nil, err
+	}
+	return parseGame(name, state), nil
+}
+
+// shape replaces the ids inside () in k with * so keys can be
+// matched against a single pattern, returning the ids separately.
+// Example
+// Scoreboard.Team(1).Skater(abc123).Name returns
+// "Scoreboard.Team(*).Skater(*).Name", ["1", "abc123"]
+func shape(k string) (string, []string) {
+	ids := statemanager.ParseIDs(k)
+	for _, id := range ids {
+		k = strings.Replace(k, "("+id+")", "(*)", 1)
+	}
+	return k, ids
+}
+
+func parseGame(name string, state map[string]string) *game {
+	g := &game{
+		name:      name,
+		jams:      make(map[int64]*gameJam),
+		snapshots: make(map[string]string),
+		officials: make(map[string]*gameOfficial),
+	}
+	for t := range g.teams {
+		g.teams[t] = &gameTeam{skaters: make(map[string]*gameSkater)}
+	}
+
+	for k, v := range state {
+		s, ids := shape(k)
+		switch {
+		case s == "Scoreboard.MasterClock.StartTime":
+			g.startTime, _ = time.Parse(time.RFC3339Nano, v)
+		case s == "Scoreboard.Snapshot(*).State":
+			g.snapshots[ids[0]] = v
+		case s == "Scoreboard.Official(*).Name" || s == "Scoreboard.Official(*).Role":
+			o, ok := g.officials[ids[0]]
+			if !ok {
+				o = &gameOfficial{}
+				g.officials[ids[0]] = o
+			}
+			if strings.HasSuffix(s, ".Name") {
+				o.name = v
+			} else {
+				o.role = v
+			}
+		case strings.HasPrefix(s, "Scoreboard.Team(*)."):
+			if t := g.team(ids[0]); t != nil {
+				t.parse(strings.TrimPrefix(s, "Scoreboard.Team(*)."), ids[1:], v)
+			}
+		case strings.HasPrefix(s, "Scoreboard.Jam(*)."):
+			idx, err := strconv.ParseInt(ids[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			j, ok := g.jams[idx]
+			if !ok {
+				j = &gameJam{}
+				for t := range j.teams {
+					j.teams[t].blockers = make(map[string]string)
+				}
+				g.jams[idx] = j
+			}
+			j.parse(strings.TrimPrefix(s, "Scoreboard.Jam(*)."), ids[1:], v)
+		}
+	}
+
+	return g
+}
+
+func (g *game) team(id string) *gameTeam {
+	switch id {
+	case "1":
+		return g.teams[0]
+	case "2":
+		return g.teams[1]
+	}
+	return nil
+}
+
+// startedJams returns the jams that were actually played, in order
+func (g *game) startedJams() []*gameJam {
+	var idxs []int64
+	for idx, j := range g.jams {
+		if j.started {
+			idxs = append(idxs, idx)
+		}
+	}
+	sort.Slice(idxs, func(i, j int) bool { return idxs[i] < idxs[j] })
+
+	var ret []*gameJam
+	for _, idx := range idxs {
+		ret = append(ret, g.jams[idx])
+	}
+	return ret
+}
+
+// countSnapshots returns the number of snapshots (clock stoppages) with the given state
+func (g *game) countSnapshots(state string) int64 {
+	n := int64(0)
+	for _, v := range g.snapshots {
+		if v == state {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *gameTeam) parse(s string, ids []string, v string) {
+	switch s {
+	case "Name":
+		t.name = v
+	case "League.ID":
+		t.leagueID = v
+	case "League.TeamID":
+		t.leagueTeamID = v
+	case "Score":
+		t.score, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	if !strings.HasPrefix(s, "Skater(*).") || len(ids) == 0 {
+		return
+	}
+	sk, ok := t.skaters[ids[0]]
+	if !ok {
+		sk = &gameSkater{
+			id:        ids[0],
+			boxTrips:  make(map[string]int64),
+			penalties: make(map[string]string),
+		}
+		t.skaters[ids[0]] = sk
+	}
+	switch s {
+	case "Skater(*).Name":
+		sk.name = v
+	case "Skater(*).Number":
+		sk.number = v
+	case "Skater(*).BoxTrip(*).Duration":
+		sk.boxTrips[ids[1]], _ = strconv.ParseInt(v, 10, 64)
+	case "Skater(*).Penalty(*).Code":
+		sk.penalties[ids[1]] = v
+	}
+}
+
+// key returns the id used to combine a team's totals across games
+func (t *gameTeam) key() string {
+	if t.leagueID != "" && t.leagueTeamID != "" {
+		return t.leagueID + "/" + t.leagueTeamID
+	}
+	return t.name
+}
+
+func (j *gameJam) parse(s string, ids []string, v string) {
+	switch s {
+	case "Started":
+		j.started, _ = strconv.ParseBool(v)
+		return
+	case "Period":
+		j.period, _ = strconv.ParseInt(v, 10, 64)
+		return
+	case "Jam":
+		j.jam, _ = strconv.ParseInt(v, 10, 64)
+		return
+	}
+	if !strings.HasPrefix(s, "Team(*).") || len(ids) == 0 {
+		return
+	}
+	var jt *gameJamTeam
+	switch ids[0] {
+	case "1":
+		jt = &j.teams[0]
+	case "2":
+		jt = &j.teams[1]
+	default:
+		return
+	}
+	switch s {
+	case "Team(*).Jammer":
+		jt.jammer = v
+	case "Team(*).Pivot":
+		jt.pivot = v
+	case "Team(*).Blocker(*)":
+		jt.blockers[ids[1]] = v
+	case "Team(*).Score":
+		jt.score, _ = strconv.ParseInt(v, 10, 64)
+	case "Team(*).Lead":
+		jt.lead = v
+	}
+}
+
+// position returns the position the skater played in the jam, or "" if
+// the skater was not on the track
+func (jt *gameJamTeam) position(id string) string {
+	if jt.jammer == id {
+		return "Jammer"
+	}
+	if jt.pivot == id {
+		return "Pivot"
+	}
+	for _, b := range jt.blockers {
+		if b == id {
+			return "Blocker"
+		}
+	}
+	return ""
+}
+
+func (jt *gameJamTeam) hadLead() bool {
+	return jt.lead == leadLead || jt.lead == leadLost
+}
@@ -0,0 +1,86 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package stats
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rollerderby/crg/auth"
+	"github.com/rollerderby/crg/statemanager"
+)
+
+const dateFormat = "2006-01-02"
+
+// parseFilter builds a Filter from the from, to (both YYYY-MM-DD, inclusive)
+// and league query parameters
+func parseFilter(r *http.Request) (Filter, error) {
+	var f Filter
+	var err error
+
+	q := r.URL.Query()
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.ParseInLocation(dateFormat, v, time.Local); err != nil {
+			return f, err
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.ParseInLocation(dateFormat, v, time.Local); err != nil {
+			return f, err
+		}
+		f.To = f.To.AddDate(0, 0, 1)
+	}
+	f.League = q.Get("league")
+	return f, nil
+}
+
+func handler(w http.ResponseWriter, r *http.Request, result func(*Season) interface{}) {
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	season, err := Load(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result(season)); err != nil {
+		log.Print("stats: Cannot write JSON: ", err)
+	}
+}
+
+func seasonHandler(w http.ResponseWriter, r *http.Request) {
+	handler(w, r, func(s *Season) interface{} { return s })
+}
+
+func teamsHandler(w http.ResponseWriter, r *http.Request) {
+	handler(w, r, func(s *Season) interface{} { return s.Teams })
+}
+
+func personsHandler(w http.ResponseWriter, r *http.Request) {
+	handler(w, r, func(s *Season) interface{} { return s.Persons })
+}
+
+// Initialize registers the stats handlers with the HTTP Server Mux.
+// The season handlers accept the query parameters from and to (YYYY-MM-DD)
+// and league (a league ID).  The report handlers accept the query parameter
+// game (the name of an archived game), without it they report on the game
+// in progress on the StateManager sm.  Only paired devices (see
+// auth.Handler) are served.
+func Initialize(mux *http.ServeMux, sm *statemanager.StateManager) {
+	lg := &liveGame{sm: sm}
+
+	mux.HandleFunc("/stats/season", auth.Handler(seasonHandler, auth.StaffRoles...))
+	mux.HandleFunc("/stats/teams", auth.Handler(teamsHandler, auth.StaffRoles...))
+	mux.HandleFunc("/stats/persons", auth.Handler(personsHandler, auth.StaffRoles...))
+	mux.HandleFunc("/stats/report.html", auth.Handler(lg.reportHTMLHandler, auth.StaffRoles...))
+	mux.HandleFunc("/stats/report.pdf", auth.Handler(lg.reportPDFHandler, auth.StaffRoles...))
+}
@@ -0,0 +1,195 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size and margin in points
+const (
+	pdfWidth  = 595
+	pdfHeight = 842
+	pdfMargin = 36
+)
+
+// pdfPage is a minimal single page PDF writer using the standard
+// Helvetica fonts, so no fonts need to be embedded
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func (p *pdfPage) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%v %.1f Tf %.1f %.1f Td (%v) Tj ET\n", font, size, x, pdfHeight-y, pdfEscape(s))
+}
+
+func (p *pdfPage) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "%.1f %.1f m %.1f %.1f l S\n", x1, pdfHeight-y1, x2, pdfHeight-y2)
+}
+
+// pdfEscape escapes s for use in a PDF string, replacing characters
+// outside of Latin-1 (which the standard fonts cannot show) with '?'
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteByte(' ')
+		case r > 255:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+func (p *pdfPage) writeTo(w io.Writer) error {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %v %v] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pdfWidth, pdfHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %v >>\nstream\n%vendstream", p.content.Len(), p.content.String()),
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%v 0 obj\n%v\nendobj\n", i+1, o)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %v\n0000000000 65535 f \n", len(objects)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %v /Root 1 0 R >>\nstartxref\n%v\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// WritePDF writes the report as a one page A4 PDF.  Skaters and officials
+// that don't fit are counted on a "... N more" line, the HTML report has
+// them all.
+func (r *Report) WritePDF(w io.Writer) error {
+	p := &pdfPage{}
+	y := float64(pdfMargin + 16)
+
+	p.text(pdfMargin, y, 16, true, fmt.Sprintf("%v %v - %v %v", r.Teams[0].Name, r.Teams[0].Score, r.Teams[1].Score, r.Teams[1].Name))
+	y += 14
+	p.text(pdfMargin, y, 9, false, r.Date)
+	y += 18
+
+	// Timeouts and reviews
+	p.text(pdfMargin, y, 10, true, "Timeouts and Official Reviews")
+	y += 12
+	for _, t := range r.Teams {
+		p.text(pdfMargin, y, 8, false, fmt.Sprintf("%v: %v timeouts, %v official reviews", t.Name, t.Timeouts, t.OfficialReviews))
+		y += 10
+	}
+	p.text(pdfMargin, y, 8, false, fmt.Sprintf("Official timeouts: %v", r.Timeouts))
+	y += 16
+
+	// Jam by jam scores, one column per period
+	const rowHeight = 8.5
+	colWidth := float64(pdfWidth-2*pdfMargin) / 2
+	top := y
+	bottom := y
+	for idx, period := range r.Periods {
+		x := pdfMargin + float64(idx%2)*colWidth
+		if idx%2 == 0 && idx > 0 {
+			top = bottom + 8
+		}
+		y = top
+		p.text(x, y, 10, true, fmt.Sprintf("Period %v", period.Period))
+		y += 11
+		p.text(x, y, 7, true, "Jam")
+		p.text(x+30, y, 7, true, truncate(r.Teams[0].Name, 20))
+		p.text(x+130, y, 7, true, truncate(r.Teams[1].Name, 20))
+		p.line(x, y+2, x+colWidth-10, y+2)
+		y += rowHeight + 1
+		for _, j := range period.Jams {
+			p.text(x, y, 7, false, fmt.Sprint(j.Jam))
+			for t := 0; t < 2; t++ {
+				p.text(x+30+float64(t)*100, y, 7, j.Lead[t], fmt.Sprint(j.Score[t]))
+				p.text(x+60+float64(t)*100, y, 7, false, fmt.Sprint(j.Total[t]))
+			}
+			y += rowHeight
+		}
+		if y > bottom {
+			bottom = y
+		}
+	}
+	y = bottom + 10
+
+	// Penalties, one column per team
+	top = y
+	bottom = y
+	for idx, t := range r.Teams {
+		x := pdfMargin + float64(idx)*colWidth
+		y = top
+		p.text(x, y, 10, true, t.Name+" Penalties")
+		y += 11
+		for i, s := range t.Skaters {
+			if y > pdfHeight-pdfMargin-60 {
+				p.text(x, y, 7, false, pdfMore(len(t.Skaters)-i))
+				y += rowHeight
+				break
+			}
+			p.text(x, y, 7, true, truncate(s.Number, 6))
+			p.text(x+25, y, 7, false, truncate(s.Name, 24))
+			p.text(x+120, y, 7, false, truncate(strings.Join(s.Penalties, " "), 32))
+			y += rowHeight
+		}
+		if y > bottom {
+			bottom = y
+		}
+	}
+	y = bottom + 10
+
+	// Officials crew
+	p.text(pdfMargin, y, 10, true, "Officials")
+	y += 11
+	for idx, o := range r.Officials {
+		x := pdfMargin + float64(idx%3)*(colWidth*2/3)
+		if idx%3 == 0 && y+rowHeight > pdfHeight-pdfMargin {
+			p.text(x, y, 7, false, pdfMore(len(r.Officials)-idx))
+			break
+		}
+		p.text(x, y, 7, false, truncate(o.Role+": "+o.Name, 40))
+		if idx%3 == 2 {
+			y += rowHeight
+		}
+	}
+
+	return p.writeTo(w)
+}
+
+func pdfMore(n int) string {
+	return fmt.Sprintf("... %v more", n)
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "."
+}
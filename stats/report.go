@@ -0,0 +1,259 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package stats
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rollerderby/crg/scoreboard"
+	"github.com/rollerderby/crg/statemanager"
+)
+
+// Report is the one page post-game summary for both teams
+type Report struct {
+	Date      string
+	Teams     [2]ReportTeam
+	Periods   []ReportPeriod
+	Timeouts  int64
+	Officials []ReportOfficial
+}
+
+// ReportTeam is one team's part of a Report
+type ReportTeam struct {
+	Name            string
+	Score           int64
+	Timeouts        int64
+	OfficialReviews int64
+	Skaters         []ReportSkater
+}
+
+// ReportSkater lists the penalties of one skater
+type ReportSkater struct {
+	Number    string
+	Name      string
+	Penalties []string
+}
+
+// ReportPeriod holds the jams of a period
+type ReportPeriod struct {
+	Period int64
+	Jams   []ReportJam
+}
+
+// ReportJam holds the jam and running scores for both teams
+type ReportJam struct {
+	Jam   int64
+	Score [2]int64
+	Total [2]int64
+	Lead  [2]bool
+}
+
+// ReportOfficial is a member of the officiating crew
+type ReportOfficial struct {
+	Role string
+	Name string
+}
+
+var errGameNotFound = errors.New("Game Not Found")
+
+// liveGame reports on the game in progress on a StateManager
+type liveGame struct {
+	sm *statemanager.StateManager
+}
+
+// report builds the Report for the archived game name, or the game
+// in progress if name is empty
+func (lg *liveGame) report(name string) (*Report, error) {
+	if name == "" {
+		lg.sm.Lock()
+		state := lg.sm.StateQuery("Scoreboard")
+		lg.sm.Unlock()
+		g := parseGame("", state)
+		return newReport(g), nil
+	}
+	return LoadReport(name)
+}
+
+// LoadReport builds the Report for the archived game name
+func LoadReport(name string) (*Report, error) {
+	if name == "" || filepath.Base(name) != name {
+		return nil, errGameNotFound
+	}
+	g, err := loadGame(filepath.Join(scoreboard.ArchivePath, name))
+	if err != nil {
+		return nil, errGameNotFound
+	}
+	return newReport(g), nil
+}
+
+// lessNumber orders skater numbers by their value, so 3 comes before 12.
+// Numbers with the same value (07 and 7) are ordered as strings, as are
+// numbers that aren't numeric, which come after the numeric ones.
+func lessNumber(a, b string) bool {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil && na != nb:
+		return na < nb
+	case errA == nil && errB != nil:
+		return true
+	case errA != nil && errB == nil:
+		return false
+	}
+	return a < b
+}
+
+func newReport(g *game) *Report {
+	r := &Report{Timeouts: g.countSnapshots("OTO")}
+	if !g.startTime.IsZero() {
+		r.Date = g.startTime.Local().Format("2006-01-02 15:04")
+	}
+
+	for tIdx, t := range g.teams {
+		rt := &r.Teams[tIdx]
+		rt.Name = t.name
+		rt.Score = t.score
+		rt.Timeouts = g.countSnapshots("TTO" + strconv.Itoa(tIdx+1))
+		rt.OfficialReviews = g.countSnapshots("OR" + strconv.Itoa(tIdx+1))
+
+		for _, sk := range t.skaters {
+			rs := ReportSkater{Number: sk.number, Name: sk.name}
+			var idxs []int
+			for idx := range sk.penalties {
+				i, _ := strconv.Atoi(idx)
+				idxs = append(idxs, i)
+			}
+			sort.Ints(idxs)
+			for _, i := range idxs {
+				rs.Penalties = append(rs.Penalties, sk.penalties[strconv.Itoa(i)])
+			}
+			rt.Skaters = append(rt.Skaters, rs)
+		}
+		sort.Slice(rt.Skaters, func(i, j int) bool { return lessNumber(rt.Skaters[i].Number, rt.Skaters[j].Number) })
+	}
+
+	var total [2]int64
+	for _, j := range g.startedJams() {
+		if len(r.Periods) == 0 || r.Periods[len(r.Periods)-1].Period != j.period {
+			r.Periods = append(r.Periods, ReportPeriod{Period: j.period})
+		}
+		rj := ReportJam{Jam: j.jam}
+		for tIdx := range j.teams {
+			total[tIdx] += j.teams[tIdx].score
+			rj.Score[tIdx] = j.teams[tIdx].score
+			rj.Total[tIdx] = total[tIdx]
+			rj.Lead[tIdx] = j.teams[tIdx].lead == leadLead
+		}
+		p := &r.Periods[len(r.Periods)-1]
+		p.Jams = append(p.Jams, rj)
+	}
+
+	for _, o := range g.officials {
+		r.Officials = append(r.Officials, ReportOfficial{Role: o.role, Name: o.name})
+	}
+	sort.Slice(r.Officials, func(i, j int) bool {
+		if r.Officials[i].Role != r.Officials[j].Role {
+			return r.Officials[i].Role < r.Officials[j].Role
+		}
+		return r.Officials[i].Name < r.Officials[j].Name
+	})
+
+	return r
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{(index .Teams 0).Name}} vs {{(index .Teams 1).Name}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 10pt; margin: 1cm; }
+h1 { font-size: 16pt; margin: 0; }
+h2 { font-size: 12pt; margin: 0.5em 0 0.2em 0; }
+table { border-collapse: collapse; margin-bottom: 0.5em; }
+th, td { border: 1px solid #888; padding: 1px 4px; text-align: left; }
+.Columns { display: flex; gap: 1cm; }
+.Lead { font-weight: bold; }
+@page { size: A4; margin: 1cm; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>{{with index .Teams 0}}{{.Name}} {{.Score}}{{end}} - {{with index .Teams 1}}{{.Score}} {{.Name}}{{end}}</h1>
+<div>{{.Date}}</div>
+<h2>Timeouts and Official Reviews</h2>
+<table>
+<tr><th></th><th>Timeouts</th><th>Official Reviews</th></tr>
+{{range .Teams}}<tr><td>{{.Name}}</td><td>{{.Timeouts}}</td><td>{{.OfficialReviews}}</td></tr>
+{{end}}<tr><td>Officials</td><td>{{.Timeouts}}</td><td></td></tr>
+</table>
+<div class="Columns">
+{{range .Periods}}<div>
+<h2>Period {{.Period}}</h2>
+<table>
+<tr><th>Jam</th><th colspan="2">{{(index $.Teams 0).Name}}</th><th colspan="2">{{(index $.Teams 1).Name}}</th></tr>
+{{range .Jams}}{{$j := .}}<tr><td>{{.Jam}}</td>{{range $t, $s := .Score}}<td{{if index $j.Lead $t}} class="Lead"{{end}}>{{$s}}</td><td>{{index $j.Total $t}}</td>{{end}}</tr>
+{{end}}</table>
+</div>
+{{end}}</div>
+<div class="Columns">
+{{range .Teams}}<div>
+<h2>{{.Name}} Penalties</h2>
+<table>
+<tr><th>#</th><th>Skater</th><th>Penalties</th></tr>
+{{range .Skaters}}<tr><td>{{.Number}}</td><td>{{.Name}}</td><td>{{join .Penalties " "}}</td></tr>
+{{end}}</table>
+</div>
+{{end}}</div>
+<h2>Officials</h2>
+<table>
+{{range .Officials}}<tr><td>{{.Role}}</td><td>{{.Name}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a self-contained HTML page
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+func (lg *liveGame) reportHandler(w http.ResponseWriter, r *http.Request, pdf bool) {
+	report, err := lg.report(r.URL.Query().Get("game"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if pdf {
+		w.Header().Set("Content-Type", "application/pdf")
+		err = report.WritePDF(w)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = report.WriteHTML(w)
+	}
+	if err != nil {
+		log.Print("stats: Cannot write report: ", err)
+	}
+}
+
+func (lg *liveGame) reportHTMLHandler(w http.ResponseWriter, r *http.Request) {
+	lg.reportHandler(w, r, false)
+}
+
+func (lg *liveGame) reportPDFHandler(w http.ResponseWriter, r *http.Request) {
+	lg.reportHandler(w, r, true)
+}
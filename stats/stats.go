@@ -0,0 +1,218 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+// Package stats builds season statistics for teams and persons from
+// the games archived by the Scoreboard.Archive command
+package stats
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rollerderby/crg/scoreboard"
+	"github.com/rollerderby/crg/statemanager"
+)
+
+// Filter limits which archived games (and teams) are included in the totals.
+// Zero values do not filter.
+type Filter struct {
+	From   time.Time
+	To     time.Time
+	League string
+}
+
+// Totals are the statistics shared by teams and persons
+type Totals struct {
+	Games         int64            `json:"games"`
+	Jams          int64            `json:"jams"`
+	JamsJammer    int64            `json:"jamsJammer"`
+	JamsPivot     int64            `json:"jamsPivot"`
+	JamsBlocker   int64            `json:"jamsBlocker"`
+	Lead          int64            `json:"lead"`
+	LeadPercent   float64          `json:"leadPercent"`
+	PointsFor     int64            `json:"pointsFor"`
+	PointsAgainst int64            `json:"pointsAgainst"`
+	Penalties     map[string]int64 `json:"penalties"`
+	BoxTrips      int64            `json:"boxTrips"`
+	BoxTime       int64            `json:"boxTime"`
+}
+
+// TeamStats are the season totals for a team.  ID is "leagueID/teamID" for
+// teams loaded from the leagues subsystem, otherwise the team name.
+type TeamStats struct {
+	Totals
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	LeagueID string `json:"leagueId"`
+}
+
+// PersonStats are the season totals for a Person (or skater)
+type PersonStats struct {
+	Totals
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Number string   `json:"number"`
+	Teams  []string `json:"teams"`
+}
+
+// Season holds the totals across all games matching a Filter
+type Season struct {
+	Games   []string       `json:"games"`
+	Teams   []*TeamStats   `json:"teams"`
+	Persons []*PersonStats `json:"persons"`
+}
+
+func newTotals() Totals {
+	return Totals{Penalties: make(map[string]int64)}
+}
+
+// Load reads all archived games and returns the season totals for those matching f
+func Load(f Filter) (*Season, error) {
+	files, err := filepath.Glob(filepath.Join(statemanager.BaseFilePath(), scoreboard.ArchivePath, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var games []*game
+	for _, file := range files {
+		g, err := loadGame(filepath.Join(scoreboard.ArchivePath, strings.TrimSuffix(filepath.Base(file), ".json")))
+		if err != nil {
+			log.Printf("stats: Cannot load %v: %v", file, err)
+			continue
+		}
+		games = append(games, g)
+	}
+	return aggregate(games, f), nil
+}
+
+func (f Filter) includeGame(g *game) bool {
+	if !f.From.IsZero() && g.startTime.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !g.startTime.Before(f.To) {
+		return false
+	}
+	if f.League != "" {
+		return f.includeTeam(g.teams[0]) || f.includeTeam(g.teams[1])
+	}
+	return true
+}
+
+func (f Filter) includeTeam(t *gameTeam) bool {
+	return f.League == "" || t.leagueID == f.League
+}
+
+func aggregate(games []*game, f Filter) *Season {
+	season := &Season{}
+	teams := make(map[string]*TeamStats)
+	persons := make(map[string]*PersonStats)
+
+	for _, g := range games {
+		if !f.includeGame(g) {
+			continue
+		}
+		season.Games = append(season.Games, filepath.Base(g.name))
+		jams := g.startedJams()
+
+		for tIdx, t := range g.teams {
+			if !f.includeTeam(t) {
+				continue
+			}
+			oIdx := 1 - tIdx
+
+			ts, ok := teams[t.key()]
+			if !ok {
+				ts = &TeamStats{Totals: newTotals(), ID: t.key(), LeagueID: t.leagueID}
+				teams[t.key()] = ts
+			}
+			ts.Name = t.name
+			ts.Games++
+			for _, j := range jams {
+				ts.Jams++
+				ts.PointsFor += j.teams[tIdx].score
+				ts.PointsAgainst += j.teams[oIdx].score
+				if j.teams[tIdx].hadLead() {
+					ts.Lead++
+				}
+			}
+
+			for _, sk := range t.skaters {
+				ps, ok := persons[sk.id]
+				if !ok {
+					ps = &PersonStats{Totals: newTotals(), ID: sk.id}
+					persons[sk.id] = ps
+				}
+				ps.Name = sk.name
+				ps.Number = sk.number
+				ps.addTeam(t.key())
+				ps.Games++
+
+				for _, j := range jams {
+					jt := j.teams[tIdx]
+					switch jt.position(sk.id) {
+					case "Jammer":
+						ps.JamsJammer++
+						if jt.hadLead() {
+							ps.Lead++
+						}
+					case "Pivot":
+						ps.JamsPivot++
+					case "Blocker":
+						ps.JamsBlocker++
+					default:
+						continue
+					}
+					ps.Jams++
+					ps.PointsFor += jt.score
+					ps.PointsAgainst += j.teams[oIdx].score
+				}
+
+				for _, d := range sk.boxTrips {
+					ps.BoxTrips++
+					ps.BoxTime += d
+					ts.BoxTrips++
+					ts.BoxTime += d
+				}
+				for _, code := range sk.penalties {
+					ps.Penalties[code]++
+					ts.Penalties[code]++
+				}
+			}
+		}
+	}
+
+	for _, ts := range teams {
+		ts.LeadPercent = percent(ts.Lead, ts.Jams)
+		season.Teams = append(season.Teams, ts)
+	}
+	for _, ps := range persons {
+		ps.LeadPercent = percent(ps.Lead, ps.JamsJammer)
+		season.Persons = append(season.Persons, ps)
+	}
+	sort.Slice(season.Teams, func(i, j int) bool { return season.Teams[i].ID < season.Teams[j].ID })
+	sort.Slice(season.Persons, func(i, j int) bool { return season.Persons[i].ID < season.Persons[j].ID })
+
+	return season
+}
+
+func (ps *PersonStats) addTeam(id string) {
+	for _, t := range ps.Teams {
+		if t == id {
+			return
+		}
+	}
+	ps.Teams = append(ps.Teams, id)
+}
+
+func percent(n, d int64) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(d)
+}
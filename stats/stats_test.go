@@ -0,0 +1,152 @@
+// Copyright 2015-2016 The CRG Authors (see AUTHORS file).
+// All rights reserved.  Use of this source code is
+// governed by a GPL-style license that can be found
+// in the LICENSE file.
+
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+var testGame = map[string]string{
+	"Scoreboard.MasterClock.StartTime":                  "2016-03-05T18:00:00Z",
+	"Scoreboard.Team(1).Name":                           "Home",
+	"Scoreboard.Team(1).League.ID":                      "l1",
+	"Scoreboard.Team(1).League.TeamID":                  "a",
+	"Scoreboard.Team(2).Name":                           "Away",
+	"Scoreboard.Team(1).Skater(p1).Name":                "Smash Lee",
+	"Scoreboard.Team(1).Skater(p1).Number":              "12",
+	"Scoreboard.Team(1).Skater(p1).BoxTrip(0).Duration": "30000",
+	"Scoreboard.Team(1).Skater(p1).Penalty(0).Code":     "X",
+	"Scoreboard.Team(1).Skater(p1).Penalty(1).Code":     "X",
+	"Scoreboard.Team(1).Skater(p2).Name":                "Block Ness",
+	"Scoreboard.Team(1).Skater(p2).Number":              "3",
+	"Scoreboard.Team(2).Skater(p3).Name":                "Jam Ham",
+	"Scoreboard.Team(2).Skater(p3).Penalty(0).Code":     "B",
+	"Scoreboard.Jam(0).Started":                         "true",
+	"Scoreboard.Jam(0).Team(1).Jammer":                  "p1",
+	"Scoreboard.Jam(0).Team(1).Blocker(0)":              "p2",
+	"Scoreboard.Jam(0).Team(1).Score":                   "4",
+	"Scoreboard.Jam(0).Team(1).Lead":                    "Lead",
+	"Scoreboard.Jam(0).Team(2).Jammer":                  "p3",
+	"Scoreboard.Jam(0).Team(2).Score":                   "1",
+	"Scoreboard.Jam(1).Started":                         "true",
+	"Scoreboard.Jam(1).Team(1).Pivot":                   "p2",
+	"Scoreboard.Jam(1).Team(1).Score":                   "0",
+	"Scoreboard.Jam(1).Team(2).Jammer":                  "p3",
+	"Scoreboard.Jam(1).Team(2).Score":                   "5",
+	"Scoreboard.Jam(1).Team(2).Lead":                    "Lost",
+	"Scoreboard.Jam(2).Started":                         "false",
+	"Scoreboard.Jam(2).Team(1).Jammer":                  "p1",
+}
+
+func TestAggregate(t *testing.T) {
+	g := parseGame("test", testGame)
+	s := aggregate([]*game{g}, Filter{})
+
+	if len(s.Teams) != 2 || s.Teams[1].ID != "l1/a" {
+		t.Fatalf("Unexpected teams %+v", s.Teams)
+	}
+	home := s.Teams[1]
+	if home.Jams != 2 || home.PointsFor != 4 || home.PointsAgainst != 6 || home.Lead != 1 || home.LeadPercent != 50 {
+		t.Errorf("Unexpected home team totals %+v", home.Totals)
+	}
+	if home.Penalties["X"] != 2 || home.BoxTrips != 1 || home.BoxTime != 30000 {
+		t.Errorf("Unexpected home team penalties %+v", home.Totals)
+	}
+
+	p := map[string]*PersonStats{}
+	for _, ps := range s.Persons {
+		p[ps.ID] = ps
+	}
+	if p["p1"].Jams != 1 || p["p1"].JamsJammer != 1 || p["p1"].LeadPercent != 100 || p["p1"].PointsFor != 4 {
+		t.Errorf("Unexpected p1 totals %+v", p["p1"].Totals)
+	}
+	if p["p2"].Jams != 2 || p["p2"].JamsBlocker != 1 || p["p2"].JamsPivot != 1 || p["p2"].PointsAgainst != 6 {
+		t.Errorf("Unexpected p2 totals %+v", p["p2"].Totals)
+	}
+	if p["p3"].JamsJammer != 2 || p["p3"].Lead != 1 || p["p3"].Penalties["B"] != 1 {
+		t.Errorf("Unexpected p3 totals %+v", p["p3"].Totals)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	g := parseGame("test", testGame)
+
+	cases := []struct {
+		f     Filter
+		games int
+		teams int
+	}{
+		{Filter{}, 1, 2},
+		{Filter{League: "l1"}, 1, 1},
+		{Filter{League: "l2"}, 0, 0},
+		{Filter{From: time.Date(2016, 3, 6, 0, 0, 0, 0, time.UTC)}, 0, 0},
+		{Filter{To: time.Date(2016, 3, 6, 0, 0, 0, 0, time.UTC)}, 1, 2},
+	}
+	for _, c := range cases {
+		s := aggregate([]*game{g}, c.f)
+		if len(s.Games) != c.games || len(s.Teams) != c.teams {
+			t.Errorf("Filter %+v expected %v games %v teams, got %v games %v teams", c.f, c.games, c.teams, len(s.Games), len(s.Teams))
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := newReport(parseGame("test", testGame))
+
+	if r.Teams[0].Score != 0 || len(r.Periods) != 1 || len(r.Periods[0].Jams) != 2 {
+		t.Fatalf("Unexpected report %+v", r)
+	}
+	if j := r.Periods[0].Jams[1]; j.Total[0] != 4 || j.Total[1] != 6 || !r.Periods[0].Jams[0].Lead[0] {
+		t.Errorf("Unexpected jam totals %+v", r.Periods[0].Jams)
+	}
+	if s := r.Teams[0].Skaters[1]; s.Number != "12" || len(s.Penalties) != 2 {
+		t.Errorf("Unexpected skater penalties %+v", r.Teams[0].Skaters)
+	}
+
+	var html, pdf bytes.Buffer
+	if err := r.WriteHTML(&html); err != nil {
+		t.Errorf("WriteHTML returned %v", err)
+	}
+	if err := r.WritePDF(&pdf); err != nil {
+		t.Errorf("WritePDF returned %v", err)
+	}
+	if !bytes.HasPrefix(pdf.Bytes(), []byte("%PDF-")) || !bytes.HasSuffix(pdf.Bytes(), []byte("%%EOF\n")) {
+		t.Errorf("WritePDF did not produce a PDF")
+	}
+}
+
+func TestReportPDFOverflow(t *testing.T) {
+	r := newReport(parseGame("test", testGame))
+	for i := 0; i < 100; i++ {
+		r.Teams[0].Skaters = append(r.Teams[0].Skaters, ReportSkater{Number: fmt.Sprint(i), Name: "Extra"})
+		r.Officials = append(r.Officials, ReportOfficial{Role: "NSO", Name: "Extra"})
+	}
+
+	// Skaters and officials that don't fit on the page are counted, not dropped silently
+	var pdf bytes.Buffer
+	if err := r.WritePDF(&pdf); err != nil {
+		t.Fatalf("WritePDF returned %v", err)
+	}
+	if n := bytes.Count(pdf.Bytes(), []byte(" more)")); n != 2 {
+		t.Errorf("Expected 2 overflow lines, got %v", n)
+	}
+}
+
+func TestLessNumber(t *testing.T) {
+	numbers := []string{"12", "a1", "3", "", "03", "100"}
+	sort.Slice(numbers, func(i, j int) bool { return lessNumber(numbers[i], numbers[j]) })
+	expected := []string{"03", "3", "12", "100", "", "a1"}
+	for i := range expected {
+		if numbers[i] != expected[i] {
+			t.Errorf("Expected %v got %v", expected, numbers)
+			break
+		}
+	}
+}
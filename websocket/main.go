@@ -6,16 +6,26 @@
 package websocket
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	ws "github.com/gorilla/websocket"
+	"github.com/rollerderby/crg/auth"
 	"github.com/rollerderby/crg/statemanager"
 	"github.com/satori/go.uuid"
 )
 
+// writeWait is how long a write to the client may take before the client is dropped
+const writeWait = 10 * time.Second
+
+var errNoToken = errors.New("No Token")
+var errNoPIN = errors.New("No PIN")
+
 var upgrader = ws.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -23,19 +33,22 @@ var upgrader = ws.Upgrader{
 
 type connection struct {
 	sync.Mutex
+	sm       *statemanager.StateManager
 	conn     *ws.Conn
 	paths    []string
 	ch       chan map[string]*string
 	state    map[string]*string
 	listener *statemanager.Listener
+	device   string
 }
 
-func newConnection(conn *ws.Conn) *connection {
+func newConnection(sm *statemanager.StateManager, conn *ws.Conn) *connection {
 	c := &connection{
+		sm:   sm,
 		conn: conn,
 		ch:   make(chan map[string]*string, 10),
 	}
-	c.listener = statemanager.NewListener(fmt.Sprintf("websocket(%v)", conn.RemoteAddr()), c.processUpdates)
+	c.listener = sm.NewListener(fmt.Sprintf("websocket(%v)", conn.RemoteAddr()), c.processUpdates)
 
 	return c
 }
@@ -59,6 +72,36 @@ func (c *connection) Run() {
 		switch cmd.Action {
 		case "Register":
 			c.listener.RegisterPaths(cmd.Data)
+		case "Authenticate":
+			// Clients send the token they were given by Pair before
+			// registering, until then they are viewers
+			if len(cmd.Data) < 1 {
+				c.sendResult(cmd, nil, errNoToken)
+				break
+			}
+			device, err := auth.Authenticate(cmd.Data[0])
+			if err != nil {
+				c.sendResult(cmd, nil, err)
+				break
+			}
+			c.setDevice(device)
+			c.sendResult(cmd, auth.DeviceRole(device).Name, nil)
+			c.listener.Resend()
+		case "Pair":
+			if len(cmd.Data) < 1 {
+				c.sendResult(cmd, nil, errNoPIN)
+				break
+			}
+			client, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+			device, token, err := auth.Pair(cmd.Data[0], client)
+			if err != nil {
+				log.Printf("Cannot pair %v: %v", c.conn.RemoteAddr(), err)
+				c.sendResult(cmd, nil, err)
+				break
+			}
+			c.setDevice(device)
+			c.sendResult(cmd, pairResult{Token: token, Role: auth.DeviceRole(device).Name}, nil)
+			c.listener.Resend()
 		case "NewObject":
 			u := uuid.NewV4().String()
 			fields := make(map[string]string)
@@ -66,22 +109,84 @@ func (c *connection) Run() {
 				k := fmt.Sprintf("%v(%v).%v", cmd.Field, u, f)
 				fields[k] = v
 			}
+			if err := c.checkWrite(fields); err != nil {
+				c.sendResult(cmd, nil, err)
+				break
+			}
 
-			statemanager.Lock()
-			statemanager.StateSetGroup(fields)
-			statemanager.Unlock()
+			c.sm.Lock()
+			tx := c.sm.Begin()
+			tx.SetGroup(fields)
+			err := tx.Commit()
+			c.sm.Unlock()
+			if err != nil {
+				log.Printf("Cannot create %v: %v", cmd.Field, err)
+				c.sendResult(cmd, nil, err)
+			} else {
+				c.sendResult(cmd, fmt.Sprintf("%v(%v)", cmd.Field, u), nil)
+			}
 		default:
-			// Try to send a command through the statemanager
-			err := statemanager.Command(cmd.Action, cmd.Data)
+			// Try to send a command through the statemanager if the client's
+			// role allows it, checking for conflicting changes to keys the
+			// client can read if it sent the stateNum it last saw
+			var result interface{}
+			r := c.role()
+			err := r.CheckCommand(cmd.Action, cmd.Data)
+			if err == nil {
+				if cmd.StateNum != 0 {
+					visible := func(k string) bool { return r.CanSee(k, c.sm.IsPII) }
+					result, err = c.sm.CommandIfVisible(cmd.Action, cmd.Data, cmd.StateNum, cmd.Keys, visible)
+				} else {
+					result, err = c.sm.CommandResult(cmd.Action, cmd.Data)
+				}
+			}
 			if err != nil {
 				log.Print("Error processing command: ", err)
 			}
-			log.Printf("cmd: %+v  returned error: %v", cmd, err)
+			c.sendResult(cmd, result, err)
+			log.Printf("cmd: %+v  returned error: %v", c.redact(cmd), err)
 		}
 
 	}
 }
 
+func (c *connection) setDevice(device string) {
+	c.Lock()
+	defer c.Unlock()
+	c.device = device
+}
+
+// role returns the role of the device the client authenticated as
+func (c *connection) role() *auth.Role {
+	c.Lock()
+	device := c.device
+	c.Unlock()
+	return auth.DeviceRole(device)
+}
+
+// checkWrite returns an error if the client may not set all of keys
+func (c *connection) checkWrite(keys map[string]string) error {
+	r := c.role()
+	for k := range keys {
+		if !r.CanWrite(k) {
+			return &auth.PermissionError{Role: r.Name, Action: "set " + k}
+		}
+	}
+	return nil
+}
+
+// redact returns a copy of cmd that is safe to log, with values set on
+// personal keys masked
+func (c *connection) redact(cmd command) command {
+	if cmd.Action != "Set" || len(cmd.Data) < 2 {
+		return cmd
+	}
+	c.sm.Lock()
+	defer c.sm.Unlock()
+	cmd.Data = []string{cmd.Data[0], c.sm.Redact(cmd.Data[0], cmd.Data[1])}
+	return cmd
+}
+
 func (c *connection) requestUpdates(paths []string) {
 	c.Lock()
 	defer c.Unlock()
@@ -105,7 +210,23 @@ func (c *connection) processUpdates(s map[string]*string) {
 	c.Lock()
 	defer c.Unlock()
 
-	err := c.conn.WriteJSON(state{State: s})
+	if s == nil {
+		// The listener was disconnected for falling behind
+		c.conn.Close()
+		return
+	}
+
+	// Only send the keys the client's role may read
+	r := auth.DeviceRole(c.device)
+	c.sm.Lock()
+	s = r.Filter(s, c.sm.IsPII)
+	c.sm.Unlock()
+	if len(s) == 0 {
+		return
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	err := c.conn.WriteJSON(state{State: s, StateNum: c.listener.StateNum()})
 	if err != nil {
 		log.Print("Cannot send JSON to client: ", err)
 		c.Close()
@@ -114,18 +235,47 @@ func (c *connection) processUpdates(s map[string]*string) {
 	return
 }
 
-func wsHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println(err)
+// sendResult sends the result of cmd to the client.  Clients that don't
+// send an ID with their commands are only told about errors.
+func (c *connection) sendResult(cmd command, result interface{}, err error) {
+	if cmd.ID == 0 && err == nil {
 		return
 	}
 
-	c := newConnection(conn)
-	c.Run()
+	c.Lock()
+	defer c.Unlock()
+
+	ce := commandResult{ID: cmd.ID, Action: cmd.Action, Result: result}
+	if err != nil {
+		ce.Error = err.Error()
+		ce.Code = statemanager.ErrorCode(err)
+	}
+	switch e := err.(type) {
+	case *statemanager.ConflictError:
+		ce.Conflict = &conflict{Key: e.Key, Value: e.Value, StateNum: e.StateNum}
+	case *statemanager.ConstraintError:
+		ce.Constraint = &constraint{Key: e.Key, Value: e.Value, Reason: e.Reason}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.conn.WriteJSON(ce); err != nil {
+		log.Print("Cannot send JSON to client: ", err)
+	}
+}
+
+func wsHandler(sm *statemanager.StateManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		c := newConnection(sm, conn)
+		c.Run()
+	}
 }
 
-// Initialize registers the websocket with the HTTP Server Mux
-func Initialize(mux *http.ServeMux) {
-	mux.HandleFunc("/ws", wsHandler)
+// Initialize registers the websocket for the StateManager sm with the HTTP Server Mux
+func Initialize(mux *http.ServeMux, sm *statemanager.StateManager) {
+	mux.HandleFunc("/ws", wsHandler(sm))
 }
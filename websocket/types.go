@@ -10,8 +10,39 @@ type command struct {
 	Data      []string          `json:"data"`
 	Field     string            `json:"field"`
 	FieldData map[string]string `json:"fieldData"`
+	StateNum  uint64            `json:"stateNum"`
+	Keys      []string          `json:"keys"`
+	ID        uint64            `json:"id"`
 }
 
 type state struct {
-	State map[string]*string `json:"state"`
+	State    map[string]*string `json:"state"`
+	StateNum uint64             `json:"stateNum"`
+}
+
+type commandResult struct {
+	ID         uint64      `json:"id,omitempty"`
+	Action     string      `json:"action"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Code       string      `json:"code,omitempty"`
+	Conflict   *conflict   `json:"conflict,omitempty"`
+	Constraint *constraint `json:"constraint,omitempty"`
+}
+
+type pairResult struct {
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+type conflict struct {
+	Key      string  `json:"key"`
+	Value    *string `json:"value"`
+	StateNum uint64  `json:"stateNum"`
+}
+
+type constraint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
 }